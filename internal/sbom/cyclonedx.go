@@ -47,7 +47,12 @@ func GenerateImageCycloneDX(mod []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	return GenerateImageCycloneDXFromBuildInfo(bi)
+}
 
+// GenerateImageCycloneDXFromBuildInfo is like GenerateImageCycloneDX, but
+// takes build info rather than the output of `go version -m`.
+func GenerateImageCycloneDXFromBuildInfo(bi *debug.BuildInfo) ([]byte, error) {
 	doc := document{
 		BOMFormat:   "CycloneDX",
 		SpecVersion: "1.4",
@@ -0,0 +1,89 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sort"
+)
+
+// goListModule is the subset of the module information reported by
+// `go list -json` used here.
+type goListModule struct {
+	Path    string
+	Version string
+	Main    bool
+	Replace *goListModule
+}
+
+// goListPackage is the subset of the package information reported by
+// `go list -json` used here.
+type goListPackage struct {
+	ImportPath string
+	Module     *goListModule
+}
+
+// BuildInfoFromGoList returns the build info that the binary of the last
+// package in the output of `go list -json -deps` would have, i.e. its main
+// module and the modules of all the packages it depends on.
+func BuildInfoFromGoList(b []byte) (*debug.BuildInfo, error) {
+	var pkgs []goListPackage
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for {
+		var pkg goListPackage
+		if err := dec.Decode(&pkg); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("parsing go list output: %w", err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	if len(pkgs) == 0 {
+		return nil, errors.New("go list reported no packages")
+	}
+
+	// With -deps, packages are listed after their dependencies.
+	main := pkgs[len(pkgs)-1]
+	bi := &debug.BuildInfo{Path: main.ImportPath}
+	if main.Module != nil {
+		// This is what `go version -m` reports for binaries built from
+		// a local checkout.
+		bi.Main = debug.Module{Path: main.Module.Path, Version: "(devel)"}
+	}
+
+	seen := map[string]bool{}
+	for _, pkg := range pkgs {
+		mod := pkg.Module
+		// Standard library packages have no module.
+		if mod == nil || mod.Main || seen[mod.Path] {
+			continue
+		}
+		seen[mod.Path] = true
+		dep := &debug.Module{Path: mod.Path, Version: mod.Version}
+		if mod.Replace != nil {
+			dep.Replace = &debug.Module{Path: mod.Replace.Path, Version: mod.Replace.Version}
+		}
+		bi.Deps = append(bi.Deps, dep)
+	}
+	sort.Slice(bi.Deps, func(i, j int) bool {
+		return bi.Deps[i].Path < bi.Deps[j].Path
+	})
+	return bi, nil
+}
@@ -64,7 +64,12 @@ func GenerateImageSPDX(koVersion string, mod []byte, img oci.SignedImage) ([]byt
 	if err != nil {
 		return nil, err
 	}
+	return GenerateImageSPDXFromBuildInfo(koVersion, bi, img)
+}
 
+// GenerateImageSPDXFromBuildInfo is like GenerateImageSPDX, but takes the
+// build info of the binary in img rather than the output of `go version -m`.
+func GenerateImageSPDXFromBuildInfo(koVersion string, bi *debug.BuildInfo, img v1.Image) ([]byte, error) {
 	imgDigest, err := img.Digest()
 	if err != nil {
 		return nil, err
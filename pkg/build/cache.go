@@ -42,13 +42,16 @@ type layerCache struct {
 
 type layerFactory func() (v1.Layer, error)
 
-func (c *layerCache) get(ctx context.Context, file string, miss layerFactory) (v1.Layer, error) {
+// get returns the layer that miss builds out of the binary file, which is
+// placed at appPath in the layer. Layers are cached by the build ID of file
+// and appPath, as both go into the layer.
+func (c *layerCache) get(ctx context.Context, file, appPath string, miss layerFactory) (v1.Layer, error) {
 	if os.Getenv("KOCACHE") == "" {
 		return miss()
 	}
 
 	// Cache hit.
-	if diffid, desc, err := c.getMeta(ctx, file); err != nil {
+	if diffid, desc, err := c.getMeta(ctx, file, appPath); err != nil {
 		logs.Debug.Printf("getMeta(%q): %v", file, err)
 	} else {
 		return &lazyLayer{
@@ -63,13 +66,13 @@ func (c *layerCache) get(ctx context.Context, file string, miss layerFactory) (v
 	if err != nil {
 		return nil, fmt.Errorf("miss(%q): %w", file, err)
 	}
-	if err := c.put(ctx, file, layer); err != nil {
+	if err := c.put(ctx, file, appPath, layer); err != nil {
 		log.Printf("failed to cache metadata %s: %v", file, err)
 	}
 	return layer, nil
 }
 
-func (c *layerCache) getMeta(ctx context.Context, file string) (*v1.Hash, *v1.Descriptor, error) {
+func (c *layerCache) getMeta(ctx context.Context, file, appPath string) (*v1.Hash, *v1.Descriptor, error) {
 	buildid, err := getBuildID(ctx, file)
 	if err != nil {
 		return nil, nil, err
@@ -78,6 +81,7 @@ func (c *layerCache) getMeta(ctx context.Context, file string) (*v1.Hash, *v1.De
 	if buildid == "" {
 		return nil, nil, fmt.Errorf("no buildid for %q", file)
 	}
+	key := layerKey(buildid, appPath)
 
 	// TODO: Implement better per-file locking.
 	c.Lock()
@@ -92,9 +96,9 @@ func (c *layerCache) getMeta(ctx context.Context, file string) (*v1.Hash, *v1.De
 		return nil, nil, err
 	}
 
-	diffid, ok := btod[buildid]
+	diffid, ok := btod[key]
 	if !ok {
-		return nil, nil, fmt.Errorf("no diffid for %q", buildid)
+		return nil, nil, fmt.Errorf("no diffid for %q", key)
 	}
 
 	desc, ok := dtod[diffid]
@@ -106,7 +110,7 @@ func (c *layerCache) getMeta(ctx context.Context, file string) (*v1.Hash, *v1.De
 }
 
 // Compute new layer metadata and cache it in-mem and on-disk.
-func (c *layerCache) put(ctx context.Context, file string, layer v1.Layer) error {
+func (c *layerCache) put(ctx context.Context, file, appPath string, layer v1.Layer) error {
 	buildid, err := getBuildID(ctx, file)
 	if err != nil {
 		return err
@@ -126,7 +130,7 @@ func (c *layerCache) put(ctx context.Context, file string, layer v1.Layer) error
 	if !ok {
 		btod = buildIDToDiffID{}
 	}
-	btod[buildid] = diffid
+	btod[layerKey(buildid, appPath)] = diffid
 
 	dtod, ok := c.diffToDesc[file]
 	if !ok {
@@ -161,6 +165,11 @@ func (c *layerCache) put(ctx context.Context, file string, layer v1.Layer) error
 	return enc.Encode(&dtod)
 }
 
+// layerKey is the key of the layer holding the binary with buildid at appPath.
+func layerKey(buildid, appPath string) string {
+	return buildid + " " + appPath
+}
+
 func (c *layerCache) readDiffToDesc(file string) (diffIDToDescriptor, error) {
 	if dtod, ok := c.diffToDesc[file]; ok {
 		return dtod, nil
@@ -14,7 +14,10 @@
 
 package build
 
-import "strings"
+import (
+	"path/filepath"
+	"strings"
+)
 
 // Note: The structs, types, and functions are based upon GoReleaser build
 // configuration to have a loosely compatible YAML configuration:
@@ -56,6 +59,15 @@ func (a *FlagArray) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// FileEntry is a file to add to an image.
+type FileEntry struct {
+	// Src is the path of the file on the host, relative to the directory of
+	// the build config unless absolute. A directory is added recursively.
+	Src string `yaml:"src"`
+	// Dst is the absolute path of the file in the image.
+	Dst string `yaml:"dst"`
+}
+
 // Config contains the build configuration section. The name was changed from
 // the original GoReleaser name to match better with the ko naming.
 //
@@ -65,6 +77,12 @@ type Config struct {
 	// ID only serves as an identifier internally
 	ID string `yaml:",omitempty"`
 
+	// WorkingDirectory overrides the global working directory for this
+	// build, e.g. to build a service under another module root of a monorepo.
+	// Dir is relative to it. A relative path is relative to the global working
+	// directory.
+	WorkingDirectory string `yaml:"workingDirectory,omitempty"`
+
 	// Dir is the directory out of which the build should be triggered
 	Dir string `yaml:",omitempty"`
 
@@ -79,6 +97,32 @@ type Config struct {
 	// Env allows setting environment variables for `go build`
 	Env []string `yaml:",omitempty"`
 
+	// Platforms overrides the platforms to build for this importpath, using
+	// the same format as the `--platform` flag.
+	Platforms []string `yaml:",omitempty"`
+
+	// Tags overrides the tags the image for this importpath is published
+	// with, e.g. both v1.2.3 and latest.
+	Tags []string `yaml:",omitempty"`
+
+	// CGOEnabled sets CGO_ENABLED for `go build`, overriding both the
+	// default of CGO_ENABLED=0 and Env. It is a pointer, so that leaving it
+	// out can be told apart from disabling cgo.
+	CGOEnabled *bool `yaml:"cgoEnabled,omitempty"`
+
+	// BaseImage overrides the default base image for this importpath. An
+	// entry for the importpath in baseImageOverrides still takes precedence.
+	BaseImage string `yaml:"baseImage,omitempty"`
+
+	// BinaryName overrides the name of the binary in the image, which is
+	// the last element of the importpath by default, e.g. server for
+	// /ko-app/server.
+	BinaryName string `yaml:"binaryName,omitempty"`
+
+	// ExtraFiles are added to the image in a layer of their own, e.g. CA
+	// certificates or time zone data that the base image lacks.
+	ExtraFiles []FileEntry `yaml:"extraFiles,omitempty"`
+
 	// Other GoReleaser fields that are not supported or do not make sense
 	// in the context of ko, for reference or for future use:
 	// Goos         []string    `yaml:",omitempty"`
@@ -93,3 +137,16 @@ type Config struct {
 	// ModTimestamp string      `yaml:"mod_timestamp,omitempty"`
 	// GoBinary     string      `yaml:",omitempty"`
 }
+
+// BaseDirectory returns the directory that c.Dir is relative to, given the
+// global working directory.
+func (c Config) BaseDirectory(workingDirectory string) string {
+	switch {
+	case c.WorkingDirectory == "":
+		return workingDirectory
+	case filepath.IsAbs(c.WorkingDirectory):
+		return c.WorkingDirectory
+	default:
+		return filepath.Join(workingDirectory, c.WorkingDirectory)
+	}
+}
@@ -31,6 +31,7 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -79,10 +80,15 @@ type gobuild struct {
 	sbomDir              string
 	disableOptimizations bool
 	trimpath             bool
+	sourceDateEpoch      time.Time
+	goFlags              []string
+	goCache              string
 	buildConfigs         map[string]Config
 	platformMatcher      *platformMatcher
+	configMatchers       map[string]*platformMatcher
 	dir                  string
 	labels               map[string]string
+	annotations          map[string]string
 	semaphore            *semaphore.Weighted
 
 	cache *layerCache
@@ -98,12 +104,18 @@ type gobuildOpener struct {
 	kodataCreationTime   v1.Time
 	build                builder
 	sbom                 sbomber
+	sbomType             string
 	sbomDir              string
 	disableOptimizations bool
 	trimpath             bool
+	trimpathSet          bool
+	sourceDateEpoch      time.Time
+	goFlags              []string
+	goCache              string
 	buildConfigs         map[string]Config
 	platforms            []string
 	labels               map[string]string
+	annotations          map[string]string
 	dir                  string
 	jobs                 int
 }
@@ -116,9 +128,23 @@ func (gbo *gobuildOpener) Open() (Interface, error) {
 	if err != nil {
 		return nil, err
 	}
+	configMatchers := map[string]*platformMatcher{}
+	for ip, config := range gbo.buildConfigs {
+		if len(config.Platforms) == 0 {
+			continue
+		}
+		if configMatchers[ip], err = parseSpec(config.Platforms); err != nil {
+			return nil, fmt.Errorf("platforms of build config for %s: %w", ip, err)
+		}
+	}
 	if gbo.jobs == 0 {
 		gbo.jobs = runtime.GOMAXPROCS(0)
 	}
+	if !gbo.sourceDateEpoch.IsZero() && !gbo.trimpathSet {
+		// A SOURCE_DATE_EPOCH asks for a reproducible build, which needs
+		// -trimpath, unless it was turned off explicitly.
+		gbo.trimpath = true
+	}
 	return &gobuild{
 		ctx:                  gbo.ctx,
 		getBase:              gbo.getBase,
@@ -129,10 +155,15 @@ func (gbo *gobuildOpener) Open() (Interface, error) {
 		sbomDir:              gbo.sbomDir,
 		disableOptimizations: gbo.disableOptimizations,
 		trimpath:             gbo.trimpath,
+		sourceDateEpoch:      gbo.sourceDateEpoch,
+		goFlags:              gbo.goFlags,
+		goCache:              gbo.goCache,
 		buildConfigs:         gbo.buildConfigs,
 		labels:               gbo.labels,
+		annotations:          gbo.annotations,
 		dir:                  gbo.dir,
 		platformMatcher:      matcher,
+		configMatchers:       configMatchers,
 		cache: &layerCache{
 			buildToDiff: map[string]buildIDToDiffID{},
 			diffToDesc:  map[string]diffIDToDescriptor{},
@@ -250,10 +281,12 @@ func getGoBinary() string {
 	return defaultGoBin
 }
 
-func build(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+// goBuildArgs returns the arguments, up to the output and package list, and
+// the environment of a `go build` for the given platform and config.
+func goBuildArgs(platform v1.Platform, config Config) ([]string, []string, error) {
 	buildArgs, err := createBuildArgs(config)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
 	args := make([]string, 0, 4+len(buildArgs))
@@ -262,7 +295,32 @@ func build(ctx context.Context, ip string, dir string, platform v1.Platform, con
 
 	env, err := buildEnv(platform, os.Environ(), config.Env)
 	if err != nil {
-		return "", fmt.Errorf("could not create env for %s: %w", ip, err)
+		return nil, nil, fmt.Errorf("could not create env: %w", err)
+	}
+	return args, mergeEnv(env, cgoEnv(config)), nil
+}
+
+// runGoBuild runs the go tool in dir with the given arguments and environment.
+func runGoBuild(ctx context.Context, dir string, args, env []string) error {
+	gobin := getGoBinary()
+	cmd := exec.CommandContext(ctx, gobin, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	var output bytes.Buffer
+	cmd.Stderr = &output
+	cmd.Stdout = &output
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go build: %w: %s", err, output.String())
+	}
+	return nil
+}
+
+func build(ctx context.Context, ip string, dir string, platform v1.Platform, config Config) (string, error) {
+	args, env, err := goBuildArgs(platform, config)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ip, err)
 	}
 
 	tmpDir := ""
@@ -297,21 +355,12 @@ func build(ctx context.Context, ip string, dir string, platform v1.Platform, con
 	args = append(args, "-o", file)
 	args = append(args, ip)
 
-	gobin := getGoBinary()
-	cmd := exec.CommandContext(ctx, gobin, args...)
-	cmd.Dir = dir
-	cmd.Env = env
-
-	var output bytes.Buffer
-	cmd.Stderr = &output
-	cmd.Stdout = &output
-
 	log.Printf("Building %s for %s", ip, platform)
-	if err := cmd.Run(); err != nil {
+	if err := runGoBuild(ctx, dir, args, env); err != nil {
 		if os.Getenv("KOCACHE") == "" {
 			os.RemoveAll(tmpDir)
 		}
-		return "", fmt.Errorf("go build: %w: %s", err, output.String())
+		return "", err
 	}
 	return file, nil
 }
@@ -463,11 +512,43 @@ func buildEnv(platform v1.Platform, userEnv, configEnv []string) ([]string, erro
 		}
 	}
 
-	env = append(env, userEnv...)
-	env = append(env, configEnv...)
+	env = mergeEnv(env, userEnv)
+	env = mergeEnv(env, configEnv)
 	return env, nil
 }
 
+// cgoEnv returns the CGO_ENABLED setting of config, if any.
+func cgoEnv(config Config) []string {
+	switch {
+	case config.CGOEnabled == nil:
+		return nil
+	case *config.CGOEnabled:
+		return []string{"CGO_ENABLED=1"}
+	default:
+		return []string{"CGO_ENABLED=0"}
+	}
+}
+
+// mergeEnv returns env with the entries of overrides applied, so that each
+// key appears at most once and the value from overrides wins.
+func mergeEnv(env, overrides []string) []string {
+	index := make(map[string]int, len(env))
+	for i, e := range env {
+		k, _, _ := strings.Cut(e, "=")
+		index[k] = i
+	}
+	for _, e := range overrides {
+		k, _, _ := strings.Cut(e, "=")
+		if i, ok := index[k]; ok {
+			env[i] = e
+			continue
+		}
+		index[k] = len(env)
+		env = append(env, e)
+	}
+	return env
+}
+
 func appFilename(importpath string) string {
 	base := filepath.Base(importpath)
 
@@ -699,6 +780,80 @@ func (g *gobuild) tarKoData(ref reference, platform *v1.Platform) (*bytes.Buffer
 	return buf, walkRecursive(tw, root, chroot, creationTime, platform)
 }
 
+// tarExtraFiles writes the extra files of a build config to a tarball.
+// Relative sources are resolved against the directory of the build config.
+// Unlike kodata, no parent directories are written, so that the extra files
+// don't change the permissions of directories in the base image.
+func (g *gobuild) tarExtraFiles(files []FileEntry, platform *v1.Platform) (*bytes.Buffer, error) {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	defer tw.Close()
+
+	creationTime := g.kodataCreationTime
+
+	prefix := ""
+	if platform.OS == "windows" {
+		prefix = "Files"
+		for _, dir := range []string{"Hives", "Files"} {
+			if err := tw.WriteHeader(&tar.Header{
+				Name:     dir,
+				Typeflag: tar.TypeDir,
+				Mode:     0555,
+				ModTime:  creationTime.Time,
+			}); err != nil {
+				return nil, fmt.Errorf("writing dir %q: %w", dir, err)
+			}
+		}
+	}
+
+	for _, f := range files {
+		src := f.Src
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(g.dir, src)
+		}
+		dst := prefix + path.Clean(f.Dst)
+
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			if err := walkRecursive(tw, src, dst, creationTime, platform); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		file, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		header := &tar.Header{
+			Name:     dst,
+			Size:     info.Size(),
+			Typeflag: tar.TypeReg,
+			// Use a fixed Mode, as for kodata.
+			Mode:    0555,
+			ModTime: creationTime.Time,
+		}
+		if platform.OS == "windows" {
+			header.PAXRecords = map[string]string{
+				"MSWINDOWS.rawsd": userOwnerAndGroupSID,
+			}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("tar.Writer.WriteHeader(%q): %w", dst, err)
+		}
+		_, err = io.Copy(tw, file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("io.Copy(%q, %q): %w", dst, src, err)
+		}
+	}
+	return buf, nil
+}
+
 func createTemplateData() map[string]interface{} {
 	envVars := map[string]string{
 		"LDFLAGS": "",
@@ -781,13 +936,31 @@ func (g *gobuild) configForImportPath(ip string) Config {
 		config.Flags = append(config.Flags, "-gcflags", "all=-N -l")
 	}
 
-	if config.ID != "" {
-		log.Printf("Using build config %s for %s", config.ID, ip)
+	config.Flags = append(config.Flags, g.goFlags...)
+
+	if !g.sourceDateEpoch.IsZero() {
+		// Go first, so that SOURCE_DATE_EPOCH in the build config's env wins.
+		epoch := "SOURCE_DATE_EPOCH=" + strconv.FormatInt(g.sourceDateEpoch.Unix(), 10)
+		config.Env = append([]string{epoch}, config.Env...)
+	}
+
+	if g.goCache != "" {
+		// Go first, so that GOCACHE in the build config's env wins.
+		config.Env = append([]string{"GOCACHE=" + g.goCache}, config.Env...)
 	}
 
 	return config
 }
 
+// platformMatcherFor returns the platform matcher for an importpath, which
+// uses the platforms of its build config if it sets any.
+func (g *gobuild) platformMatcherFor(ip string) *platformMatcher {
+	if matcher, ok := g.configMatchers[ip]; ok {
+		return matcher
+	}
+	return g.platformMatcher
+}
+
 func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, platform *v1.Platform) (oci.SignedImage, error) {
 	if err := g.semaphore.Acquire(ctx, 1); err != nil {
 		return nil, err
@@ -822,8 +995,8 @@ func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, pl
 		}
 	}
 
-	if !g.platformMatcher.matches(platform) {
-		return nil, fmt.Errorf("base image platform %q does not match desired platforms %v", platform, g.platformMatcher.platforms)
+	if matcher := g.platformMatcherFor(ref.Path()); !matcher.matches(platform) {
+		return nil, fmt.Errorf("base image platform %q does not match desired platforms %v", platform, matcher.platforms)
 	}
 	// Do the build into a temporary file.
 	file, err := g.build(ctx, ref.Path(), g.dir, *platform, g.configForImportPath(ref.Path()))
@@ -858,15 +1031,43 @@ func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, pl
 		},
 	})
 
+	// Create a layer from the extra files of the build config, if any.
+	if extraFiles := g.buildConfigs[ref.Path()].ExtraFiles; len(extraFiles) > 0 {
+		extraLayerBuf, err := g.tarExtraFiles(extraFiles, platform)
+		if err != nil {
+			return nil, fmt.Errorf("tarring extra files: %w", err)
+		}
+		extraLayerBytes := extraLayerBuf.Bytes()
+		extraLayer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewBuffer(extraLayerBytes)), nil
+		}, tarball.WithCompressedCaching, tarball.WithMediaType(layerMediaType))
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, mutate.Addendum{
+			Layer: extraLayer,
+			History: v1.History{
+				Author:    "ko",
+				CreatedBy: "ko build " + ref.String(),
+				Created:   g.kodataCreationTime,
+				Comment:   "extra files",
+			},
+		})
+	}
+
 	appDir := "/ko-app"
 	appFileName := appFilename(ref.Path())
-	appPath := path.Join(appDir, appFileName)
+	binaryName := appFileName
+	if name := g.buildConfigs[ref.Path()].BinaryName; name != "" {
+		binaryName = name
+	}
+	appPath := path.Join(appDir, binaryName)
 
 	miss := func() (v1.Layer, error) {
 		return buildLayer(appPath, file, platform, layerMediaType)
 	}
 
-	binaryLayer, err := g.cache.get(ctx, file, miss)
+	binaryLayer, err := g.cache.get(ctx, file, appPath, miss)
 	if err != nil {
 		return nil, fmt.Errorf("cache.get(%q): %w", file, err)
 	}
@@ -899,7 +1100,7 @@ func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, pl
 	cfg.Config.Entrypoint = []string{appPath}
 	cfg.Config.Cmd = nil
 	if platform.OS == "windows" {
-		cfg.Config.Entrypoint = []string{`C:\ko-app\` + appFileName}
+		cfg.Config.Entrypoint = []string{`C:\ko-app\` + binaryName}
 		updatePath(cfg, `C:\ko-app`)
 		cfg.Config.Env = append(cfg.Config.Env, `KO_DATA_PATH=C:\var\run\ko`)
 	} else {
@@ -924,6 +1125,9 @@ func (g *gobuild) buildOne(ctx context.Context, refStr string, base v1.Image, pl
 	if err != nil {
 		return nil, err
 	}
+	if len(g.annotations) > 0 {
+		image = mutate.Annotations(image, g.annotations).(v1.Image)
+	}
 
 	si := signed.Image(image)
 
@@ -1035,6 +1239,7 @@ func (g *gobuild) buildAll(ctx context.Context, ref string, baseRef name.Referen
 		return nil, err
 	}
 
+	matcher := g.platformMatcherFor(newRef(ref).Path())
 	matches := []v1.Descriptor{}
 	for _, desc := range im.Manifests {
 		// Nested index is pretty rare. We could support this in theory, but return an error for now.
@@ -1042,7 +1247,7 @@ func (g *gobuild) buildAll(ctx context.Context, ref string, baseRef name.Referen
 			return nil, fmt.Errorf("%q has unexpected mediaType %q in base for %q", desc.Digest, desc.MediaType, ref)
 		}
 
-		if g.platformMatcher.matches(desc.Platform) {
+		if matcher.matches(desc.Platform) {
 			matches = append(matches, desc)
 		}
 	}
@@ -1125,10 +1330,17 @@ func (g *gobuild) buildAll(ctx context.Context, ref string, baseRef name.Referen
 		return nil, err
 	}
 
+	anns := make(map[string]string, len(im.Annotations)+len(g.annotations))
+	for k, v := range im.Annotations {
+		anns[k] = v
+	}
+	for k, v := range g.annotations {
+		anns[k] = v
+	}
 	idx := ocimutate.AppendManifests(
 		mutate.Annotations(
 			mutate.IndexMediaType(empty.Index, baseType),
-			im.Annotations).(v1.ImageIndex),
+			anns).(v1.ImageIndex),
 		adds...)
 
 	if g.sbom != nil {
@@ -297,6 +297,69 @@ func TestBuildEnv(t *testing.T) {
 	}
 }
 
+func TestBuildEnvConfigWins(t *testing.T) {
+	env, err := buildEnv(v1.Platform{OS: "linux", Architecture: "amd64"},
+		[]string{"GOFLAGS=-mod=mod", "FOO=user"},
+		[]string{"FOO=config", "BAR=config"})
+	if err != nil {
+		t.Fatalf("buildEnv() = %v", err)
+	}
+	want := []string{
+		"CGO_ENABLED=0",
+		"GOOS=linux",
+		"GOARCH=amd64",
+		"GOFLAGS=-mod=mod",
+		"FOO=config",
+		"BAR=config",
+	}
+	if diff := cmp.Diff(want, env); diff != "" {
+		t.Errorf("buildEnv() (-want +got) = %s", diff)
+	}
+}
+
+func TestCGOEnv(t *testing.T) {
+	enabled, disabled := true, false
+	for _, test := range []struct {
+		description string
+		userEnv     []string
+		config      Config
+		want        string
+	}{{
+		description: "unset keeps the default",
+		want:        "CGO_ENABLED=0",
+	}, {
+		description: "unset keeps the config env",
+		config:      Config{Env: []string{"CGO_ENABLED=1"}},
+		want:        "CGO_ENABLED=1",
+	}, {
+		description: "enabled",
+		config:      Config{CGOEnabled: &enabled},
+		want:        "CGO_ENABLED=1",
+	}, {
+		description: "disabled wins over env",
+		userEnv:     []string{"CGO_ENABLED=1"},
+		config:      Config{Env: []string{"CGO_ENABLED=1"}, CGOEnabled: &disabled},
+		want:        "CGO_ENABLED=0",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			env, err := buildEnv(v1.Platform{OS: "linux", Architecture: "amd64"}, test.userEnv, test.config.Env)
+			if err != nil {
+				t.Fatalf("buildEnv() = %v", err)
+			}
+			env = mergeEnv(env, cgoEnv(test.config))
+			var got []string
+			for _, e := range env {
+				if strings.HasPrefix(e, "CGO_ENABLED=") {
+					got = append(got, e)
+				}
+			}
+			if diff := cmp.Diff([]string{test.want}, got); diff != "" {
+				t.Errorf("CGO_ENABLED (-want +got) = %s", diff)
+			}
+		})
+	}
+}
+
 func TestBuildConfig(t *testing.T) {
 	tests := []struct {
 		description  string
@@ -369,6 +432,22 @@ func TestBuildConfig(t *testing.T) {
 				Flags: FlagArray{"-gcflags", "all=-N -l"},
 			},
 		},
+		{
+			description: "go flags and build config",
+			options: []Option{
+				WithBaseImages(nilGetBase),
+				WithConfig(map[string]Config{
+					"example.com/foo": {
+						Flags: FlagArray{"-v"},
+					},
+				}),
+				WithGoFlags("-race", "-cover"),
+			},
+			importpath: "example.com/foo",
+			expectConfig: Config{
+				Flags: FlagArray{"-v", "-race", "-cover"},
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
@@ -389,6 +468,112 @@ func TestBuildConfig(t *testing.T) {
 	}
 }
 
+func TestGoFlagsInBuildArgs(t *testing.T) {
+	i, err := NewGo(context.Background(), "",
+		WithBaseImages(nilGetBase),
+		WithTrimpath(true),
+		WithGoFlags("-race", "-tags=integration"))
+	if err != nil {
+		t.Fatalf("NewGo(): unexpected error: %+v", err)
+	}
+	gb, ok := i.(*gobuild)
+	if !ok {
+		t.Fatal("NewGo() did not return *gobuild{} as expected")
+	}
+	args, err := createBuildArgs(gb.configForImportPath("example.com/foo"))
+	if err != nil {
+		t.Fatalf("createBuildArgs(): unexpected error: %v", err)
+	}
+	want := []string{"-trimpath", "-race", "-tags=integration"}
+	if diff := cmp.Diff(want, args); diff != "" {
+		t.Errorf("createBuildArgs() (-want +got): %s", diff)
+	}
+}
+
+func TestGoBuildCacheInBuildEnv(t *testing.T) {
+	cacheDir := t.TempDir()
+	i, err := NewGo(context.Background(), "",
+		WithBaseImages(nilGetBase),
+		WithGoBuildCache(cacheDir),
+		WithConfig(map[string]Config{
+			"example.com/own": {Env: []string{"GOCACHE=/own/cache"}},
+		}))
+	if err != nil {
+		t.Fatalf("NewGo(): unexpected error: %+v", err)
+	}
+	gb, ok := i.(*gobuild)
+	if !ok {
+		t.Fatal("NewGo() did not return *gobuild{} as expected")
+	}
+
+	for _, tc := range []struct {
+		importpath string
+		want       string
+	}{
+		{"example.com/foo", "GOCACHE=" + cacheDir},
+		{"example.com/own", "GOCACHE=/own/cache"},
+	} {
+		_, env, err := goBuildArgs(v1.Platform{OS: "linux", Architecture: "amd64"}, gb.configForImportPath(tc.importpath))
+		if err != nil {
+			t.Fatalf("goBuildArgs(): unexpected error: %v", err)
+		}
+		var got []string
+		for _, e := range env {
+			if strings.HasPrefix(e, "GOCACHE=") {
+				got = append(got, e)
+			}
+		}
+		if diff := cmp.Diff([]string{tc.want}, got); diff != "" {
+			t.Errorf("%s: GOCACHE in env (-want +got): %s", tc.importpath, diff)
+		}
+	}
+}
+
+func TestBuildConfigPlatforms(t *testing.T) {
+	i, err := NewGo(context.Background(), "",
+		WithBaseImages(nilGetBase),
+		WithPlatforms("all"),
+		WithConfig(map[string]Config{
+			"example.com/arm": {Platforms: []string{"linux/arm64"}},
+			"example.com/any": {},
+		}))
+	if err != nil {
+		t.Fatalf("NewGo(): unexpected error: %+v", err)
+	}
+	gb, ok := i.(*gobuild)
+	if !ok {
+		t.Fatal("NewGo() did not return *gobuild{} as expected")
+	}
+
+	amd64 := &v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := &v1.Platform{OS: "linux", Architecture: "arm64"}
+	for _, tc := range []struct {
+		importpath string
+		platform   *v1.Platform
+		want       bool
+	}{
+		{"example.com/arm", arm64, true},
+		{"example.com/arm", amd64, false},
+		{"example.com/any", amd64, true},
+		{"example.com/other", amd64, true},
+	} {
+		if got := gb.platformMatcherFor(tc.importpath).matches(tc.platform); got != tc.want {
+			t.Errorf("platformMatcherFor(%q).matches(%v) = %t, want %t", tc.importpath, tc.platform, got, tc.want)
+		}
+	}
+}
+
+func TestBuildConfigInvalidPlatforms(t *testing.T) {
+	_, err := NewGo(context.Background(), "",
+		WithBaseImages(nilGetBase),
+		WithConfig(map[string]Config{
+			"example.com/foo": {Platforms: []string{"linux/arm64/v8/extra/parts"}},
+		}))
+	if err == nil {
+		t.Error("NewGo() should err for invalid build config platforms, got nil")
+	}
+}
+
 func nilGetBase(context.Context, string) (name.Reference, Result, error) {
 	return nil, nil, nil
 }
@@ -922,6 +1107,228 @@ func TestGoBuildIndex(t *testing.T) {
 	})
 }
 
+func TestGoBuildBinaryName(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko/test"
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		WithPlatforms("all"),
+		withBuilder(writeTempFile),
+		withSBOMber(fauxSBOM),
+		WithConfig(map[string]Config{importpath: {BinaryName: "server"}}),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+	result, err := ng.Build(context.Background(), StrictScheme+importpath)
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	img, ok := result.(oci.SignedImage)
+	if !ok {
+		t.Fatalf("Build() not a SignedImage: %T", result)
+	}
+
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile() = %v", err)
+	}
+	if diff := cmp.Diff([]string{"/ko-app/server"}, cfg.Config.Entrypoint); diff != "" {
+		t.Errorf("Entrypoint (-want +got) = %s", diff)
+	}
+
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatalf("Layers() = %v", err)
+	}
+	r, err := ls[len(ls)-1].Uncompressed()
+	if err != nil {
+		t.Fatalf("Uncompressed() = %v", err)
+	}
+	defer r.Close()
+	var names []string
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+		names = append(names, header.Name)
+	}
+	if diff := cmp.Diff([]string{"ko-app", "/ko-app/server"}, names); diff != "" {
+		t.Errorf("binary layer files (-want +got) = %s", diff)
+	}
+}
+
+func TestGoBuildExtraFiles(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ca.pem"), []byte("certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "zoneinfo", "Europe"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "zoneinfo", "Europe", "Paris"), []byte("tz"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	importpath := "github.com/google/ko/test"
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		WithPlatforms("all"),
+		withBuilder(writeTempFile),
+		withSBOMber(fauxSBOM),
+		WithConfig(map[string]Config{importpath: {ExtraFiles: []FileEntry{
+			{Src: filepath.Join(dir, "ca.pem"), Dst: "/etc/ssl/certs/ca.pem"},
+			{Src: filepath.Join(dir, "zoneinfo"), Dst: "/usr/share/zoneinfo/"},
+		}}}),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+	result, err := ng.Build(context.Background(), StrictScheme+importpath)
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	img, ok := result.(oci.SignedImage)
+	if !ok {
+		t.Fatalf("Build() not a SignedImage: %T", result)
+	}
+
+	// The base layer, kodata, the extra files and the binary.
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatalf("Layers() = %v", err)
+	}
+	if got, want := len(ls), 4; got != want {
+		t.Fatalf("len(Layers()) = %d, want %d", got, want)
+	}
+	r, err := ls[2].Uncompressed()
+	if err != nil {
+		t.Fatalf("Uncompressed() = %v", err)
+	}
+	defer r.Close()
+	got := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("ReadAll() = %v", err)
+		}
+		got[header.Name] = string(content)
+	}
+	want := map[string]string{
+		"/etc/ssl/certs/ca.pem":            "certificate",
+		"/usr/share/zoneinfo/Europe/Paris": "tz",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("extra files layer (-want +got) = %s", diff)
+	}
+}
+
+func TestGoBuildAnnotations(t *testing.T) {
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	index, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	want := map[string]string{
+		"org.opencontainers.image.source": "https://github.com/google/ko",
+		"team":                            "foo",
+	}
+	// hasAnnotations reports whether got holds every annotation of want,
+	// besides those ko adds about the base image.
+	hasAnnotations := func(t *testing.T, got map[string]string) {
+		t.Helper()
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("annotation %q = %q, want %q", k, got[k], v)
+			}
+		}
+		if got[specsv1.AnnotationBaseImageName] == "" {
+			t.Errorf("annotation %q is missing", specsv1.AnnotationBaseImageName)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		base Result
+	}{
+		{name: "image", base: image},
+		{name: "index", base: index},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ng, err := NewGo(
+				context.Background(),
+				"",
+				WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, tc.base, nil }),
+				WithPlatforms("all"),
+				withBuilder(writeTempFile),
+				withSBOMber(fauxSBOM),
+				WithAnnotation("org.opencontainers.image.source", "https://github.com/google/ko"),
+				WithAnnotation("team", "foo"),
+			)
+			if err != nil {
+				t.Fatalf("NewGo() = %v", err)
+			}
+			result, err := ng.Build(context.Background(), StrictScheme+filepath.Join("github.com/google/ko", "test"))
+			if err != nil {
+				t.Fatalf("Build() = %v", err)
+			}
+
+			switch r := result.(type) {
+			case oci.SignedImage:
+				m, err := r.Manifest()
+				if err != nil {
+					t.Fatalf("Manifest() = %v", err)
+				}
+				hasAnnotations(t, m.Annotations)
+			case oci.SignedImageIndex:
+				im, err := r.IndexManifest()
+				if err != nil {
+					t.Fatalf("IndexManifest() = %v", err)
+				}
+				hasAnnotations(t, im.Annotations)
+				for _, desc := range im.Manifests {
+					img, err := r.Image(desc.Digest)
+					if err != nil {
+						t.Fatalf("Image(%s) = %v", desc.Digest, err)
+					}
+					m, err := img.Manifest()
+					if err != nil {
+						t.Fatalf("Manifest() = %v", err)
+					}
+					hasAnnotations(t, m.Annotations)
+				}
+			default:
+				t.Fatalf("Build() = %T, want a SignedImage or SignedImageIndex", result)
+			}
+		})
+	}
+}
+
 func TestNestedIndex(t *testing.T) {
 	baseLayers := int64(3)
 	images := int64(2)
@@ -1232,3 +1639,154 @@ func TestGoBuildConsistentMediaTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestCreationTimestamp(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	before := time.Now().UTC().Truncate(time.Second)
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		WithPlatforms("all"),
+		WithCreationTimestamp(),
+		withBuilder(writeTempFile),
+		withSBOMber(fauxSBOM),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+	result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko/test")
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	after := time.Now().UTC()
+	img, ok := result.(v1.Image)
+	if !ok {
+		t.Fatalf("Build() not an Image: %T", result)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile() = %v", err)
+	}
+	// The config file stores the time with a precision of seconds.
+	if created := cfg.Created.Time; created.Before(before) || created.After(after) {
+		t.Errorf("Created = %v, want between %v and %v", created, before, after)
+	}
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	epoch := time.Unix(1700000000, 0)
+	importpath := StrictScheme + "github.com/google/ko/test"
+
+	var configs []Config
+	newBuilder := func() Interface {
+		t.Helper()
+		ng, err := NewGo(
+			context.Background(),
+			"",
+			WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+			WithPlatforms("all"),
+			WithSourceDateEpoch(epoch),
+			withBuilder(func(ctx context.Context, ip, dir string, platform v1.Platform, config Config) (string, error) {
+				configs = append(configs, config)
+				return writeTempFile(ctx, ip, dir, platform, config)
+			}),
+			withSBOMber(fauxSBOM),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		return ng
+	}
+
+	var digests [][]v1.Hash
+	for i := 0; i < 2; i++ {
+		result, err := newBuilder().Build(context.Background(), importpath)
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		img, ok := result.(v1.Image)
+		if !ok {
+			t.Fatalf("Build() not an Image: %T", result)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		if !cfg.Created.Time.Equal(epoch) {
+			t.Errorf("Created = %v, want %v", cfg.Created.Time, epoch)
+		}
+		layers, err := img.Layers()
+		if err != nil {
+			t.Fatalf("Layers() = %v", err)
+		}
+		var ds []v1.Hash
+		for _, l := range layers {
+			d, err := l.Digest()
+			if err != nil {
+				t.Fatalf("Digest() = %v", err)
+			}
+			ds = append(ds, d)
+		}
+		digests = append(digests, ds)
+	}
+	if diff := cmp.Diff(digests[0], digests[1]); diff != "" {
+		t.Errorf("layer digests differ between builds (-first +second): %s", diff)
+	}
+
+	contains := func(list []string, want string) bool {
+		for _, s := range list {
+			if s == want {
+				return true
+			}
+		}
+		return false
+	}
+	for _, config := range configs {
+		if !contains(config.Flags, "-trimpath") {
+			t.Errorf("Flags = %v, want -trimpath", config.Flags)
+		}
+		if !contains(config.Env, "SOURCE_DATE_EPOCH=1700000000") {
+			t.Errorf("Env = %v, want SOURCE_DATE_EPOCH=1700000000", config.Env)
+		}
+	}
+}
+
+func TestSourceDateEpochWithoutTrimpath(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	var flags []string
+	ng, err := NewGo(
+		context.Background(),
+		"",
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		WithPlatforms("all"),
+		WithSourceDateEpoch(time.Unix(1700000000, 0)),
+		WithTrimpath(false),
+		withBuilder(func(ctx context.Context, ip, dir string, platform v1.Platform, config Config) (string, error) {
+			flags = append(flags, config.Flags...)
+			return writeTempFile(ctx, ip, dir, platform, config)
+		}),
+		withSBOMber(fauxSBOM),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+	if _, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko/test"); err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	for _, flag := range flags {
+		if flag == "-trimpath" {
+			t.Errorf("Flags = %v, want no -trimpath with WithTrimpath(false)", flags)
+		}
+	}
+}
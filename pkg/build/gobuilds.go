@@ -55,7 +55,7 @@ func NewGobuilds(ctx context.Context, workingDirectory string, buildConfigs map[
 		workingDirectory: workingDirectory,
 	}
 	for importpath, buildConfig := range buildConfigs {
-		builderDirectory := path.Join(workingDirectory, buildConfig.Dir)
+		builderDirectory := path.Join(buildConfig.BaseDirectory(workingDirectory), buildConfig.Dir)
 		builder, err := NewGo(ctx, builderDirectory, opts...)
 		if err != nil {
 			return nil, fmt.Errorf("could not create go builder for config (%q): %w", importpath, err)
@@ -98,6 +98,20 @@ func Test_gobuilds(t *testing.T) {
 			opts:              opts,
 			importpath:        "./test",
 		},
+		{
+			description:      "build config working directory overrides the global one",
+			workingDirectory: "../../pkg",
+			buildConfigs: map[string]Config{
+				"github.com/google/ko/test": {
+					ID:               "build-config-4",
+					WorkingDirectory: "..",
+					Dir:              "test",
+				},
+			},
+			nilDefaultBuilder: true,
+			opts:              opts,
+			importpath:        "github.com/google/ko/test",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
@@ -15,7 +15,10 @@
 package build
 
 import (
+	"fmt"
+	"path/filepath"
 	"strings"
+	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
@@ -38,6 +41,29 @@ func WithCreationTime(t v1.Time) Option {
 	}
 }
 
+// WithSourceDateEpoch is a functional option for making builds reproducible
+// with the given SOURCE_DATE_EPOCH. The time becomes the creation time of the
+// images, `go build` runs with -trimpath unless WithTrimpath turns it off, and
+// SOURCE_DATE_EPOCH is set in its environment for the tools it invokes, like a
+// C compiler, unless a build config sets it too.
+func WithSourceDateEpoch(t time.Time) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.creationTime = v1.Time{Time: t}
+		gbo.sourceDateEpoch = t
+		return nil
+	}
+}
+
+// WithCreationTimestamp is a functional option for recording the current
+// time, in UTC, as the creation time of the images, for pipelines that audit
+// when images were built. This makes builds non-reproducible.
+func WithCreationTimestamp() Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.creationTime = v1.Time{Time: time.Now().UTC()}
+		return nil
+	}
+}
+
 // WithKoDataCreationTime is a functional option for overriding the creation
 // time given to the files in the kodata directory.
 func WithKoDataCreationTime(t v1.Time) Option {
@@ -60,15 +86,41 @@ func WithDisabledOptimizations() Option {
 func WithDisabledSBOM() Option {
 	return func(gbo *gobuildOpener) error {
 		gbo.sbom = nil
+		gbo.sbomType = "none"
 		return nil
 	}
 }
 
 // WithTrimpath is a functional option that controls whether the `-trimpath`
-// flag is added to `go build`.
+// flag is added to `go build`. It takes precedence over WithSourceDateEpoch.
 func WithTrimpath(v bool) Option {
 	return func(gbo *gobuildOpener) error {
 		gbo.trimpath = v
+		gbo.trimpathSet = true
+		return nil
+	}
+}
+
+// WithGoFlags is a functional option for passing additional flags to every
+// `go build` invocation, e.g. `-race`.
+func WithGoFlags(flags ...string) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.goFlags = append(gbo.goFlags, flags...)
+		return nil
+	}
+}
+
+// WithGoBuildCache is a functional option for setting GOCACHE for every
+// `go build` invocation, e.g. to keep parallel runs of ko on the same host
+// from sharing a build cache. dir is made absolute, as the go tool requires,
+// and a build config that sets GOCACHE in its env takes precedence.
+func WithGoBuildCache(dir string) Option {
+	return func(gbo *gobuildOpener) error {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("go build cache: %w", err)
+		}
+		gbo.goCache = abs
 		return nil
 	}
 }
@@ -117,6 +169,18 @@ func WithLabel(k, v string) Option {
 	}
 }
 
+// WithAnnotation is a functional option for adding annotations to the
+// manifests of built images, and of the indexes of multi-platform images.
+func WithAnnotation(k, v string) Option {
+	return func(gbo *gobuildOpener) error {
+		if gbo.annotations == nil {
+			gbo.annotations = map[string]string{}
+		}
+		gbo.annotations[k] = v
+		return nil
+	}
+}
+
 // withBuilder is a functional option for overriding the way go binaries
 // are built.
 func withBuilder(b builder) Option {
@@ -131,6 +195,7 @@ func withBuilder(b builder) Option {
 func WithGoVersionSBOM() Option {
 	return func(gbo *gobuildOpener) error {
 		gbo.sbom = goversionm
+		gbo.sbomType = "go.version-m"
 		return nil
 	}
 }
@@ -140,6 +205,7 @@ func WithGoVersionSBOM() Option {
 func WithSPDX(version string) Option {
 	return func(gbo *gobuildOpener) error {
 		gbo.sbom = spdx(version)
+		gbo.sbomType = "spdx " + version
 		return nil
 	}
 }
@@ -149,6 +215,7 @@ func WithSPDX(version string) Option {
 func WithCycloneDX() Option {
 	return func(gbo *gobuildOpener) error {
 		gbo.sbom = cycloneDX()
+		gbo.sbomType = "cyclonedx"
 		return nil
 	}
 }
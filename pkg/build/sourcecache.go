@@ -0,0 +1,914 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/logs"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sigstore/cosign/v2/pkg/oci"
+	"github.com/sigstore/cosign/v2/pkg/oci/signed"
+	"github.com/sigstore/cosign/v2/pkg/oci/walk"
+)
+
+// CachingBuilder wraps a builder in a layer that persists build results in a
+// local directory, so that import paths whose sources haven't changed are not
+// rebuilt, even across invocations of ko. Unlike Caching, which shares results
+// within a single process until they are invalidated, results are keyed by
+// the content of the files that go into each import path, as enumerated by
+// `go list -deps` for each platform it is built for, with the environment and
+// flags of its build config, along with the Go version and environment.
+//
+// Results can also be shared through a registry with WithCacheFrom and
+// WithCacheTo, e.g. between CI runs that start without a cache directory. A
+// result is cached there as the built image or image index itself, unchanged,
+// tagged with its cache key: a hex encoded SHA-256 hash, like
+// registry.example.com/cache:3f2a...9c. A cached result thus has the digest
+// that the wrapped builder produced, and only blobs missing from the registry
+// are pushed.
+//
+// The SBOMs that the wrapped builder attaches to a result and to the images
+// it contains are cached along with it, as an image index with one manifest
+// per SBOM, annotated with the digest of what it is attached to. In the
+// cache directory, it is stored in the same layout as the result; in a
+// registry, it is tagged with the cache key and a ".sbom" suffix. Cached
+// results have their SBOMs attached again, so they are published like fresh
+// ones, but they aren't written to the directory passed to WithSBOMDir.
+//
+// The key covers the configuration of the wrapped builder only as far as it is
+// passed with WithCacheBuildOptions: the digest of the base image, the
+// platforms, the build config of the import path, including the content of
+// its extra files, and options like labels, Go flags and the SBOM type.
+type CachingBuilder struct {
+	inner      Interface
+	dir        string
+	cacheDir   string
+	cacheFrom  *name.Repository
+	cacheTo    *name.Repository
+	remoteOpts []remote.Option
+
+	// opener holds the options of the wrapped builder.
+	opener gobuildOpener
+
+	versionOnce sync.Once
+	version     string
+	versionErr  error
+}
+
+// CachingBuilder implements Interface
+var _ Interface = (*CachingBuilder)(nil)
+
+// keyEnv are the environment variables that affect `go build`, besides those
+// ko sets for each platform, and so are part of cache keys.
+var keyEnv = []string{"CGO_ENABLED", "GOAMD64", "GOARM", "GOEXPERIMENT", "GOFLAGS"}
+
+const (
+	// sbomsAnnotation marks the index of SBOMs in the layout of a result in
+	// the cache directory.
+	sbomsAnnotation = "dev.ko.cache.sboms"
+	// sbomSubjectAnnotation holds the digest of the image or image index
+	// that each SBOM in an index of SBOMs is attached to.
+	sbomSubjectAnnotation = "dev.ko.cache.sbom-subject"
+)
+
+// CachingOption is a functional option for NewCachingBuilder.
+type CachingOption func(*CachingBuilder) error
+
+// WithCacheFrom is a functional option for looking up results that are missing
+// from the cache directory in repo.
+func WithCacheFrom(repo name.Repository) CachingOption {
+	return func(c *CachingBuilder) error {
+		c.cacheFrom = &repo
+		return nil
+	}
+}
+
+// WithCacheTo is a functional option for pushing new results to repo, besides
+// storing them in the cache directory.
+func WithCacheTo(repo name.Repository) CachingOption {
+	return func(c *CachingBuilder) error {
+		c.cacheTo = &repo
+		return nil
+	}
+}
+
+// WithCacheRemoteOptions is a functional option for the options used to access
+// the repositories passed to WithCacheFrom and WithCacheTo, e.g. for
+// authentication.
+func WithCacheRemoteOptions(opts ...remote.Option) CachingOption {
+	return func(c *CachingBuilder) error {
+		c.remoteOpts = append(c.remoteOpts, opts...)
+		return nil
+	}
+}
+
+// WithCacheBuildOptions is a functional option for the options that the
+// wrapped builder was created with, e.g. by NewGobuilds, so that cache keys
+// cover its base images, platforms and build configs.
+func WithCacheBuildOptions(opts ...Option) CachingOption {
+	return func(c *CachingBuilder) error {
+		for _, opt := range opts {
+			if err := opt(&c.opener); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// NewCachingBuilder wraps the provided build.Interface in an implementation
+// that stores build results in cacheDir, unless it is empty. The `go` tool is
+// run in dir to enumerate the files that go into each import path.
+func NewCachingBuilder(inner Interface, dir, cacheDir string, opts ...CachingOption) (*CachingBuilder, error) {
+	c := &CachingBuilder{
+		inner:    inner,
+		dir:      dir,
+		cacheDir: cacheDir,
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if cacheDir == "" {
+		return c, nil
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating build cache dir: %w", err)
+	}
+	return c, nil
+}
+
+// QualifyImport implements Interface
+func (c *CachingBuilder) QualifyImport(ip string) (string, error) {
+	return c.inner.QualifyImport(ip)
+}
+
+// IsSupportedReference implements Interface
+func (c *CachingBuilder) IsSupportedReference(ip string) error {
+	return c.inner.IsSupportedReference(ip)
+}
+
+// Build implements Interface
+func (c *CachingBuilder) Build(ctx context.Context, ip string) (Result, error) {
+	key, err := c.key(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := c.lookup(ctx, key); ok {
+		return r, nil
+	}
+	r, err := c.inner.Build(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	c.save(ctx, key, r)
+	return r, nil
+}
+
+// lookup returns the result cached under key, if any, looking in the cache
+// directory first.
+func (c *CachingBuilder) lookup(ctx context.Context, key string) (Result, bool) {
+	if c.cacheDir != "" {
+		r, err := c.load(key)
+		if err == nil {
+			return r, true
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			logs.Debug.Printf("loading cached build %s: %v", key, err)
+		}
+	}
+	if c.cacheFrom != nil {
+		r, err := c.pull(ctx, key)
+		if err == nil {
+			return r, true
+		}
+		var terr *transport.Error
+		if !errors.As(err, &terr) || terr.StatusCode != http.StatusNotFound {
+			logs.Debug.Printf("pulling cached build %s: %v", key, err)
+		}
+	}
+	return nil, false
+}
+
+func (c *CachingBuilder) load(key string) (Result, error) {
+	p, err := layout.FromPath(filepath.Join(c.cacheDir, key))
+	if err != nil {
+		return nil, err
+	}
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		results []v1.Descriptor
+		sboms   v1.ImageIndex
+	)
+	for _, desc := range im.Manifests {
+		if desc.Annotations[sbomsAnnotation] == "" {
+			results = append(results, desc)
+			continue
+		}
+		if sboms, err = idx.ImageIndex(desc.Digest); err != nil {
+			return nil, err
+		}
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("found %d results, expected 1", len(results))
+	}
+	desc := results[0]
+	if desc.MediaType.IsIndex() {
+		ii, err := idx.ImageIndex(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		return withSBOMs(signed.ImageIndex(ii), sboms)
+	}
+	img, err := idx.Image(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return withSBOMs(signed.Image(img), sboms)
+}
+
+// save caches r under key. Failing to do so doesn't fail the build.
+func (c *CachingBuilder) save(ctx context.Context, key string, r Result) {
+	sboms, err := sbomsOf(ctx, r)
+	if err != nil {
+		log.Printf("failed to cache build %s: %v", key, err)
+		return
+	}
+	if c.cacheDir != "" {
+		if err := c.store(key, r, sboms); err != nil {
+			log.Printf("failed to cache build %s: %v", key, err)
+		}
+	}
+	if c.cacheTo != nil {
+		if err := c.push(ctx, key, r, sboms); err != nil {
+			log.Printf("failed to push build %s to cache %s: %v", key, c.cacheTo, err)
+		}
+	}
+}
+
+// pull returns the result tagged with key in the cacheFrom repository.
+func (c *CachingBuilder) pull(ctx context.Context, key string) (Result, error) {
+	desc, err := remote.Get(c.cacheFrom.Tag(key), c.remoteOptions(ctx)...)
+	if err != nil {
+		return nil, err
+	}
+	sboms, err := remote.Index(sbomsTag(*c.cacheFrom, key), c.remoteOptions(ctx)...)
+	var terr *transport.Error
+	if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+		// The result has no SBOMs.
+		sboms, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if desc.MediaType.IsIndex() {
+		ii, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		return withSBOMs(signed.ImageIndex(ii), sboms)
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return nil, err
+	}
+	return withSBOMs(signed.Image(img), sboms)
+}
+
+// sbomsTag returns the tag of the SBOMs of the result cached under key in
+// repo.
+func sbomsTag(repo name.Repository, key string) name.Tag {
+	return repo.Tag(key + ".sbom")
+}
+
+// push tags r with key in the cacheTo repository, after its SBOMs, if any, so
+// that they can be found once r can.
+func (c *CachingBuilder) push(ctx context.Context, key string, r Result, sboms v1.ImageIndex) error {
+	if sboms != nil {
+		if err := remote.WriteIndex(sbomsTag(*c.cacheTo, key), sboms, c.remoteOptions(ctx)...); err != nil {
+			return err
+		}
+	}
+	ref := c.cacheTo.Tag(key)
+	switch r := r.(type) {
+	case v1.ImageIndex:
+		return remote.WriteIndex(ref, r, c.remoteOptions(ctx)...)
+	case v1.Image:
+		return remote.Write(ref, r, c.remoteOptions(ctx)...)
+	default:
+		return fmt.Errorf("unsupported build result type %T", r)
+	}
+}
+
+func (c *CachingBuilder) remoteOptions(ctx context.Context) []remote.Option {
+	return append([]remote.Option{remote.WithContext(ctx)}, c.remoteOpts...)
+}
+
+// store writes r and its SBOMs, if any, as an OCI image layout, which is only
+// moved in place once complete so that concurrent readers never see a partial
+// one.
+func (c *CachingBuilder) store(key string, r Result, sboms v1.ImageIndex) error {
+	tmp, err := os.MkdirTemp(c.cacheDir, key+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	p, err := layout.Write(tmp, empty.Index)
+	if err != nil {
+		return err
+	}
+	switch r := r.(type) {
+	case v1.ImageIndex:
+		err = p.AppendIndex(r)
+	case v1.Image:
+		err = p.AppendImage(r)
+	default:
+		err = fmt.Errorf("unsupported build result type %T", r)
+	}
+	if err != nil {
+		return err
+	}
+	if sboms != nil {
+		if err := p.AppendIndex(sboms, layout.WithAnnotations(map[string]string{sbomsAnnotation: "true"})); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, filepath.Join(c.cacheDir, key)); err != nil {
+		if _, statErr := os.Stat(filepath.Join(c.cacheDir, key)); statErr == nil {
+			// Another build stored the same result first.
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// sbomsOf returns an index of the SBOMs attached to r and to the images and
+// image indexes it contains, or nil if there are none.
+func sbomsOf(ctx context.Context, r Result) (v1.ImageIndex, error) {
+	se, ok := r.(oci.SignedEntity)
+	if !ok {
+		return nil, nil
+	}
+	var adds []mutate.IndexAddendum
+	if err := walk.SignedEntity(ctx, se, func(_ context.Context, se oci.SignedEntity) error {
+		f, err := se.Attachment("sbom")
+		if err != nil {
+			// Like for publishing, some levels may not have an SBOM.
+			return nil
+		}
+		h, err := se.Digest()
+		if err != nil {
+			return err
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: f,
+			Descriptor: v1.Descriptor{
+				Annotations: map[string]string{sbomSubjectAnnotation: h.String()},
+			},
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(adds) == 0 {
+		return nil, nil
+	}
+	return mutate.AppendManifests(empty.Index, adds...), nil
+}
+
+// withSBOMs attaches the SBOMs in sboms, as returned by sbomsOf, to r and to
+// the images and image indexes it contains again.
+func withSBOMs(r Result, sboms v1.ImageIndex) (Result, error) {
+	if sboms == nil {
+		return r, nil
+	}
+	im, err := sboms.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[v1.Hash]oci.File, len(im.Manifests))
+	for _, desc := range im.Manifests {
+		subject, err := v1.NewHash(desc.Annotations[sbomSubjectAnnotation])
+		if err != nil {
+			return nil, fmt.Errorf("subject of SBOM %s: %w", desc.Digest, err)
+		}
+		img, err := sboms.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		files[subject] = sbomFile{signed.Image(img)}
+	}
+	switch r := r.(type) {
+	case oci.SignedImageIndex:
+		return &sbomIndex{r, files}, nil
+	case oci.SignedImage:
+		return &sbomImage{r, files}, nil
+	default:
+		return r, nil
+	}
+}
+
+// sbomImage is a cached image with its SBOM attached again.
+type sbomImage struct {
+	oci.SignedImage
+	sboms map[v1.Hash]oci.File
+}
+
+// Attachment implements oci.SignedEntity
+func (i *sbomImage) Attachment(name string) (oci.File, error) {
+	return attachment(i.SignedImage, i.sboms, name)
+}
+
+// signedImageIndex lets sbomIndex embed an oci.SignedImageIndex, and still
+// override its SignedImageIndex method.
+type signedImageIndex = oci.SignedImageIndex
+
+// sbomIndex is a cached image index with its SBOMs, and those of the images
+// and image indexes it contains, attached again.
+type sbomIndex struct {
+	signedImageIndex
+	sboms map[v1.Hash]oci.File
+}
+
+// Attachment implements oci.SignedEntity
+func (i *sbomIndex) Attachment(name string) (oci.File, error) {
+	return attachment(i.signedImageIndex, i.sboms, name)
+}
+
+// SignedImage implements oci.SignedImageIndex
+func (i *sbomIndex) SignedImage(h v1.Hash) (oci.SignedImage, error) {
+	img, err := i.signedImageIndex.SignedImage(h)
+	if err != nil {
+		return nil, err
+	}
+	return &sbomImage{img, i.sboms}, nil
+}
+
+// SignedImageIndex implements oci.SignedImageIndex
+func (i *sbomIndex) SignedImageIndex(h v1.Hash) (oci.SignedImageIndex, error) {
+	ii, err := i.signedImageIndex.SignedImageIndex(h)
+	if err != nil {
+		return nil, err
+	}
+	return &sbomIndex{ii, i.sboms}, nil
+}
+
+// attachment returns the SBOM of se from sboms, or else asks se for the
+// attachment.
+func attachment(se oci.SignedEntity, sboms map[v1.Hash]oci.File, name string) (oci.File, error) {
+	if name == "sbom" {
+		h, err := se.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if f, ok := sboms[h]; ok {
+			return f, nil
+		}
+	}
+	return se.Attachment(name)
+}
+
+// sbomFile is an SBOM read back from the cache, made with static.NewFile.
+type sbomFile struct {
+	oci.SignedImage
+}
+
+var _ oci.File = sbomFile{}
+
+// FileMediaType implements oci.File
+func (f sbomFile) FileMediaType() (types.MediaType, error) {
+	l, err := f.layer()
+	if err != nil {
+		return "", err
+	}
+	return l.MediaType()
+}
+
+// Payload implements oci.File
+func (f sbomFile) Payload() ([]byte, error) {
+	l, err := f.layer()
+	if err != nil {
+		return nil, err
+	}
+	rc, err := l.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (f sbomFile) layer() (v1.Layer, error) {
+	layers, err := f.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) != 1 {
+		return nil, fmt.Errorf("found %d layers in SBOM, expected 1", len(layers))
+	}
+	return layers[0], nil
+}
+
+// listedPackage is the subset of the output of `go list -json` that
+// determines the content of a package.
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	Standard   bool
+	Module     *struct {
+		Path    string
+		Version string
+		Main    bool
+		Replace *struct {
+			Path    string
+			Version string
+		}
+	}
+	GoFiles    []string
+	CgoFiles   []string
+	CFiles     []string
+	CXXFiles   []string
+	HFiles     []string
+	SFiles     []string
+	SysoFiles  []string
+	EmbedFiles []string
+}
+
+// files returns the files of p that go build reads, relative to p.Dir.
+func (p listedPackage) files() []string {
+	var files []string
+	for _, names := range [][]string{p.GoFiles, p.CgoFiles, p.CFiles, p.CXXFiles, p.HFiles, p.SFiles, p.SysoFiles, p.EmbedFiles} {
+		files = append(files, names...)
+	}
+	return files
+}
+
+// versioned returns the module version that p comes from, if its content is
+// fixed by that version, i.e. it comes from the module cache.
+func (p listedPackage) versioned() (string, bool) {
+	m := p.Module
+	switch {
+	case m == nil || m.Main:
+		return "", false
+	case m.Replace != nil:
+		if m.Replace.Version == "" {
+			// Replaced by a local directory.
+			return "", false
+		}
+		return m.Replace.Path + "@" + m.Replace.Version, true
+	case m.Version != "":
+		return m.Path + "@" + m.Version, true
+	}
+	return "", false
+}
+
+// buildKey is the configuration of the wrapped builder that goes into the
+// cache key of an import path.
+type buildKey struct {
+	Base                 string
+	Platforms            []string
+	Config               Config
+	CreationTime         v1.Time
+	KoDataCreationTime   v1.Time
+	SourceDateEpoch      time.Time
+	DisableOptimizations bool
+	Trimpath             bool
+	GoFlags              []string
+	Labels               map[string]string
+	Annotations          map[string]string
+	SBOM                 string
+}
+
+// buildKey returns the configuration of the wrapped builder for ip, and the
+// base image it resolves to, if any.
+func (c *CachingBuilder) buildKey(ctx context.Context, ip string) (buildKey, Result, error) {
+	gbo := c.opener
+	bk := buildKey{
+		Platforms:            gbo.platforms,
+		Config:               gbo.buildConfigs[strings.TrimPrefix(ip, StrictScheme)],
+		CreationTime:         gbo.creationTime,
+		KoDataCreationTime:   gbo.kodataCreationTime,
+		SourceDateEpoch:      gbo.sourceDateEpoch,
+		DisableOptimizations: gbo.disableOptimizations,
+		Trimpath:             gbo.trimpath,
+		GoFlags:              gbo.goFlags,
+		Labels:               gbo.labels,
+		Annotations:          gbo.annotations,
+		SBOM:                 gbo.sbomType,
+	}
+	if gbo.getBase == nil {
+		return bk, nil, nil
+	}
+	ref, base, err := gbo.getBase(ctx, ip)
+	if err != nil {
+		return buildKey{}, nil, err
+	}
+	digest, err := base.Digest()
+	if err != nil {
+		return buildKey{}, nil, fmt.Errorf("digest of base image %s: %w", ref, err)
+	}
+	bk.Base = ref.String() + "@" + digest.String()
+	return bk, base, nil
+}
+
+// platforms returns the platforms that the wrapped builder builds for, given
+// the build config of an import path and its base image, like buildAll picks
+// them. Without a base image, it falls back to the requested platforms, or to
+// the zero platform, i.e. that of the host, if they aren't specific.
+func (c *CachingBuilder) platforms(config Config, base Result) ([]v1.Platform, error) {
+	spec := c.opener.platforms
+	if len(config.Platforms) > 0 {
+		spec = config.Platforms
+	}
+	matcher, err := parseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	switch base := base.(type) {
+	case v1.ImageIndex:
+		im, err := base.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		var platforms []v1.Platform
+		for _, desc := range im.Manifests {
+			if matcher.matches(desc.Platform) {
+				platforms = append(platforms, *desc.Platform)
+			}
+		}
+		return platforms, nil
+	case v1.Image:
+		cf, err := base.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		if p := cf.Platform(); p != nil {
+			return []v1.Platform{*p}, nil
+		}
+	}
+	if len(matcher.platforms) > 0 {
+		return matcher.platforms, nil
+	}
+	return []v1.Platform{{}}, nil
+}
+
+// key returns the cache key of ip.
+func (c *CachingBuilder) key(ctx context.Context, ip string) (string, error) {
+	version, err := c.goVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+	bk, base, err := c.buildKey(ctx, ip)
+	if err != nil {
+		return "", err
+	}
+	platforms, err := c.platforms(bk.Config, base)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(bk.Config.BaseDirectory(c.dir), bk.Config.Dir)
+	listed, err := c.listDeps(ctx, dir, strings.TrimPrefix(ip, StrictScheme), bk.Config, platforms)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", ip, version)
+	b, err := json.Marshal(bk)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "build %s\n", b)
+	for _, name := range keyEnv {
+		fmt.Fprintf(h, "%s=%s\n", name, os.Getenv(name))
+	}
+	for _, pkg := range sortedKeys(listed.packages) {
+		fmt.Fprintf(h, "package %s\n", pkg)
+	}
+	for _, module := range sortedKeys(listed.modules) {
+		fmt.Fprintf(h, "module %s\n", module)
+	}
+	names := make([]string, 0, len(listed.files))
+	for name := range listed.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := hashFile(h, name, listed.files[name]); err != nil {
+			return "", err
+		}
+	}
+
+	// The kodata directory of the package to build goes into the image as
+	// well.
+	if listed.dir != "" {
+		root := filepath.Join(listed.dir, "kodata")
+		if err := hashTree(h, "kodata", root); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("hashing kodata: %w", err)
+		}
+	}
+
+	// So do the extra files of the build config, whose paths are covered by
+	// the build config itself.
+	for _, file := range bk.Config.ExtraFiles {
+		src := file.Src
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(dir, src)
+		}
+		if err := hashTree(h, file.Dst, src); err != nil {
+			return "", fmt.Errorf("hashing extra file %s: %w", file.Src, err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashTree writes the names and content hashes of the files under root to w,
+// with their paths relative to root appended to name. If root is a file, it
+// is written as name. Symlinks are followed, like walkRecursive does.
+func hashTree(w io.Writer, name, root string) error {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		fileName := name
+		if rel != "." {
+			fileName = name + "/" + filepath.ToSlash(rel)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return hashTree(w, fileName, path)
+		}
+		return hashFile(w, fileName, path)
+	})
+}
+
+// hashFile writes the name and content hash of the file at path to w.
+func hashFile(w io.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("hashing %s: %w", path, err)
+	}
+	_, err = fmt.Fprintf(w, "file %s %x\n", name, h.Sum(nil))
+	return err
+}
+
+// deps is the union of the packages that go into the binaries of an import
+// path across platforms.
+type deps struct {
+	// dir is the directory of the import path itself.
+	dir string
+	// packages are the import paths of all packages.
+	packages map[string]bool
+	// modules are the versions of the modules that packages come from, for
+	// those whose content is fixed by them.
+	modules map[string]bool
+	// files are the files of the other packages, except for the standard
+	// library which is covered by the Go version, by their import path and
+	// name.
+	files map[string]string
+}
+
+// listDeps returns the packages that go into the binaries of importPath for
+// each of platforms, as listed by `go list -deps` in dir with the environment
+// and flags that the wrapped builder passes to `go build`.
+func (c *CachingBuilder) listDeps(ctx context.Context, dir, importPath string, config Config, platforms []v1.Platform) (*deps, error) {
+	// Only the Go flags of the builder can change which files go into a
+	// binary, e.g. with -tags.
+	config.Flags = append(append(FlagArray{}, config.Flags...), c.opener.goFlags...)
+
+	d := &deps{
+		packages: map[string]bool{},
+		modules:  map[string]bool{},
+		files:    map[string]string{},
+	}
+	for _, platform := range platforms {
+		args, env, err := goBuildArgs(platform, config)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", importPath, err)
+		}
+		args = append([]string{"list", "-deps", "-json"}, args[1:]...)
+		out, err := c.runGo(ctx, dir, env, append(args, importPath)...)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(out))
+		for {
+			var pkg listedPackage
+			if err := dec.Decode(&pkg); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("decoding go list output: %w", err)
+			}
+			// The package to build comes last, after its dependencies.
+			d.dir = pkg.Dir
+			d.packages[pkg.ImportPath] = true
+			if pkg.Standard {
+				continue
+			}
+			if v, ok := pkg.versioned(); ok {
+				d.modules[v] = true
+				continue
+			}
+			for _, file := range pkg.files() {
+				d.files[pkg.ImportPath+"/"+file] = filepath.Join(pkg.Dir, file)
+			}
+		}
+	}
+	return d, nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// goVersion returns the version of the `go` tool, which is only looked up once.
+func (c *CachingBuilder) goVersion(ctx context.Context) (string, error) {
+	c.versionOnce.Do(func() {
+		var out []byte
+		out, c.versionErr = c.runGo(ctx, c.dir, nil, "env", "GOVERSION")
+		c.version = strings.TrimSpace(string(out))
+	})
+	return c.version, c.versionErr
+}
+
+// runGo runs the `go` tool in dir, with env unless it is nil.
+func (c *CachingBuilder) runGo(ctx context.Context, dir string, env []string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, getGoBinary(), args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("go %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,466 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sigstore/cosign/v2/pkg/oci"
+	ocimutate "github.com/sigstore/cosign/v2/pkg/oci/mutate"
+	"github.com/sigstore/cosign/v2/pkg/oci/signed"
+	"github.com/sigstore/cosign/v2/pkg/oci/static"
+	"github.com/sigstore/cosign/v2/pkg/oci/walk"
+)
+
+// writeModule writes the files of a Go module into a temporary directory,
+// and returns it.
+func writeModule(tb testing.TB, files map[string]string) string {
+	tb.Helper()
+	// The module has no dependencies to vendor.
+	tb.Setenv("GOFLAGS", "-mod=mod")
+	dir := tb.TempDir()
+	files["go.mod"] = "module example.com/cached\n\ngo 1.21\n"
+	for path, content := range files {
+		writeFile(tb, filepath.Join(dir, path), content)
+	}
+	return dir
+}
+
+func writeFile(tb testing.TB, path, content string) {
+	tb.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+const mainGo = "package main\n\nfunc main() {}\n"
+
+func TestCachingBuilder(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"cmd/app/main.go":           mainGo,
+		"cmd/app/kodata/index.html": "hello",
+	})
+	cacheDir := t.TempDir()
+	ip := StrictScheme + "example.com/cached/cmd/app"
+
+	rec := &Recorder{Builder: &slowbuild{}}
+	build := func(t *testing.T) string {
+		t.Helper()
+		// Each build uses a new CachingBuilder, like separate runs of ko.
+		cb, err := NewCachingBuilder(rec, dir, cacheDir)
+		if err != nil {
+			t.Fatalf("NewCachingBuilder() = %v", err)
+		}
+		result, err := cb.Build(context.Background(), ip)
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		return digest(t, result)
+	}
+
+	first := build(t)
+	if got := build(t); got != first {
+		t.Errorf("Build() of unchanged sources = %s, want cached %s", got, first)
+	}
+	if got, want := len(rec.ImportPaths), 1; got != want {
+		t.Fatalf("inner builds = %d, want %d", got, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		path string
+	}{
+		{name: "source changed", path: "cmd/app/main.go"},
+		{name: "kodata changed", path: "cmd/app/kodata/index.html"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := len(rec.ImportPaths)
+			writeFile(t, filepath.Join(dir, tc.path), mainGo+"// "+tc.name+"\n")
+			if got := build(t); got == first {
+				t.Errorf("Build() = %s, want a new build", got)
+			}
+			if got, want := len(rec.ImportPaths), before+1; got != want {
+				t.Errorf("inner builds = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestCachingBuilderRegistry(t *testing.T) {
+	dir := writeModule(t, map[string]string{"cmd/app/main.go": mainGo})
+	ip := StrictScheme + "example.com/cached/cmd/app"
+	ctx := context.Background()
+
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+	repo, err := name.NewRepository(u.Host + "/cache")
+	if err != nil {
+		t.Fatalf("name.NewRepository() = %v", err)
+	}
+
+	rec := &Recorder{Builder: &slowbuild{}}
+	// Without a cache directory, results only go to the registry.
+	pusher, err := NewCachingBuilder(rec, dir, "", WithCacheTo(repo))
+	if err != nil {
+		t.Fatalf("NewCachingBuilder() = %v", err)
+	}
+	built, err := pusher.Build(ctx, ip)
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+
+	// The cache holds the built image index itself, tagged with the cache
+	// key, so it has the same digest and media type.
+	key, err := pusher.key(ctx, ip)
+	if err != nil {
+		t.Fatalf("key() = %v", err)
+	}
+	desc, err := remote.Get(repo.Tag(key))
+	if err != nil {
+		t.Fatalf("remote.Get(%s) = %v", repo.Tag(key), err)
+	}
+	if got, want := desc.Digest.String(), digest(t, built); got != want {
+		t.Errorf("cached digest = %s, want %s", got, want)
+	}
+	if !desc.MediaType.IsIndex() {
+		t.Errorf("cached media type = %s, want an image index", desc.MediaType)
+	}
+
+	// Another run, e.g. on another machine, pulls the result instead of
+	// building it, while a repository without it falls back to building.
+	for _, tc := range []struct {
+		name       string
+		repo       name.Repository
+		wantBuilds int
+	}{
+		{name: "hit", repo: repo, wantBuilds: 1},
+		{name: "miss", repo: repo.Registry.Repo("other"), wantBuilds: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			puller, err := NewCachingBuilder(rec, dir, "", WithCacheFrom(tc.repo))
+			if err != nil {
+				t.Fatalf("NewCachingBuilder() = %v", err)
+			}
+			got, err := puller.Build(ctx, ip)
+			if err != nil {
+				t.Fatalf("Build() = %v", err)
+			}
+			if hit := digest(t, got) == digest(t, built); hit != (tc.wantBuilds == 1) {
+				t.Errorf("Build() = %s, cached %s", digest(t, got), digest(t, built))
+			}
+			if got := len(rec.ImportPaths); got != tc.wantBuilds {
+				t.Errorf("inner builds = %d, want %d", got, tc.wantBuilds)
+			}
+		})
+	}
+}
+
+func TestCachingBuilderBuildOptions(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"cmd/app/main.go": mainGo,
+		"ca.crt":          "certificate",
+	})
+	ip := StrictScheme + "example.com/cached/cmd/app"
+	ctx := context.Background()
+
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	otherBase, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	baseImages := func(img v1.Image) Option {
+		return WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, img, nil })
+	}
+	config := func(c Config) Option {
+		return WithConfig(map[string]Config{"example.com/cached/cmd/app": c})
+	}
+	key := func(t *testing.T, opts ...Option) string {
+		t.Helper()
+		cb, err := NewCachingBuilder(&slowbuild{}, dir, "", WithCacheBuildOptions(opts...))
+		if err != nil {
+			t.Fatalf("NewCachingBuilder() = %v", err)
+		}
+		k, err := cb.key(ctx, ip)
+		if err != nil {
+			t.Fatalf("key() = %v", err)
+		}
+		return k
+	}
+
+	extraFiles := []FileEntry{{Src: "ca.crt", Dst: "/etc/ssl/ca.crt"}}
+	opts := []Option{baseImages(base), WithPlatforms("linux/amd64"), config(Config{Ldflags: []string{"-s"}, ExtraFiles: extraFiles})}
+	want := key(t, opts...)
+	if got := key(t, opts...); got != want {
+		t.Errorf("key() with the same options = %s, want %s", got, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "base image", opts: []Option{baseImages(otherBase)}},
+		{name: "platforms", opts: []Option{WithPlatforms("linux/amd64,linux/arm64")}},
+		{name: "ldflags", opts: []Option{config(Config{Ldflags: []string{"-w"}, ExtraFiles: extraFiles})}},
+		{name: "env", opts: []Option{config(Config{Ldflags: []string{"-s"}, Env: []string{"GOAMD64=v3"}, ExtraFiles: extraFiles})}},
+		{name: "labels", opts: []Option{WithLabel("foo", "bar")}},
+		{name: "sbom", opts: []Option{WithCycloneDX()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := key(t, append(append([]Option{}, opts...), tc.opts...)...); got == want {
+				t.Errorf("key() = %s, want a new key", got)
+			}
+		})
+	}
+
+	t.Run("extra file changed", func(t *testing.T) {
+		writeFile(t, filepath.Join(dir, "ca.crt"), "other certificate")
+		if got := key(t, opts...); got == want {
+			t.Errorf("key() = %s, want a new key", got)
+		}
+	})
+}
+
+// sbomBuild builds image indexes with SBOMs attached to them and to their
+// images, like the Go builder does.
+type sbomBuild struct{}
+
+func (sbomBuild) QualifyImport(ip string) (string, error) { return ip, nil }
+
+func (sbomBuild) IsSupportedReference(string) error { return nil }
+
+func (sbomBuild) Build(context.Context, string) (Result, error) {
+	var adds []ocimutate.IndexAddendum
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(256, 1)
+		if err != nil {
+			return nil, err
+		}
+		f, err := static.NewFile([]byte("sbom of "+arch), static.WithLayerMediaType("text/spdx+json"))
+		if err != nil {
+			return nil, err
+		}
+		si, err := ocimutate.AttachFileToImage(signed.Image(img), "sbom", f)
+		if err != nil {
+			return nil, err
+		}
+		adds = append(adds, ocimutate.IndexAddendum{
+			Add:        si,
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}},
+		})
+	}
+	f, err := static.NewFile([]byte("sbom of index"), static.WithLayerMediaType("text/spdx+json"))
+	if err != nil {
+		return nil, err
+	}
+	return ocimutate.AttachFileToImageIndex(ocimutate.AppendManifests(empty.Index, adds...), "sbom", f)
+}
+
+// sbomsByDigest returns the payloads of the SBOMs attached to r and to the
+// images it contains, by the digest of what they are attached to.
+func sbomsByDigest(t *testing.T, r Result) map[string]string {
+	t.Helper()
+	sboms := map[string]string{}
+	if err := walk.SignedEntity(context.Background(), r.(oci.SignedEntity), func(_ context.Context, se oci.SignedEntity) error {
+		f, err := se.Attachment("sbom")
+		if err != nil {
+			return nil
+		}
+		mt, err := f.FileMediaType()
+		if err != nil {
+			return err
+		}
+		payload, err := f.Payload()
+		if err != nil {
+			return err
+		}
+		h, err := se.Digest()
+		if err != nil {
+			return err
+		}
+		sboms[h.String()] = string(mt) + " " + string(payload)
+		return nil
+	}); err != nil {
+		t.Fatalf("walk.SignedEntity() = %v", err)
+	}
+	return sboms
+}
+
+func TestCachingBuilderSBOMs(t *testing.T) {
+	dir := writeModule(t, map[string]string{"cmd/app/main.go": mainGo})
+	ip := StrictScheme + "example.com/cached/cmd/app"
+	ctx := context.Background()
+
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+	repo, err := name.NewRepository(u.Host + "/cache")
+	if err != nil {
+		t.Fatalf("name.NewRepository() = %v", err)
+	}
+	cacheDir := t.TempDir()
+
+	rec := &Recorder{Builder: sbomBuild{}}
+	cb, err := NewCachingBuilder(rec, dir, cacheDir, WithCacheTo(repo))
+	if err != nil {
+		t.Fatalf("NewCachingBuilder() = %v", err)
+	}
+	built, err := cb.Build(ctx, ip)
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	want := sbomsByDigest(t, built)
+	if len(want) != 3 {
+		t.Fatalf("built SBOMs = %v, want 3", want)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		cacheDir string
+		opts     []CachingOption
+	}{
+		{name: "cache directory", cacheDir: cacheDir},
+		{name: "registry", opts: []CachingOption{WithCacheFrom(repo)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cb, err := NewCachingBuilder(rec, dir, tc.cacheDir, tc.opts...)
+			if err != nil {
+				t.Fatalf("NewCachingBuilder() = %v", err)
+			}
+			got, err := cb.Build(ctx, ip)
+			if err != nil {
+				t.Fatalf("Build() = %v", err)
+			}
+			if len(rec.ImportPaths) != 1 {
+				t.Fatalf("inner builds = %d, want 1", len(rec.ImportPaths))
+			}
+			if diff := cmp.Diff(want, sbomsByDigest(t, got)); diff != "" {
+				t.Errorf("cached SBOMs (-want +got): %s", diff)
+			}
+		})
+	}
+}
+
+func TestCachingBuilderListsEachPlatform(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"cmd/app/main.go":      mainGo,
+		"cmd/app/arm64.go":     "//go:build arm64\n\npackage main\n",
+		"cmd/app/extra.go":     "//go:build extra\n\npackage main\n",
+		"shared/index.html":    "hello",
+		"cmd/app/kodata/.keep": "",
+	})
+	if err := os.Symlink(filepath.Join(dir, "shared"), filepath.Join(dir, "cmd/app/kodata/shared")); err != nil {
+		t.Fatal(err)
+	}
+	ip := StrictScheme + "example.com/cached/cmd/app"
+	cb, err := NewCachingBuilder(&slowbuild{}, dir, "", WithCacheBuildOptions(
+		WithPlatforms("linux/amd64,linux/arm64"),
+		WithConfig(map[string]Config{"example.com/cached/cmd/app": {Flags: []string{"-tags=extra"}}}),
+	))
+	if err != nil {
+		t.Fatalf("NewCachingBuilder() = %v", err)
+	}
+	key := func(t *testing.T) string {
+		t.Helper()
+		k, err := cb.key(context.Background(), ip)
+		if err != nil {
+			t.Fatalf("key() = %v", err)
+		}
+		return k
+	}
+
+	for _, tc := range []struct {
+		name string
+		path string
+	}{
+		{name: "file of another platform", path: "cmd/app/arm64.go"},
+		{name: "file with build tag", path: "cmd/app/extra.go"},
+		{name: "symlinked kodata", path: "shared/index.html"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := key(t)
+			writeFile(t, filepath.Join(dir, tc.path), "//go:build arm64 || extra\n\npackage main\n// "+tc.name+"\n")
+			if got := key(t); got == before {
+				t.Errorf("key() = %s, want a new key", got)
+			}
+		})
+	}
+}
+
+// BenchmarkCachingBuilder compares building an image from scratch with
+// getting it from the cache.
+func BenchmarkCachingBuilder(b *testing.B) {
+	dir := writeModule(b, map[string]string{"cmd/app/main.go": mainGo})
+	ip := StrictScheme + "example.com/cached/cmd/app"
+	base, err := random.Image(1024, 3)
+	if err != nil {
+		b.Fatalf("random.Image() = %v", err)
+	}
+	ng, err := NewGo(context.Background(), dir,
+		WithBaseImages(func(context.Context, string) (name.Reference, Result, error) { return baseRef, base, nil }),
+		WithDisabledSBOM(),
+		WithPlatforms("all"),
+	)
+	if err != nil {
+		b.Fatalf("NewGo() = %v", err)
+	}
+	cb, err := NewCachingBuilder(ng, dir, b.TempDir())
+	if err != nil {
+		b.Fatalf("NewCachingBuilder() = %v", err)
+	}
+	if _, err := cb.Build(context.Background(), ip); err != nil {
+		b.Fatalf("Build() = %v", err)
+	}
+
+	for _, bc := range []struct {
+		name    string
+		builder Interface
+	}{
+		{name: "full rebuild", builder: ng},
+		{name: "cache hit", builder: cb},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := bc.builder.Build(context.Background(), ip); err != nil {
+					b.Fatalf("Build() = %v", err)
+				}
+			}
+		})
+	}
+}
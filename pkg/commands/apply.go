@@ -77,10 +77,11 @@ func addApply(topLevel *cobra.Command) {
 			if err != nil {
 				return fmt.Errorf("error creating builder: %w", err)
 			}
-			publisher, err := makePublisher(po)
+			publisher, err := makePublisher(po, bo)
 			if err != nil {
 				return fmt.Errorf("error creating publisher: %w", err)
 			}
+			publisher = withPostBuildHooks(publisher, bo)
 			defer publisher.Close()
 
 			// Issue a "kubectl apply" command reading from stdin,
@@ -114,7 +115,7 @@ func addApply(topLevel *cobra.Command) {
 					stdin.Write([]byte("---\n"))
 				}
 				// Once primed kick things off.
-				return ResolveFilesToWriter(ctx, builder, publisher, fo, so, stdin)
+				return ResolveFilesToWriter(ctx, builder, publisher, fo, so, stdin, resolveOptions(bo)...)
 			})
 
 			g.Go(func() error {
@@ -128,7 +129,7 @@ func addApply(topLevel *cobra.Command) {
 			return g.Wait()
 		},
 	}
-	options.AddPublishArg(apply, po)
+	options.AddPublishOptions(apply, po)
 	options.AddFileArg(apply, fo)
 	options.AddSelectorArg(apply, so)
 	options.AddBuildOptions(apply, bo)
@@ -72,10 +72,11 @@ func addBuild(topLevel *cobra.Command) {
 			if err != nil {
 				return fmt.Errorf("error creating builder: %w", err)
 			}
-			publisher, err := makePublisher(po)
+			publisher, err := makePublisher(po, bo)
 			if err != nil {
 				return fmt.Errorf("error creating publisher: %w", err)
 			}
+			publisher = withPostBuildHooks(publisher, bo)
 			defer publisher.Close()
 			images, err := publishImages(ctx, args, publisher, builder)
 			if err != nil {
@@ -87,7 +88,7 @@ func addBuild(topLevel *cobra.Command) {
 			return nil
 		},
 	}
-	options.AddPublishArg(build, po)
+	options.AddPublishOptions(build, po)
 	options.AddBuildOptions(build, bo)
 	topLevel.AddCommand(build)
 }
@@ -89,16 +89,7 @@ func getBaseImage(bo *options.BuildOptions) build.GetBase {
 
 	return func(ctx context.Context, s string) (name.Reference, build.Result, error) {
 		s = strings.TrimPrefix(s, build.StrictScheme)
-		// Viper configuration file keys are case insensitive, and are
-		// returned as all lowercase.  This means that import paths with
-		// uppercase must be normalized for matching here, e.g.
-		//    github.com/GoogleCloudPlatform/foo/cmd/bar
-		// comes through as:
-		//    github.com/googlecloudplatform/foo/cmd/bar
-		baseImage, ok := bo.BaseImageOverrides[strings.ToLower(s)]
-		if !ok || baseImage == "" {
-			baseImage = bo.BaseImage
-		}
+		baseImage := bo.BaseImageFor(s)
 		var nameOpts []name.Option
 		if bo.InsecureRegistry {
 			nameOpts = append(nameOpts, name.Insecure)
@@ -20,7 +20,9 @@ import (
 	"testing"
 
 	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 
+	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/commands/options"
 )
 
@@ -57,3 +59,50 @@ func TestOverrideDefaultBaseImageUsingBuildOption(t *testing.T) {
 		t.Errorf("got digest %s, wanted %s", gotDigest, wantDigest)
 	}
 }
+
+func TestBuildConfigBaseImage(t *testing.T) {
+	s, err := registryServerWithImage("static")
+	if err != nil {
+		t.Fatalf("could not create test registry server: %v", err)
+	}
+	defer s.Close()
+	registry := s.Listener.Addr().String()
+	dynamic, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image(): %v", err)
+	}
+	if err := crane.Push(dynamic, registry+"/dynamic"); err != nil {
+		t.Fatalf("crane.Push(): %v", err)
+	}
+
+	bo := &options.BuildOptions{
+		BaseImage: registry + "/default",
+		Platforms: []string{"all"},
+		BuildConfigs: map[string]build.Config{
+			"example.com/app/cmd/static":  {ID: "static", BaseImage: registry + "/static"},
+			"example.com/app/cmd/dynamic": {ID: "dynamic", BaseImage: registry + "/dynamic"},
+		},
+	}
+
+	baseFn := getBaseImage(bo)
+	for _, ip := range []string{"static", "dynamic"} {
+		wantDigest, err := crane.Digest(registry + "/" + ip)
+		if err != nil {
+			t.Fatalf("crane.Digest(%s): %v", ip, err)
+		}
+		ref, res, err := baseFn(context.Background(), "ko://example.com/app/cmd/"+ip)
+		if err != nil {
+			t.Fatalf("getBaseImage(%s): %v", ip, err)
+		}
+		if got, want := ref.Context().RepositoryStr(), ip; got != want {
+			t.Errorf("base of %s is from repository %s, wanted %s", ip, got, want)
+		}
+		digest, err := res.Digest()
+		if err != nil {
+			t.Fatalf("res.Digest(): %v", err)
+		}
+		if got := digest.String(); got != wantDigest {
+			t.Errorf("base of %s has digest %s, wanted %s", ip, got, wantDigest)
+		}
+	}
+}
@@ -77,10 +77,11 @@ func addCreate(topLevel *cobra.Command) {
 			if err != nil {
 				return fmt.Errorf("error creating builder: %w", err)
 			}
-			publisher, err := makePublisher(po)
+			publisher, err := makePublisher(po, bo)
 			if err != nil {
 				return fmt.Errorf("error creating publisher: %w", err)
 			}
+			publisher = withPostBuildHooks(publisher, bo)
 			defer publisher.Close()
 
 			// Issue a "kubectl create" command reading from stdin,
@@ -114,7 +115,7 @@ func addCreate(topLevel *cobra.Command) {
 					stdin.Write([]byte("---\n"))
 				}
 				// Once primed kick things off.
-				return ResolveFilesToWriter(ctx, builder, publisher, fo, so, stdin)
+				return ResolveFilesToWriter(ctx, builder, publisher, fo, so, stdin, resolveOptions(bo)...)
 			})
 
 			g.Go(func() error {
@@ -128,7 +129,7 @@ func addCreate(topLevel *cobra.Command) {
 			return g.Wait()
 		},
 	}
-	options.AddPublishArg(create, po)
+	options.AddPublishOptions(create, po)
 	options.AddFileArg(create, fo)
 	options.AddSelectorArg(create, so)
 	options.AddBuildOptions(create, bo)
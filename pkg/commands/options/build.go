@@ -15,15 +15,25 @@
 package options
 
 import (
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"io/fs"
+	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"golang.org/x/tools/go/packages"
+	"gopkg.in/yaml.v3"
 
 	"github.com/google/ko/pkg/build"
 )
@@ -31,12 +41,19 @@ import (
 const (
 	// configDefaultBaseImage is the default base image if not specified in .ko.yaml.
 	configDefaultBaseImage = "cgr.dev/chainguard/static:latest"
+
+	// MergeStrategyOverride only reads the `.ko.yaml` in the working directory.
+	MergeStrategyOverride = "override"
+	// MergeStrategyMerge reads every `.ko.yaml` from the module root down to
+	// the working directory, with configs deeper in the tree taking precedence.
+	MergeStrategyMerge = "merge"
 )
 
 // BuildOptions represents options for the ko builder.
 type BuildOptions struct {
-	// BaseImage enables setting the default base image programmatically.
-	// If non-empty, this takes precedence over the value in `.ko.yaml`.
+	// BaseImage enables setting the default base image programmatically, or
+	// with `--base-image`. If non-empty, it takes precedence over
+	// `defaultBaseImage` in `.ko.yaml`.
 	BaseImage string
 
 	// BaseImageOverrides stores base image overrides for import paths.
@@ -45,16 +62,66 @@ type BuildOptions struct {
 	// DefaultPlatforms defines the default platforms when Platforms is not explicitly defined
 	DefaultPlatforms []string
 
+	// DefaultPushRepo is the repository to publish images to when
+	// KO_DOCKER_REPO is unset. If empty, it is loaded from `.ko.yaml`.
+	DefaultPushRepo string
+
+	// SourceDateEpoch makes builds reproducible: it becomes the creation time
+	// of the images, and is passed to `go build`, along with -trimpath unless
+	// Trimpath is false. If nil, it is loaded from the SOURCE_DATE_EPOCH
+	// environment variable, or else from `sourceDateEpoch` in `.ko.yaml`.
+	SourceDateEpoch *time.Time
+
+	// RecordCreationTimestamp records the current time as the creation time
+	// of the images, for pipelines that audit when images were built. It
+	// conflicts with SourceDateEpoch.
+	RecordCreationTimestamp bool
+
 	// WorkingDirectory allows for setting the working directory for invocations of the `go` tool.
 	// Empty string means the current working directory.
 	WorkingDirectory string
 
+	// ConfigPath is the path to the `.ko.yaml` config file, or a directory
+	// containing one. If non-empty, this takes precedence over KO_CONFIG_PATH.
+	// The flags added by AddBuildOptions set it from `--config`, or else from
+	// the persistent `--ko-config-path`.
+	ConfigPath string
+
+	// ConfigFilePath is set by LoadConfig to the absolute path of the config
+	// file it read, or empty if there is none. With MergeStrategyMerge, it is
+	// the one closest to WorkingDirectory. Files it includes are not reported.
+	ConfigFilePath string
+
+	// ConcurrencyLimit limits the number of image references within resolved
+	// files that are built and published concurrently. Zero means no limit.
+	ConcurrencyLimit int
+
 	ConcurrentBuilds     int
 	DisableOptimizations bool
 	SBOM                 string
 	SBOMDir              string
-	Platforms            []string
-	Labels               []string
+	// SBOMFormat is the format of the SBOMs generated from the module graph
+	// of each import path and attached to its published images as OCI
+	// referrers, either "spdx" or "cyclonedx". Empty disables this.
+	SBOMFormat string
+	Platforms  []string
+	// Labels are added to the image as key=value pairs, after those from
+	// `.ko.yaml`, whose values have environment variables expanded by
+	// LoadConfig. Labels set here are used as is.
+	Labels []string
+	// Annotations are added to the image manifests as key=value pairs, after
+	// those from `.ko.yaml`.
+	Annotations []string
+
+	// StrictEnv makes LoadConfig fail when a label in `.ko.yaml` refers to an
+	// unset environment variable. Otherwise, such variables expand to the empty
+	// string with a warning. `AddBuildOptions()` defaults this field to `true`.
+	StrictEnv bool
+	// SkipMissing makes LoadConfig skip, with a warning, the build configs
+	// whose `dir` or `main` doesn't exist under WorkingDirectory, e.g. shared
+	// configs listing services that are optional in a checkout. Otherwise,
+	// such configs are an error.
+	SkipMissing bool
 	// UserAgent enables overriding the default value of the `User-Agent` HTTP
 	// request header used when retrieving the base image.
 	UserAgent string
@@ -67,8 +134,56 @@ type BuildOptions struct {
 	// `AddBuildOptions()` defaults this field to `true`.
 	Trimpath bool
 
+	// Watch makes `ko resolve` rebuild and re-resolve its input files whenever
+	// a Go source file of a referenced import path changes.
+	Watch bool
+
+	// WatchDebounce is how long Watch waits for changes to settle before
+	// rebuilding.
+	WatchDebounce time.Duration
+
+	// GoFlags are passed to every `go build` invocation, e.g. `-race`.
+	GoFlags []string
+
+	// GoBuildCache is the GOCACHE directory of every `go build` invocation,
+	// e.g. to isolate parallel runs of ko on the same host. Empty means the
+	// go tool's default.
+	GoBuildCache string
+
+	// CacheFrom is a repository to look up the results of builds in before
+	// building, e.g. registry.example.com/ko-cache, where they are tagged with
+	// a hash of the sources of each import path. See build.CachingBuilder.
+	CacheFrom string
+	// CacheTo is a repository to push the results of builds to, in the
+	// format that CacheFrom expects.
+	CacheTo string
+
+	// PostBuildHooks are shell commands run after each image is published,
+	// with `{IMAGE}` replaced by the published image reference.
+	PostBuildHooks []string
+
 	// BuildConfigs stores the per-image build config from `.ko.yaml`.
 	BuildConfigs map[string]build.Config
+
+	// Overlays are build configs that LoadConfig adds to those from the
+	// `builds` section of `.ko.yaml`, e.g. from `--overlay-config` flags. An
+	// overlay may not reuse the ID of another build config.
+	Overlays []build.Config
+
+	// MergeStrategy controls how `.ko.yaml` files in ancestor directories of
+	// WorkingDirectory are handled, either MergeStrategyOverride (the default)
+	// or MergeStrategyMerge. It has no effect when ConfigPath or
+	// KO_CONFIG_PATH is set.
+	MergeStrategy string
+}
+
+// AddConfigPathFlag registers the persistent --ko-config-path flag on the root
+// command, so that every subcommand accepts it. Commands with build options
+// copy it to ConfigPath unless --config is set, so it takes precedence over
+// KO_CONFIG_PATH, but not over the --config flag of a subcommand.
+func AddConfigPathFlag(root *cobra.Command) {
+	root.PersistentFlags().String("ko-config-path", "",
+		"Path to the .ko.yaml config file, or a directory containing one. Takes precedence over KO_CONFIG_PATH.")
 }
 
 func AddBuildOptions(cmd *cobra.Command, bo *BuildOptions) {
@@ -76,19 +191,219 @@ func AddBuildOptions(cmd *cobra.Command, bo *BuildOptions) {
 		"The maximum number of concurrent builds (default GOMAXPROCS)")
 	cmd.Flags().BoolVar(&bo.DisableOptimizations, "disable-optimizations", bo.DisableOptimizations,
 		"Disable optimizations when building Go code. Useful when you want to interactively debug the created container.")
-	cmd.Flags().StringVar(&bo.SBOM, "sbom", "spdx",
-		"The SBOM media type to use (none will disable SBOM synthesis and upload, also supports: spdx, cyclonedx, go.version-m).")
+	cmd.Flags().StringVar(&bo.SBOM, "sbom", "",
+		"The SBOM media type to use (none will disable SBOM synthesis and upload, also supports: spdx, cyclonedx, go.version-m). Defaults to the sbom set in .ko.yaml, or spdx.")
 	cmd.Flags().StringVar(&bo.SBOMDir, "sbom-dir", "",
 		"Path to file where the SBOM will be written.")
+	cmd.Flags().StringVar(&bo.SBOMFormat, "sbom-format", "",
+		"Attach an SBOM in this format (spdx or cyclonedx) to each pushed image as an OCI referrer. Disabled if empty.")
 	cmd.Flags().StringSliceVar(&bo.Platforms, "platform", []string{},
 		"Which platform to use when pulling a multi-platform base. Format: all | <os>[/<arch>[/<variant>]][,platform]*")
-	cmd.Flags().StringSliceVar(&bo.Labels, "image-label", []string{},
+	cmd.Flags().Var(labelsValue{labels: &bo.Labels, csv: true}, "image-label",
 		"Which labels (key=value) to add to the image.")
+	cmd.Flags().Var(labelsValue{labels: &bo.Labels}, "label",
+		"Label (key=value) to add to the image, taking precedence over .ko.yaml. May be repeated.")
+	cmd.Flags().Var(labelsValue{labels: &bo.Annotations}, "annotation",
+		"Annotation (key=value) to add to the image manifest, taking precedence over .ko.yaml. May be repeated.")
+	cmd.Flags().BoolVar(&bo.RecordCreationTimestamp, "record-creation-timestamp", bo.RecordCreationTimestamp,
+		"Record the current time as the creation time of the images. Builds are then not reproducible, so this cannot be combined with SOURCE_DATE_EPOCH or sourceDateEpoch in .ko.yaml.")
+	cmd.Flags().StringVar(&bo.BaseImage, "base-image", bo.BaseImage,
+		"Default base image, taking precedence over defaultBaseImage in .ko.yaml and KO_DEFAULTBASEIMAGE.")
+	cmd.Flags().StringVar(&bo.ConfigPath, "config", "",
+		"Path to the .ko.yaml config file, or a directory containing one. Takes precedence over KO_CONFIG_PATH.")
+	cmd.Flags().StringArrayVar(&bo.GoFlags, "go-flag", []string{},
+		"Additional flag to pass to go build, e.g. -race. May be repeated.")
+	cmd.Flags().StringVar(&bo.GoBuildCache, "go-build-cache", "",
+		"GOCACHE directory for go build, instead of the go tool's default.")
+	cmd.Flags().StringArrayVar(&bo.PostBuildHooks, "post-build-hook", []string{},
+		"Shell command to run after each image is published, with {IMAGE} replaced by the image reference. May be repeated.")
+	cmd.Flags().Var(buildConfigsValue{&bo.Overlays}, "overlay-config",
+		`Build config to add to those from .ko.yaml, as JSON, e.g. '{"id":"svc","main":"./cmd/svc"}'. May be repeated.`)
+	cmd.Flags().StringVar(&bo.CacheFrom, "cache-from", "",
+		"Repository to pull the results of builds from instead of building, if their sources are unchanged.")
+	cmd.Flags().StringVar(&bo.CacheTo, "cache-to", "",
+		"Repository to push the results of builds to, for later use with --cache-from.")
 	bo.Trimpath = true
+	bo.StrictEnv = true
+	cmd.Flags().Var(negatedBoolValue{&bo.Trimpath}, "no-trimpath",
+		"Don't pass -trimpath to go build, keeping file paths in stack traces.")
+	cmd.Flags().Lookup("no-trimpath").NoOptDefVal = "true"
+
+	preRunE := cmd.PreRunE
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		if f := cmd.Flags().Lookup("ko-config-path"); f != nil && bo.ConfigPath == "" {
+			bo.ConfigPath = f.Value.String()
+		}
+		if preRunE != nil {
+			return preRunE(cmd, args)
+		}
+		return nil
+	}
+}
+
+// labelsValue is a flag value that appends labels to a slice, so that
+// `--image-label` and `--label` can be combined. With csv, a value may hold
+// several comma-separated labels, like for a StringSlice flag.
+type labelsValue struct {
+	labels *[]string
+	csv    bool
+}
+
+func (l labelsValue) String() string {
+	if l.labels == nil || len(*l.labels) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(*l.labels, ",") + "]"
+}
+
+func (l labelsValue) Set(s string) error {
+	if !l.csv {
+		*l.labels = append(*l.labels, s)
+		return nil
+	}
+	labels, err := csv.NewReader(strings.NewReader(s)).Read()
+	if err != nil {
+		return err
+	}
+	*l.labels = append(*l.labels, labels...)
+	return nil
+}
+
+func (l labelsValue) Type() string {
+	if l.csv {
+		return "strings"
+	}
+	return "stringArray"
+}
+
+// buildConfigsValue is a flag value that appends a build config, given with
+// the same keys as an entry of the `builds` section of `.ko.yaml`, as JSON.
+type buildConfigsValue struct {
+	configs *[]build.Config
+}
+
+func (b buildConfigsValue) String() string {
+	if b.configs == nil || len(*b.configs) == 0 {
+		return ""
+	}
+	ids := make([]string, 0, len(*b.configs))
+	for _, config := range *b.configs {
+		ids = append(ids, config.ID)
+	}
+	return "[" + strings.Join(ids, ",") + "]"
+}
+
+func (b buildConfigsValue) Set(s string) error {
+	// JSON is a subset of YAML, so decoding as YAML gets the same keys and
+	// shorthands, like a single string for `ldflags`, as `.ko.yaml`.
+	dec := yaml.NewDecoder(strings.NewReader(s))
+	dec.KnownFields(true)
+	var config build.Config
+	if err := dec.Decode(&config); err != nil {
+		return fmt.Errorf("parsing build config: %w", err)
+	}
+	*b.configs = append(*b.configs, config)
+	return nil
+}
+
+func (b buildConfigsValue) Type() string {
+	return "json"
+}
+
+// negatedBoolValue is a boolean flag value that stores the opposite of what
+// is passed, e.g. `--no-trimpath` sets Trimpath to false.
+type negatedBoolValue struct {
+	b *bool
+}
+
+func (n negatedBoolValue) String() string {
+	if n.b == nil {
+		return "false"
+	}
+	return strconv.FormatBool(!*n.b)
+}
+
+func (n negatedBoolValue) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	*n.b = !v
+	return nil
+}
+
+func (n negatedBoolValue) Type() string {
+	return "bool"
+}
+
+// ConfigErrorKind classifies the errors returned by LoadConfig.
+type ConfigErrorKind int
+
+const (
+	// ConfigInvalid means that the config is malformed, e.g. it does not
+	// match the config schema or refers to import paths that don't exist.
+	ConfigInvalid ConfigErrorKind = iota
+	// ConfigNotFound means that a config file that was asked for, with
+	// ConfigPath, KO_CONFIG_PATH or an include, does not exist. A missing
+	// `.ko.yaml` in the working directory is not an error.
+	ConfigNotFound
+	// ConfigUnreadable means that a config file exists, but cannot be read
+	// or is not valid YAML or TOML.
+	ConfigUnreadable
+)
+
+func (k ConfigErrorKind) String() string {
+	switch k {
+	case ConfigNotFound:
+		return "not found"
+	case ConfigUnreadable:
+		return "unreadable"
+	default:
+		return "invalid"
+	}
+}
+
+// ConfigError is the type of every error returned by LoadConfig.
+type ConfigError struct {
+	Kind       ConfigErrorKind
+	Underlying error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Underlying.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Underlying
+}
+
+// readError returns a ConfigError for err, which occurred while looking for or
+// reading a config file.
+func readError(err error) error {
+	kind := ConfigUnreadable
+	var ce *ConfigError
+	if errors.As(err, &ce) {
+		kind = ce.Kind
+	} else if errors.Is(err, fs.ErrNotExist) {
+		kind = ConfigNotFound
+	}
+	return &ConfigError{Kind: kind, Underlying: err}
 }
 
 // LoadConfig reads build configuration from defaults, environment variables, and the `.ko.yaml` config file.
+// Errors are of type *ConfigError.
 func (bo *BuildOptions) LoadConfig() error {
+	err := bo.loadConfig()
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*ConfigError); ok {
+		return err
+	}
+	return &ConfigError{Kind: ConfigInvalid, Underlying: err}
+}
+
+func (bo *BuildOptions) loadConfig() error {
 	v := viper.New()
 	if bo.WorkingDirectory == "" {
 		bo.WorkingDirectory = "."
@@ -101,33 +416,79 @@ func (bo *BuildOptions) LoadConfig() error {
 	v.SetEnvPrefix("KO")
 	v.AutomaticEnv()
 
-	if override := os.Getenv("KO_CONFIG_PATH"); override != "" {
+	override := bo.ConfigPath
+	if override == "" {
+		override = os.Getenv("KO_CONFIG_PATH")
+	}
+	if override != "" {
 		file, err := os.Stat(override)
 		if err != nil {
-			return fmt.Errorf("error looking for config file: %w", err)
+			return readError(fmt.Errorf("error looking for config file: %w", err))
 		}
 		if file.Mode().IsRegular() {
 			v.SetConfigFile(override)
 		} else if file.IsDir() {
-			path := filepath.Join(override, ".ko.yaml")
+			path := configFileInDir(override)
 			file, err = os.Stat(path)
 			if err != nil {
-				return fmt.Errorf("error looking for config file: %w", err)
+				return readError(fmt.Errorf("error looking for config file: %w", err))
 			}
 			if file.Mode().IsRegular() {
 				v.SetConfigFile(path)
 			} else {
-				return fmt.Errorf("config file %s is not a regular file", path)
+				return &ConfigError{Kind: ConfigUnreadable, Underlying: fmt.Errorf("config file %s is not a regular file", path)}
 			}
 		} else {
-			return fmt.Errorf("config file %s is not a regular file", override)
+			return &ConfigError{Kind: ConfigUnreadable, Underlying: fmt.Errorf("config file %s is not a regular file", override)}
 		}
+	} else if path := configFileInDir(bo.WorkingDirectory); isRegularFile(path) {
+		// Pick the config file the same way as for KO_CONFIG_PATH, rather
+		// than the first extension viper happens to support.
+		v.SetConfigFile(path)
 	}
 	v.AddConfigPath(bo.WorkingDirectory)
 
-	if err := v.ReadInConfig(); err != nil {
+	switch bo.MergeStrategy {
+	case "", MergeStrategyOverride, MergeStrategyMerge:
+	default:
+		return fmt.Errorf("unknown merge strategy %q, expected %q or %q", bo.MergeStrategy, MergeStrategyOverride, MergeStrategyMerge)
+	}
+
+	var paths []string
+	if bo.MergeStrategy == MergeStrategyMerge && override == "" {
+		var err error
+		paths, err = findConfigFiles(bo.WorkingDirectory)
+		if err != nil {
+			return readError(fmt.Errorf("error looking for config files: %w", err))
+		}
+	} else if err := v.ReadInConfig(); err != nil {
 		if !errors.As(err, &viper.ConfigFileNotFoundError{}) {
-			return fmt.Errorf("error reading config file: %w", err)
+			return readError(fmt.Errorf("error reading config file: %w", err))
+		}
+	} else {
+		paths = []string{v.ConfigFileUsed()}
+	}
+	bo.ConfigFilePath = ""
+	if len(paths) > 0 {
+		path, err := filepath.Abs(paths[len(paths)-1])
+		if err != nil {
+			return readError(fmt.Errorf("error reading config file: %w", err))
+		}
+		bo.ConfigFilePath = path
+	}
+	for _, path := range paths {
+		files, err := withIncludes(path, nil)
+		if err != nil {
+			return readError(fmt.Errorf("error reading config file %s: %w", path, err))
+		}
+		for _, file := range files {
+			v.SetConfigFile(file)
+			if err := v.MergeInConfig(); err != nil {
+				return readError(fmt.Errorf("error reading config file %s: %w", file, err))
+			}
+			if err := validateConfigFile(file); err != nil {
+				return fmt.Errorf("invalid config file %s: %w", file, err)
+			}
 		}
 	}
 
@@ -136,12 +497,92 @@ func (bo *BuildOptions) LoadConfig() error {
 		bo.DefaultPlatforms = dp
 	}
 
+	// Platforms passed as flags take precedence over those in `.ko.yaml`.
+	if len(bo.Platforms) == 0 {
+		bo.Platforms = v.GetStringSlice("platforms")
+	}
+
+	for _, flag := range bo.GoFlags {
+		name, _, _ := strings.Cut(flag, "=")
+		switch name {
+		case "-o", "--o", "-v", "--v":
+			return fmt.Errorf("go flag %q cannot be set, as ko already handles it", flag)
+		}
+	}
+	// GOFLAGS applies to the go build of ko too. CI environments often set it
+	// for other tools, so flags that break ko's builds are only warned about.
+	for _, flag := range strings.Fields(os.Getenv("GOFLAGS")) {
+		if reason := incompatibleGoFlag(flag); reason != "" {
+			log.Printf("WARNING: GOFLAGS contains %s, which is incompatible with ko: %s", flag, reason)
+		}
+	}
+
+	labels, err := configLabels(v)
+	if err != nil {
+		return err
+	}
+	// Only labels from `.ko.yaml` are expanded, as the shell already expanded
+	// those passed as flags.
+	if err := expandLabels(labels, bo.StrictEnv); err != nil {
+		return err
+	}
+	bo.Labels = mergeKeyValues(labels, bo.Labels)
+	bo.Annotations = mergeKeyValues(v.GetStringSlice("annotations"), bo.Annotations)
+
+	if bo.DefaultPushRepo == "" {
+		bo.DefaultPushRepo = v.GetString("defaultPushRepo")
+	}
+
+	if bo.SourceDateEpoch == nil {
+		if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+			seconds, err := strconv.ParseInt(epoch, 10, 64)
+			if err != nil {
+				return fmt.Errorf("the environment variable SOURCE_DATE_EPOCH should be the number of seconds since January 1st 1970, 00:00 UTC, got: %w", err)
+			}
+			t := time.Unix(seconds, 0)
+			bo.SourceDateEpoch = &t
+		} else if v.IsSet("sourceDateEpoch") {
+			seconds, err := strconv.ParseInt(v.GetString("sourceDateEpoch"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("'sourceDateEpoch' should be the number of seconds since January 1st 1970, 00:00 UTC, got: %w", err)
+			}
+			t := time.Unix(seconds, 0)
+			bo.SourceDateEpoch = &t
+		}
+	}
+	if bo.RecordCreationTimestamp && bo.SourceDateEpoch != nil {
+		return errors.New("--record-creation-timestamp cannot be combined with SOURCE_DATE_EPOCH or sourceDateEpoch in .ko.yaml, which make builds reproducible")
+	}
+
+	// An SBOM type passed as a flag takes precedence over the one in `.ko.yaml`.
+	if bo.SBOM == "" {
+		bo.SBOM = v.GetString("sbom")
+	}
+	switch bo.SBOM {
+	case "":
+		bo.SBOM = "spdx"
+	case "none", "spdx", "cyclonedx", "go.version-m":
+	default:
+		return fmt.Errorf("unsupported SBOM type %q, expected one of none, spdx, cyclonedx, go.version-m", bo.SBOM)
+	}
+
 	if bo.BaseImage == "" {
 		ref := v.GetString("defaultBaseImage")
 		if _, err := name.ParseReference(ref); err != nil {
 			return fmt.Errorf("'defaultBaseImage': error parsing %q as image reference: %w", ref, err)
 		}
 		bo.BaseImage = ref
+	} else if _, err := name.ParseReference(bo.BaseImage); err != nil {
+		return fmt.Errorf("BaseImage %q is not a valid image reference: %w", bo.BaseImage, err)
+	}
+
+	for flag, repo := range map[string]string{"--cache-from": bo.CacheFrom, "--cache-to": bo.CacheTo} {
+		if repo == "" {
+			continue
+		}
+		if _, err := name.NewRepository(repo); err != nil {
+			return fmt.Errorf("%s: error parsing %q as repository: %w", flag, repo, err)
+		}
 	}
 
 	if len(bo.BaseImageOverrides) == 0 {
@@ -161,24 +602,481 @@ func (bo *BuildOptions) LoadConfig() error {
 		if err := v.UnmarshalKey("builds", &builds); err != nil {
 			return fmt.Errorf("configuration section 'builds' cannot be parsed")
 		}
-		buildConfigs, err := createBuildConfigMap(bo.WorkingDirectory, builds)
+		if err := checkUniqueIDs(builds); err != nil {
+			return err
+		}
+		if err := checkOverlayIDs(builds, bo.Overlays); err != nil {
+			return err
+		}
+		builds = append(builds, bo.Overlays...)
+		buildConfigs, err := createBuildConfigMap(bo.WorkingDirectory, builds, bo.SkipMissing)
 		if err != nil {
 			return fmt.Errorf("could not create build config map: %w", err)
 		}
 		bo.BuildConfigs = buildConfigs
 	}
 
+	for _, config := range bo.BuildConfigs {
+		if config.BinaryName != "" && (strings.ContainsAny(config.BinaryName, `/\`) || config.BinaryName == "." || config.BinaryName == "..") {
+			return fmt.Errorf("build config %q: 'binaryName': %q should be a file name, not a path", config.ID, config.BinaryName)
+		}
+		for _, f := range config.ExtraFiles {
+			if f.Src == "" {
+				return fmt.Errorf("build config %q: 'extraFiles': missing src for %q", config.ID, f.Dst)
+			}
+			if !path.IsAbs(f.Dst) || path.Clean(f.Dst) == "/" {
+				return fmt.Errorf("build config %q: 'extraFiles': dst %q should be an absolute file path in the image", config.ID, f.Dst)
+			}
+		}
+		if err := validatePlatforms(config.Platforms); err != nil {
+			return fmt.Errorf("build config %q: 'platforms': %w", config.ID, err)
+		}
+		if config.BaseImage == "" {
+			continue
+		}
+		if _, err := name.ParseReference(config.BaseImage); err != nil {
+			return fmt.Errorf("build config %q: 'baseImage': error parsing %q as image reference: %w", config.ID, config.BaseImage, err)
+		}
+	}
+
+	return bo.validateCGOBaseImages()
+}
+
+// validatePlatforms checks platforms in the format of the --platform flag:
+// either just "all", or platforms like linux/arm64/v8 or windows/amd64:10.0.17763.
+// A platform may leave out trailing parts, e.g. linux matches every Linux
+// platform.
+func validatePlatforms(platforms []string) error {
+	for _, platform := range platforms {
+		if platform == "all" {
+			if len(platforms) > 1 {
+				return errors.New(`"all" cannot be combined with other platforms`)
+			}
+			continue
+		}
+		if strings.Count(platform, ":") > 1 {
+			return fmt.Errorf("invalid platform %q: more than one OS version", platform)
+		}
+		spec, _, _ := strings.Cut(platform, ":")
+		for _, part := range strings.Split(spec, "/") {
+			if part == "" {
+				return fmt.Errorf("invalid platform %q: expected os[/arch[/variant]][:osversion], e.g. linux/arm64", platform)
+			}
+		}
+		if _, err := v1.ParsePlatform(platform); err != nil {
+			return fmt.Errorf("invalid platform %q: %w", platform, err)
+		}
+	}
+	return nil
+}
+
+// staticBaseImages are repositories of base images that are known to have no
+// libc, which binaries built with cgo need. Each also matches its variants,
+// e.g. gcr.io/distroless/static-debian12.
+var staticBaseImages = []string{
+	"cgr.dev/chainguard/static",
+	"gcr.io/distroless/static",
+}
+
+// validateCGOBaseImages checks that the build configs that enable cgo don't
+// use a base image without libc.
+func (bo *BuildOptions) validateCGOBaseImages() error {
+	importPaths := make([]string, 0, len(bo.BuildConfigs))
+	for importPath, config := range bo.BuildConfigs {
+		if config.CGOEnabled != nil && *config.CGOEnabled {
+			importPaths = append(importPaths, importPath)
+		}
+	}
+	sort.Strings(importPaths)
+
+	var errs []error
+	for _, importPath := range importPaths {
+		baseImage := bo.BaseImageFor(importPath)
+		ref, err := name.ParseReference(baseImage)
+		if err != nil {
+			// Invalid base images are reported when they are loaded.
+			continue
+		}
+		repo := ref.Context().Name()
+		for _, static := range staticBaseImages {
+			if rest, ok := strings.CutPrefix(repo, static); ok && (rest == "" || rest[0] == '-') {
+				errs = append(errs, fmt.Errorf("build config %q enables cgo, but its base image %s has no libc; set 'baseImage' to an image with libc", bo.BuildConfigs[importPath].ID, baseImage))
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// BaseImageFor returns the base image for the given import path, which may be
+// prefixed with the ko:// scheme. An entry in BaseImageOverrides comes first,
+// then the baseImage of the import path's build config, then BaseImage.
+func (bo *BuildOptions) BaseImageFor(importPath string) string {
+	importPath = strings.TrimPrefix(importPath, build.StrictScheme)
+	// Viper configuration file keys are case insensitive, and are
+	// returned as all lowercase.  This means that import paths with
+	// uppercase must be normalized for matching here, e.g.
+	//    github.com/GoogleCloudPlatform/foo/cmd/bar
+	// comes through as:
+	//    github.com/googlecloudplatform/foo/cmd/bar
+	if baseImage := bo.BaseImageOverrides[strings.ToLower(importPath)]; baseImage != "" {
+		return baseImage
+	}
+	if config, ok := bo.BuildConfigs[importPath]; ok && config.BaseImage != "" {
+		return config.BaseImage
+	}
+	return bo.BaseImage
+}
+
+// GetBuildConfig returns the build config for the given import path, which
+// may be prefixed with the ko:// scheme. If there is no build config for the
+// exact import path, the build config whose key is the longest suffix of the
+// import path is returned, e.g. a build config stored as "cmd/app" is returned
+// for "example.com/repo/cmd/app".
+func (bo *BuildOptions) GetBuildConfig(importPath string) (build.Config, bool) {
+	importPath = strings.TrimPrefix(importPath, build.StrictScheme)
+	if config, ok := bo.BuildConfigs[importPath]; ok {
+		return config, true
+	}
+
+	var (
+		match  string
+		config build.Config
+		found  bool
+	)
+	for key, c := range bo.BuildConfigs {
+		if len(key) <= len(match) || !strings.HasSuffix(importPath, "/"+key) {
+			continue
+		}
+		match, config, found = key, c, true
+	}
+	return config, found
+}
+
+// configFileNames are the names of the config files ko looks for, in order
+// of preference.
+var configFileNames = []string{".ko.yaml", ".ko.yml", ".ko.toml"}
+
+// configFileInDir returns the path of the first config file that exists in
+// dir, or the path of `.ko.yaml` in dir if there is none.
+func configFileInDir(dir string) string {
+	for _, name := range configFileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(dir, configFileNames[0])
+}
+
+func isRegularFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// withIncludes returns the config files to merge for the config file at
+// path, in order: the files listed in its `include` key, each preceded by the
+// files it includes in turn, and then path itself. Like config files in
+// ancestor directories, included files are overridden by the files that
+// include them. Relative includes are relative to the including file. stack
+// holds the files that (transitively) include path, to detect cycles.
+func withIncludes(path string, stack []string) ([]string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range stack {
+		if s == abs {
+			return nil, &ConfigError{Kind: ConfigInvalid, Underlying: fmt.Errorf("include cycle: %s", strings.Join(append(stack, abs), " -> "))}
+		}
+	}
+	// Don't let siblings share the backing array of stack.
+	stack = append(stack[:len(stack):len(stack)], abs)
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, include := range v.GetStringSlice("include") {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		included, err := withIncludes(include, stack)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, included...)
+	}
+	return append(files, path), nil
+}
+
+// findConfigFiles returns the config files found walking up from dir to the
+// module root (the closest directory containing a `go.mod` file), ordered
+// from the module root down to dir. If dir is not part of a module, only the
+// config file in dir is considered.
+func findConfigFiles(dir string) ([]string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for current := dir; ; {
+		if path := configFileInDir(current); isRegularFile(path) {
+			paths = append([]string{path}, paths...)
+		}
+		if _, err := os.Stat(filepath.Join(current, "go.mod")); err == nil {
+			return paths, nil
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			break
+		}
+		current = parent
+	}
+
+	// No module root found, so only use the config in dir.
+	if path := configFileInDir(dir); isRegularFile(path) {
+		return []string{path}, nil
+	}
+	return nil, nil
+}
+
+// checkUniqueIDs returns an error for each ID used by more than one of
+// configs, since that is most likely a copy-paste mistake. Entries without an
+// ID are identified by their index instead.
+func checkUniqueIDs(configs []build.Config) error {
+	first := make(map[string]int, len(configs))
+	var errs []error
+	for i, config := range configs {
+		if config.ID == "" {
+			continue
+		}
+		if j, ok := first[config.ID]; ok {
+			errs = append(errs, fmt.Errorf("'builds': entries #%d and #%d have the same ID '%s'", j, i, config.ID))
+			continue
+		}
+		first[config.ID] = i
+	}
+	return errors.Join(errs...)
+}
+
+// checkOverlayIDs returns an error for each overlay whose ID is already used by
+// one of configs or by an earlier overlay.
+func checkOverlayIDs(configs, overlays []build.Config) error {
+	ids := make(map[string]bool, len(configs)+len(overlays))
+	for _, config := range configs {
+		ids[config.ID] = true
+	}
+	var errs []error
+	for i, overlay := range overlays {
+		if overlay.ID == "" {
+			continue
+		}
+		if ids[overlay.ID] {
+			errs = append(errs, fmt.Errorf("overlay config #%d: a build config with ID '%s' already exists", i, overlay.ID))
+			continue
+		}
+		ids[overlay.ID] = true
+	}
+	return errors.Join(errs...)
+}
+
+// mergeKeyValues returns the key=value pairs of config followed by those of
+// flags. Pairs passed as flags win over those from .ko.yaml with the same
+// key, which are left out.
+func mergeKeyValues(config, flags []string) []string {
+	if len(config) == 0 {
+		return flags
+	}
+	flagKeys := make(map[string]bool, len(flags))
+	for _, kv := range flags {
+		key, _, _ := strings.Cut(kv, "=")
+		flagKeys[key] = true
+	}
+	merged := make([]string, 0, len(config)+len(flags))
+	for _, kv := range config {
+		if key, _, _ := strings.Cut(kv, "="); !flagKeys[key] {
+			merged = append(merged, kv)
+		}
+	}
+	return append(merged, flags...)
+}
+
+// LabelEntry is a label of the `.ko.yaml` labels list, written as an object
+// rather than a plain key=value string so that it can be conditional.
+type LabelEntry struct {
+	// Value is the label as key=value.
+	Value string
+	// When is a condition on the environment that must hold for the label to
+	// be added: NAME=value, NAME!=value, or just NAME for a variable that is
+	// set and not empty. The label is always added if When is empty.
+	When string
+}
+
+// holds reports whether the condition of e is met in the current environment.
+func (e LabelEntry) holds() bool {
+	if e.When == "" {
+		return true
+	}
+	if name, want, ok := strings.Cut(e.When, "!="); ok {
+		return os.Getenv(strings.TrimSpace(name)) != strings.TrimSpace(want)
+	}
+	if name, want, ok := strings.Cut(e.When, "="); ok {
+		return os.Getenv(strings.TrimSpace(name)) == strings.TrimSpace(want)
+	}
+	return os.Getenv(strings.TrimSpace(e.When)) != ""
+}
+
+// configLabels returns the labels of the config file whose conditions hold.
+// Each label is either a key=value string or a LabelEntry.
+func configLabels(v *viper.Viper) ([]string, error) {
+	items, ok := v.Get("labels").([]interface{})
+	if !ok {
+		return v.GetStringSlice("labels"), nil
+	}
+	labels := make([]string, 0, len(items))
+	for i, item := range items {
+		if label, ok := item.(string); ok {
+			labels = append(labels, label)
+			continue
+		}
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("'labels': entry #%d must be a string or an object, got %T", i, item)
+		}
+		var entry LabelEntry
+		for k, f := range fields {
+			switch {
+			case strings.EqualFold(k, "value"):
+				entry.Value = fmt.Sprint(f)
+			case strings.EqualFold(k, "when"):
+				entry.When = fmt.Sprint(f)
+			}
+		}
+		if entry.holds() {
+			labels = append(labels, entry.Value)
+		}
+	}
+	return labels, nil
+}
+
+// expandLabels expands the environment variables in the values of labels in
+// place, with `$$` producing a literal `$`. Unless strictEnv is set, unset
+// variables expand to the empty string with a warning.
+func expandLabels(labels []string, strictEnv bool) error {
+	for i, label := range labels {
+		key, value, found := strings.Cut(label, "=")
+		if !found {
+			continue
+		}
+		var unset []string
+		value = os.Expand(value, func(name string) string {
+			// `$$` is an escaped `$`.
+			if name == "$" {
+				return "$"
+			}
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				unset = append(unset, name)
+			}
+			return v
+		})
+		if len(unset) > 0 {
+			if strictEnv {
+				return fmt.Errorf("label %q refers to unset environment variables: %s", key, strings.Join(unset, ", "))
+			}
+			log.Printf("WARNING: label %q refers to unset environment variables, expanding them to empty strings: %s", key, strings.Join(unset, ", "))
+		}
+		labels[i] = key + "=" + value
+	}
 	return nil
 }
 
-func createBuildConfigMap(workingDirectory string, configs []build.Config) (map[string]build.Config, error) {
-	buildConfigsByImportPath := make(map[string]build.Config)
+// incompatibleGoFlag returns why flag, from GOFLAGS, breaks ko's builds, or
+// the empty string if it doesn't.
+func incompatibleGoFlag(flag string) string {
+	name, value, _ := strings.Cut(strings.TrimLeft(flag, "-"), "=")
+	switch name {
+	case "o", "v":
+		return "ko already handles it"
+	case "n":
+		return "go build only prints its commands, without building a binary"
+	case "work":
+		return "go build keeps its temporary work directories"
+	case "buildmode":
+		switch value {
+		case "", "default", "exe", "pie":
+		default:
+			return "ko needs go build to build an executable"
+		}
+	}
+	return ""
+}
+
+// buildEntry is a build config along with its index in the 'builds' section.
+type buildEntry struct {
+	index  int
+	config build.Config
+}
+
+// expandDirGlobs defaults the ID of each build config to its index, and
+// replaces each build config whose Dir is a glob pattern, e.g. services/*,
+// with one build config per directory that the pattern matches. The
+// expanded build configs have IDs like "servers[services/foo]". Patterns
+// that match no directories are reported in errs.
+func expandDirGlobs(workingDirectory string, configs []build.Config, errs *[]error) []buildEntry {
+	var entries []buildEntry
 	for i, config := range configs {
 		// In case no ID is specified, use the index of the build config in
 		// the ko YAML file as a reference (debug help).
 		if config.ID == "" {
 			config.ID = fmt.Sprintf("#%d", i)
 		}
+		if !strings.ContainsAny(config.Dir, "*?[") {
+			entries = append(entries, buildEntry{index: i, config: config})
+			continue
+		}
+
+		base := config.BaseDirectory(workingDirectory)
+		matches, err := filepath.Glob(filepath.Join(base, config.Dir))
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("'builds': entry #%d: dir %q: %w", i, config.Dir, err))
+			continue
+		}
+		var dirs []string
+		for _, match := range matches {
+			if fi, err := os.Stat(match); err != nil || !fi.IsDir() {
+				continue
+			}
+			dir, err := filepath.Rel(filepath.Join(base, "."), match)
+			if err != nil {
+				*errs = append(*errs, fmt.Errorf("'builds': entry #%d: %w", i, err))
+				continue
+			}
+			dirs = append(dirs, filepath.ToSlash(dir))
+		}
+		if len(dirs) == 0 {
+			*errs = append(*errs, fmt.Errorf("'builds': entry #%d: dir %q matches no directories", i, config.Dir))
+			continue
+		}
+		for _, dir := range dirs {
+			expanded := config
+			expanded.ID = fmt.Sprintf("%s[%s]", config.ID, dir)
+			expanded.Dir = dir
+			entries = append(entries, buildEntry{index: i, config: expanded})
+		}
+	}
+	return entries
+}
+
+func createBuildConfigMap(workingDirectory string, configs []build.Config, skipMissing bool) (map[string]build.Config, error) {
+	buildConfigsByImportPath := make(map[string]build.Config)
+	var errs []error
+	for _, entry := range expandDirGlobs(workingDirectory, configs, &errs) {
+		i, config := entry.index, entry.config
 
 		// Make sure to behave like GoReleaser by defaulting to the current
 		// directory in case the build or main field is not set, check
@@ -191,7 +1089,14 @@ func createBuildConfigMap(workingDirectory string, configs []build.Config) (map[
 		}
 
 		// baseDir is the directory where `go list` will be run to look for package information
-		baseDir := filepath.Join(workingDirectory, config.Dir)
+		baseDir := filepath.Join(config.BaseDirectory(workingDirectory), config.Dir)
+
+		// Resolve symlinks, so that the module containing the directory is
+		// found the same way the go command finds it. If this fails, the
+		// directory doesn't exist, which is reported below.
+		if resolved, err := filepath.EvalSymlinks(baseDir); err == nil {
+			baseDir = resolved
+		}
 
 		// To behave like GoReleaser, check whether the configured `main` config value points to a
 		// source file, and if so, just use the directory it is in
@@ -202,7 +1107,12 @@ func createBuildConfigMap(workingDirectory string, configs []build.Config) (map[
 
 		// Verify that the path actually leads to a local file (https://github.com/google/ko/issues/483)
 		if _, err := os.Stat(filepath.Join(baseDir, path)); err != nil {
-			return nil, err
+			if skipMissing && errors.Is(err, fs.ErrNotExist) {
+				log.Printf("WARNING: skipping build config %q, entry #%d, whose path %s does not exist", config.ID, i, filepath.Join(baseDir, path))
+				continue
+			}
+			errs = append(errs, fmt.Errorf("'builds': entry #%d: %w", i, err))
+			continue
 		}
 
 		// By default, paths configured in the builds section are considered
@@ -210,20 +1120,52 @@ func createBuildConfigMap(workingDirectory string, configs []build.Config) (map[
 		// the constructured import path
 		localImportPath := fmt.Sprint(".", string(filepath.Separator), path)
 		dir := filepath.Clean(baseDir)
+
+		// In a workspace, the nearest go.mod may belong to a module that
+		// isn't used by the workspace, so look the package up from the
+		// workspace module that contains it instead.
+		if workFile := findWorkFile(dir); workFile != "" {
+			pkgDir, err := filepath.Abs(filepath.Join(dir, path))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("'builds': entry #%d: %w", i, err))
+				continue
+			}
+			moduleDir, err := workspaceModuleFor(workFile, pkgDir)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("'builds': entry #%d: %w", i, err))
+				continue
+			}
+			rel, err := filepath.Rel(moduleDir, pkgDir)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("'builds': entry #%d: %w", i, err))
+				continue
+			}
+			localImportPath = fmt.Sprint(".", string(filepath.Separator), rel)
+			dir = moduleDir
+		}
 		if dir == "." {
 			dir = ""
 		}
 		pkgs, err := packages.Load(&packages.Config{Mode: packages.NeedName, Dir: dir}, localImportPath)
 		if err != nil {
-			return nil, fmt.Errorf("'builds': entry #%d does not contain a valid local import path (%s) for directory (%s): %w", i, localImportPath, baseDir, err)
+			errs = append(errs, fmt.Errorf("'builds': entry #%d does not contain a valid local import path (%s) for directory (%s): %w", i, localImportPath, baseDir, err))
+			continue
 		}
 
 		if len(pkgs) != 1 {
-			return nil, fmt.Errorf("'builds': entry #%d results in %d local packages, only 1 is expected", i, len(pkgs))
+			errs = append(errs, fmt.Errorf("'builds': entry #%d (%s) results in %d local packages, only 1 is expected", i, localImportPath, len(pkgs)))
+			continue
 		}
 		importPath := pkgs[0].PkgPath
+		if existing, ok := buildConfigsByImportPath[importPath]; ok {
+			errs = append(errs, fmt.Errorf("duplicate build config for import path '%s': IDs '%s' and '%s'", importPath, existing.ID, config.ID))
+			continue
+		}
 		buildConfigsByImportPath[importPath] = config
 	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
 
 	return buildConfigsByImportPath, nil
 }
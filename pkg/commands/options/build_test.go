@@ -15,10 +15,15 @@
 package options
 
 import (
+	"bytes"
+	"errors"
+	"log"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/ko/pkg/build"
 	"github.com/spf13/cobra"
@@ -39,6 +44,166 @@ func TestDefaultBaseImage(t *testing.T) {
 	}
 }
 
+func TestDefaultPushRepo(t *testing.T) {
+	bo := &BuildOptions{WorkingDirectory: "testdata/push-repo"}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "registry.example.com/staging"; bo.DefaultPushRepo != want { // matches value in ./testdata/push-repo/.ko.yaml
+		t.Errorf("wanted DefaultPushRepo %s, got %s", want, bo.DefaultPushRepo)
+	}
+
+	bo = &BuildOptions{WorkingDirectory: "testdata/push-repo", DefaultPushRepo: "registry.example.com/mine"}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "registry.example.com/mine"; bo.DefaultPushRepo != want {
+		t.Errorf("wanted DefaultPushRepo %s, got %s", want, bo.DefaultPushRepo)
+	}
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	fieldEpoch := time.Unix(1500000000, 0)
+	for _, tc := range []struct {
+		name  string
+		env   string
+		field *time.Time
+		want  int64
+	}{{
+		name: "from .ko.yaml",
+		want: 1700000000, // matches value in ./testdata/source-date-epoch/.ko.yaml
+	}, {
+		name: "environment wins",
+		env:  "1600000000",
+		want: 1600000000,
+	}, {
+		name:  "field wins",
+		env:   "1600000000",
+		field: &fieldEpoch,
+		want:  1500000000,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("SOURCE_DATE_EPOCH", tc.env)
+			bo := &BuildOptions{WorkingDirectory: "testdata/source-date-epoch", SourceDateEpoch: tc.field}
+			if err := bo.LoadConfig(); err != nil {
+				t.Fatal(err)
+			}
+			if bo.SourceDateEpoch == nil || bo.SourceDateEpoch.Unix() != tc.want {
+				t.Errorf("SourceDateEpoch = %v, want %d", bo.SourceDateEpoch, tc.want)
+			}
+		})
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		t.Setenv("SOURCE_DATE_EPOCH", "")
+		bo := &BuildOptions{WorkingDirectory: "testdata/config"}
+		if err := bo.LoadConfig(); err != nil {
+			t.Fatal(err)
+		}
+		if bo.SourceDateEpoch != nil {
+			t.Errorf("SourceDateEpoch = %v, want nil", bo.SourceDateEpoch)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+		bo := &BuildOptions{WorkingDirectory: "testdata/config"}
+		if err := bo.LoadConfig(); err == nil || !strings.Contains(err.Error(), "SOURCE_DATE_EPOCH") {
+			t.Errorf("LoadConfig() = %v, want an error about SOURCE_DATE_EPOCH", err)
+		}
+	})
+}
+
+func TestRecordCreationTimestamp(t *testing.T) {
+	fieldEpoch := time.Unix(1500000000, 0)
+	for _, tc := range []struct {
+		name    string
+		dir     string
+		env     string
+		field   *time.Time
+		wantErr bool
+	}{{
+		name: "alone",
+		dir:  "testdata/config",
+	}, {
+		name:    "with SOURCE_DATE_EPOCH",
+		dir:     "testdata/config",
+		env:     "1600000000",
+		wantErr: true,
+	}, {
+		name:    "with sourceDateEpoch in .ko.yaml",
+		dir:     "testdata/source-date-epoch",
+		wantErr: true,
+	}, {
+		name:    "with SourceDateEpoch",
+		dir:     "testdata/config",
+		field:   &fieldEpoch,
+		wantErr: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("SOURCE_DATE_EPOCH", tc.env)
+			cmd := &cobra.Command{}
+			bo := &BuildOptions{}
+			AddBuildOptions(cmd, bo)
+			if err := cmd.Flags().Set("record-creation-timestamp", "true"); err != nil {
+				t.Fatal(err)
+			}
+			bo.WorkingDirectory = tc.dir
+			bo.SourceDateEpoch = tc.field
+			err := bo.LoadConfig()
+			if tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "--record-creation-timestamp") {
+					t.Errorf("LoadConfig() = %v, want a conflict with --record-creation-timestamp", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() = %v", err)
+			}
+			if !bo.RecordCreationTimestamp {
+				t.Error("RecordCreationTimestamp = false, want true")
+			}
+		})
+	}
+}
+
+func TestInvalidBaseImage(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		bo   *BuildOptions
+		err  string
+	}{{
+		name: "from .ko.yaml",
+		bo:   &BuildOptions{WorkingDirectory: "testdata/invalid-base-image"},
+		err:  `'defaultBaseImage': error parsing "alpin e" as image reference`,
+	}, {
+		name: "from BuildOptions",
+		bo:   &BuildOptions{WorkingDirectory: "testdata/config", BaseImage: "alpin e"},
+		err:  `BaseImage "alpin e" is not a valid image reference`,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.bo.LoadConfig()
+			if err == nil {
+				t.Fatalf("expected error %q, saw nil", tc.err)
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error to contain %q, saw: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestInvalidCacheRepository(t *testing.T) {
+	for _, bo := range []*BuildOptions{
+		{WorkingDirectory: "testdata/config", CacheFrom: "registry.example.com/Cache"},
+		{WorkingDirectory: "testdata/config", CacheTo: "registry.example.com/ca che"},
+	} {
+		if err := bo.LoadConfig(); err == nil || !strings.Contains(err.Error(), "as repository") {
+			t.Errorf("LoadConfig() with --cache-from=%q --cache-to=%q = %v, want an invalid repository error", bo.CacheFrom, bo.CacheTo, err)
+		}
+	}
+}
+
 func TestDefaultPlatformsAll(t *testing.T) {
 	allBo := &BuildOptions{
 		WorkingDirectory: "testdata/config",
@@ -67,6 +232,119 @@ func TestDefaultPlatformsAll(t *testing.T) {
 	}
 }
 
+func TestMergeStrategy(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		mergeStrategy string
+		wantBaseImage string
+		wantPlatforms []string
+		wantErr       bool
+	}{{
+		name:          "default",
+		wantBaseImage: "busybox", // matches value in ./testdata/merge/app/.ko.yaml
+	}, {
+		name:          "override",
+		mergeStrategy: MergeStrategyOverride,
+		wantBaseImage: "busybox",
+	}, {
+		name:          "merge",
+		mergeStrategy: MergeStrategyMerge,
+		wantBaseImage: "busybox",
+		wantPlatforms: []string{"linux/arm64"}, // matches value in ./testdata/merge/.ko.yaml
+	}, {
+		name:          "unknown",
+		mergeStrategy: "bogus",
+		wantErr:       true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			bo := &BuildOptions{
+				WorkingDirectory: "testdata/merge/app",
+				MergeStrategy:    tc.mergeStrategy,
+			}
+			err := bo.LoadConfig()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("LoadConfig() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if bo.BaseImage != tc.wantBaseImage {
+				t.Errorf("wanted BaseImage %s, got %s", tc.wantBaseImage, bo.BaseImage)
+			}
+			if !reflect.DeepEqual(bo.DefaultPlatforms, tc.wantPlatforms) {
+				t.Errorf("wanted DefaultPlatforms %s, got %s", tc.wantPlatforms, bo.DefaultPlatforms)
+			}
+		})
+	}
+}
+
+func TestInclude(t *testing.T) {
+	bo := &BuildOptions{WorkingDirectory: "testdata/include"}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	// The including file wins over ./testdata/include/common/base.yaml.
+	if want := "alpine"; bo.BaseImage != want {
+		t.Errorf("wanted BaseImage %s, got %s", want, bo.BaseImage)
+	}
+	// Directly included from ./testdata/include/common/base.yaml.
+	if want := []string{"team=foo"}; !reflect.DeepEqual(bo.Labels, want) {
+		t.Errorf("wanted Labels %s, got %s", want, bo.Labels)
+	}
+	// Included by base.yaml, relative to it.
+	if want := []string{"linux/arm64"}; !reflect.DeepEqual(bo.DefaultPlatforms, want) {
+		t.Errorf("wanted DefaultPlatforms %s, got %s", want, bo.DefaultPlatforms)
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	bo := &BuildOptions{WorkingDirectory: "testdata/include-cycle"}
+	err := bo.LoadConfig()
+	if err == nil {
+		t.Fatal("LoadConfig() = nil, want error")
+	}
+	if !strings.Contains(err.Error(), "include cycle") || !strings.Contains(err.Error(), "a.yaml -> ") {
+		t.Errorf("LoadConfig() = %v, want an include cycle error", err)
+	}
+}
+
+func TestTOMLConfig(t *testing.T) {
+	yamlBo := &BuildOptions{
+		WorkingDirectory: "testdata/toml",
+		ConfigPath:       "testdata/toml/ko.yaml",
+	}
+	if err := yamlBo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		bo   *BuildOptions
+	}{{
+		name: "working directory",
+		bo:   &BuildOptions{WorkingDirectory: "testdata/toml"},
+	}, {
+		name: "config path is a directory",
+		bo:   &BuildOptions{WorkingDirectory: "testdata/toml", ConfigPath: "testdata/toml"},
+	}, {
+		name: "config path points to a file",
+		bo:   &BuildOptions{WorkingDirectory: "testdata/toml", ConfigPath: "testdata/toml/.ko.toml"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.bo.LoadConfig(); err != nil {
+				t.Fatal(err)
+			}
+			// Only the location of the config file should differ.
+			got, want := *tc.bo, *yamlBo
+			got.ConfigPath, want.ConfigPath = "", ""
+			got.ConfigFilePath, want.ConfigFilePath = "", ""
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("wanted %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
 func TestBuildConfigWithWorkingDirectoryAndDirAndMain(t *testing.T) {
 	bo := &BuildOptions{
 		WorkingDirectory: "testdata/paths",
@@ -85,6 +363,38 @@ func TestBuildConfigWithWorkingDirectoryAndDirAndMain(t *testing.T) {
 	}
 }
 
+func TestBuildConfigWorkingDirectory(t *testing.T) {
+	abs, err := filepath.Abs("testdata/paths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name             string
+		workingDirectory string
+	}{{
+		name:             "relative",
+		workingDirectory: "../paths",
+	}, {
+		name:             "absolute",
+		workingDirectory: abs,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The global working directory holds no module, so only the
+			// working directory of the build config leads to the package.
+			buildConfigMap, err := createBuildConfigMap("testdata/config", []build.Config{
+				{ID: "app", WorkingDirectory: tc.workingDirectory, Dir: "./app", Main: "./cmd/foo"},
+			}, false)
+			if err != nil {
+				t.Fatalf("createBuildConfigMap() = %v", err)
+			}
+			const want = "example.com/testapp/cmd/foo"
+			if _, ok := buildConfigMap[want]; !ok {
+				t.Errorf("expected build config for import path [%s], got %+v", want, buildConfigMap)
+			}
+		})
+	}
+}
+
 func TestCreateBuildConfigs(t *testing.T) {
 	compare := func(expected string, actual string) {
 		if expected != actual {
@@ -101,7 +411,7 @@ func TestCreateBuildConfigs(t *testing.T) {
 	}
 
 	for _, b := range buildConfigs {
-		buildConfigMap, err := createBuildConfigMap("../../..", []build.Config{b})
+		buildConfigMap, err := createBuildConfigMap("../../..", []build.Config{b}, false)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -120,55 +430,1231 @@ func TestCreateBuildConfigs(t *testing.T) {
 	}
 }
 
-func TestAddBuildOptionsSetsDefaultsForNonFlagOptions(t *testing.T) {
-	cmd := &cobra.Command{}
-	bo := &BuildOptions{}
-	AddBuildOptions(cmd, bo)
-	if !bo.Trimpath {
-		t.Error("expected Trimpath=true")
+func TestCreateBuildConfigsWithSymlinkedDir(t *testing.T) {
+	target, err := filepath.Abs("testdata/paths/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	workingDirectory := t.TempDir()
+	if err := os.Symlink(target, filepath.Join(workingDirectory, "app")); err != nil {
+		t.Skipf("cannot create symlink: %v", err)
+	}
+
+	buildConfigMap, err := createBuildConfigMap(workingDirectory, []build.Config{
+		{ID: "app", Dir: "app", Main: "./cmd/foo"},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "example.com/testapp/cmd/foo"
+	config, ok := buildConfigMap[want]
+	if !ok {
+		t.Fatalf("expected build config for import path [%s], got %+v", want, buildConfigMap)
+	}
+	if config.Dir != "app" {
+		t.Errorf("Dir = %q, want it unchanged", config.Dir)
 	}
 }
 
-func TestOverrideConfigPath(t *testing.T) {
-	const envName = "KO_CONFIG_PATH"
-	bo := &BuildOptions{}
+func TestCreateBuildConfigsInWorkspace(t *testing.T) {
+	t.Setenv("GOWORK", "")
+
 	for _, tc := range []struct {
-		name         string
-		koConfigPath string
-		err          string
+		name             string
+		workingDirectory string
+		config           build.Config
+		want             string
 	}{{
-		name:         ".ko.yaml does not exist",
-		koConfigPath: "testdata",
-		err:          "testdata/.ko.yaml: no such file or directory",
+		name:             "dir in first module",
+		workingDirectory: "testdata/workspace",
+		config:           build.Config{ID: "a", Dir: "a", Main: "./cmd/app"},
+		want:             "example.com/a/cmd/app",
 	}, {
-		name:         "config path does not contain .ko.yaml",
-		koConfigPath: "testdata/bad-config",
-		err:          "testdata/bad-config/.ko.yaml is not a regular file",
+		name:             "dir in second module",
+		workingDirectory: "testdata/workspace",
+		config:           build.Config{ID: "b", Dir: "b/cmd", Main: "./app"},
+		want:             "example.com/b/cmd/app",
 	}, {
-		name:         "config path is a directory containing a .ko.yaml",
-		koConfigPath: "testdata/config",
+		name:             "main in second module",
+		workingDirectory: "testdata/workspace",
+		config:           build.Config{ID: "b", Main: "./b/cmd/app/main.go"},
+		want:             "example.com/b/cmd/app",
 	}, {
-		name:         "config path points to a file",
-		koConfigPath: "testdata/config/my-ko.yaml",
+		name:             "working directory in module",
+		workingDirectory: "testdata/workspace/b",
+		config:           build.Config{ID: "b", Main: "./cmd/app"},
+		want:             "example.com/b/cmd/app",
 	}} {
 		t.Run(tc.name, func(t *testing.T) {
-			oldEnv := os.Getenv(envName)
-			defer os.Setenv(envName, oldEnv)
+			buildConfigMap, err := createBuildConfigMap(tc.workingDirectory, []build.Config{tc.config}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := buildConfigMap[tc.want]; !ok {
+				t.Errorf("expected build config for import path [%s], got %+v", tc.want, buildConfigMap)
+			}
+		})
+	}
+}
 
-			os.Setenv(envName, tc.koConfigPath)
+func TestCreateBuildConfigsOutsideWorkspaceModules(t *testing.T) {
+	t.Setenv("GOWORK", "")
+
+	_, err := createBuildConfigMap("testdata/workspace", []build.Config{{ID: "c", Dir: "c"}}, false)
+	if err == nil {
+		t.Fatal("expected an error, saw nil")
+	}
+	if want := "is not in any module listed in"; !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error to contain %q, saw: %v", want, err)
+	}
+}
+
+func TestWorkspaceModules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "go.work")
+	work := `go 1.21
+
+use ./a
+use "./b"
+use (
+	// The tools module.
+	./tools
+	/abs/path
+)
+
+replace example.com/x => ./x
+`
+	if err := os.WriteFile(path, []byte(work), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := workspaceModules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Dir(path)
+	want := []string{
+		filepath.Join(root, "a"),
+		filepath.Join(root, "b"),
+		filepath.Join(root, "tools"),
+		filepath.Clean("/abs/path"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("workspaceModules() = %v, want %v", got, want)
+	}
+}
+
+func TestCGOEnabled(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		baseImage string
+		overrides map[string]string
+		err       string
+	}{{
+		name: "default base image",
+		err:  `build config "withcgo" enables cgo, but its base image cgr.dev/chainguard/static:latest has no libc`,
+	}, {
+		name:      "base image with libc",
+		baseImage: "cgr.dev/chainguard/glibc-dynamic",
+	}, {
+		name:      "static override",
+		baseImage: "cgr.dev/chainguard/glibc-dynamic",
+		overrides: map[string]string{"example.com/cgo/cmd/withcgo": "gcr.io/distroless/static-debian12:nonroot"},
+		err:       "base image gcr.io/distroless/static-debian12:nonroot has no libc",
+	}, {
+		name:      "override with libc",
+		overrides: map[string]string{"example.com/cgo/cmd/withcgo": "gcr.io/distroless/base-debian12"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			bo := &BuildOptions{
+				WorkingDirectory:   "testdata/cgo",
+				BaseImage:          tc.baseImage,
+				BaseImageOverrides: tc.overrides,
+			}
 			err := bo.LoadConfig()
-			if err == nil {
-				if tc.err != "" {
-					t.Fatalf("expected error %q, saw nil", tc.err)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("LoadConfig() = %v, want error containing %q", err, tc.err)
 				}
-			} else {
-				if tc.err == "" {
-					t.Errorf("expected no error, saw: %v", err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() = %v", err)
+			}
+
+			// matches values in ./testdata/cgo/.ko.yaml
+			for importPath, want := range map[string]bool{
+				"example.com/cgo/cmd/withcgo": true,
+				"example.com/cgo/cmd/nocgo":   false,
+			} {
+				got := bo.BuildConfigs[importPath].CGOEnabled
+				if got == nil || *got != want {
+					t.Errorf("CGOEnabled of %s = %v, want %v", importPath, got, want)
 				}
-				if !strings.Contains(err.Error(), tc.err) {
-					t.Errorf("expected error to contain %q, saw: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestBuildConfigBaseImage(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		overrides map[string]string
+		want      map[string]string
+	}{{
+		name: "build config base image",
+		// matches values in ./testdata/base-image/.ko.yaml
+		want: map[string]string{
+			"example.com/base/cmd/static":        "cgr.dev/chainguard/static",
+			"ko://example.com/base/cmd/dynamic":  "cgr.dev/chainguard/glibc-dynamic",
+			"example.com/base/cmd/withoutconfig": "cgr.dev/chainguard/static",
+		},
+	}, {
+		name:      "override wins",
+		overrides: map[string]string{"example.com/base/cmd/dynamic": "gcr.io/distroless/base-debian12"},
+		want: map[string]string{
+			"example.com/base/cmd/static":  "cgr.dev/chainguard/static",
+			"example.com/base/cmd/dynamic": "gcr.io/distroless/base-debian12",
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			bo := &BuildOptions{
+				WorkingDirectory:   "testdata/base-image",
+				BaseImageOverrides: tc.overrides,
+			}
+			if err := bo.LoadConfig(); err != nil {
+				t.Fatalf("LoadConfig() = %v", err)
+			}
+			for importPath, want := range tc.want {
+				if got := bo.BaseImageFor(importPath); got != want {
+					t.Errorf("BaseImageFor(%q) = %q, want %q", importPath, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidBuildConfigBaseImage(t *testing.T) {
+	bo := &BuildOptions{
+		BuildConfigs: map[string]build.Config{
+			"example.com/base/cmd/static": {ID: "static", BaseImage: "not a valid:reference"},
+		},
+	}
+	err := bo.LoadConfig()
+	if err == nil || !strings.Contains(err.Error(), `build config "static": 'baseImage'`) {
+		t.Errorf("LoadConfig() = %v, want an invalid baseImage error", err)
+	}
+}
+
+func TestBuildConfigPlatforms(t *testing.T) {
+	for _, tc := range []struct {
+		platforms []string
+		wantErr   string
+	}{
+		{platforms: []string{"linux/amd64", "linux/arm64/v8"}},
+		{platforms: []string{"all"}},
+		{platforms: []string{"windows"}},
+		{platforms: []string{"windows/amd64:10.0.17763.1879"}},
+		{platforms: []string{"linux/arm64/v8/extra"}, wantErr: "too many slashes"},
+		{platforms: []string{"linux/"}, wantErr: `invalid platform "linux/": expected os[/arch[/variant]]`},
+		{platforms: []string{"/amd64"}, wantErr: `invalid platform "/amd64"`},
+		{platforms: []string{""}, wantErr: `invalid platform ""`},
+		{platforms: []string{"windows/amd64:10:0"}, wantErr: "more than one OS version"},
+		{platforms: []string{"linux/amd64", "all"}, wantErr: `"all" cannot be combined`},
+	} {
+		t.Run(strings.Join(tc.platforms, ","), func(t *testing.T) {
+			bo := &BuildOptions{
+				BuildConfigs: map[string]build.Config{
+					"example.com/app": {ID: "app", Platforms: tc.platforms},
+				},
+			}
+			err := bo.LoadConfig()
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("LoadConfig() = %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("LoadConfig() = %v, want error containing %q", err, tc.wantErr)
+			case tc.wantErr != "" && !strings.Contains(err.Error(), `build config "app": 'platforms'`):
+				t.Errorf("LoadConfig() = %v, want it to name the build config", err)
+			}
+		})
+	}
+}
+
+func TestInvalidBuildConfigBinaryName(t *testing.T) {
+	for _, binary := range []string{"bin/server", `bin\server`, "..", "."} {
+		bo := &BuildOptions{
+			BuildConfigs: map[string]build.Config{
+				"example.com/app": {ID: "app", BinaryName: binary},
+			},
+		}
+		err := bo.LoadConfig()
+		if err == nil || !strings.Contains(err.Error(), `build config "app": 'binaryName'`) {
+			t.Errorf("LoadConfig() with binary %q = %v, want an invalid binary error", binary, err)
+		}
+	}
+}
+
+func TestInvalidBuildConfigExtraFiles(t *testing.T) {
+	for _, f := range []build.FileEntry{
+		{Src: "", Dst: "/etc/ssl/certs/ca.pem"},
+		{Src: "ca.pem", Dst: ""},
+		{Src: "ca.pem", Dst: "etc/ssl/certs/ca.pem"},
+		{Src: "ca.pem", Dst: "/"},
+	} {
+		bo := &BuildOptions{
+			BuildConfigs: map[string]build.Config{
+				"example.com/app": {ID: "app", ExtraFiles: []build.FileEntry{f}},
+			},
+		}
+		err := bo.LoadConfig()
+		if err == nil || !strings.Contains(err.Error(), `build config "app": 'extraFiles'`) {
+			t.Errorf("LoadConfig() with %+v = %v, want an invalid extra file error", f, err)
+		}
+	}
+}
+
+func TestOverlayConfigs(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		overlays []string
+		want     map[string]build.Config
+		wantErr  string
+	}{{
+		name: "no overlays",
+		want: map[string]build.Config{
+			"example.com/overlay/cmd/app": {ID: "app", Dir: ".", Main: "./cmd/app"},
+		},
+	}, {
+		name:     "overlay",
+		overlays: []string{`{"id":"svc","main":"./cmd/svc","ldflags":"-s -w","binaryName":"svc"}`},
+		want: map[string]build.Config{
+			"example.com/overlay/cmd/app": {ID: "app", Dir: ".", Main: "./cmd/app"},
+			"example.com/overlay/cmd/svc": {ID: "svc", Dir: ".", Main: "./cmd/svc", Ldflags: build.StringArray{"-s -w"}, BinaryName: "svc"},
+		},
+	}, {
+		name:     "duplicate of file config",
+		overlays: []string{`{"id":"app","main":"./cmd/svc"}`},
+		wantErr:  "overlay config #0: a build config with ID 'app' already exists",
+	}, {
+		name:     "duplicate overlays",
+		overlays: []string{`{"id":"svc","main":"./cmd/svc"}`, `{"id":"svc","main":"./cmd/svc"}`},
+		wantErr:  "overlay config #1: a build config with ID 'svc' already exists",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			bo := &BuildOptions{}
+			AddBuildOptions(cmd, bo)
+			for _, overlay := range tc.overlays {
+				if err := cmd.Flags().Set("overlay-config", overlay); err != nil {
+					t.Fatalf("--overlay-config=%s: %v", overlay, err)
 				}
 			}
+			bo.WorkingDirectory = "testdata/overlay"
+			err := bo.LoadConfig()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("LoadConfig() = %v, want error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() = %v", err)
+			}
+			if !reflect.DeepEqual(bo.BuildConfigs, tc.want) {
+				t.Errorf("BuildConfigs = %+v, want %+v", bo.BuildConfigs, tc.want)
+			}
 		})
 	}
+
+	cmd := &cobra.Command{}
+	AddBuildOptions(cmd, &BuildOptions{})
+	if err := cmd.Flags().Set("overlay-config", `{"id":"svc","unknown":true}`); err == nil {
+		t.Error("--overlay-config with an unknown key = nil, want error")
+	}
+}
+
+func TestMergeKeys(t *testing.T) {
+	bo := &BuildOptions{WorkingDirectory: "testdata/merge-keys"}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() = %v", err)
+	}
+	// Both builds share the ldflags of the anchor, while svc overrides env.
+	want := map[string]build.Config{
+		"example.com/overlay/cmd/app": {
+			ID: "app", Dir: "../overlay", Main: "./cmd/app",
+			Ldflags: build.StringArray{"-s", "-w"},
+			Env:     build.StringArray{"CGO_ENABLED=0"},
+		},
+		"example.com/overlay/cmd/svc": {
+			ID: "svc", Dir: "../overlay", Main: "./cmd/svc",
+			Ldflags: build.StringArray{"-s", "-w"},
+			Env:     build.StringArray{"CGO_ENABLED=1"},
+		},
+	}
+	if !reflect.DeepEqual(bo.BuildConfigs, want) {
+		t.Errorf("BuildConfigs = %+v, want %+v", bo.BuildConfigs, want)
+	}
+}
+
+func TestSkipMissing(t *testing.T) {
+	bo := &BuildOptions{WorkingDirectory: "testdata/skip-missing"}
+	err := bo.LoadConfig()
+	for _, want := range []string{"'builds': entry #1", "'builds': entry #2"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadConfig() = %v, want error containing %q", err, want)
+		}
+	}
+
+	bo = &BuildOptions{WorkingDirectory: "testdata/skip-missing", SkipMissing: true}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatalf("LoadConfig() with SkipMissing = %v", err)
+	}
+	want := map[string]build.Config{
+		"example.com/overlay/cmd/app": {ID: "app", Dir: "../overlay", Main: "./cmd/app"},
+	}
+	if !reflect.DeepEqual(bo.BuildConfigs, want) {
+		t.Errorf("BuildConfigs = %+v, want %+v", bo.BuildConfigs, want)
+	}
+}
+
+func TestBuildConfigDirGlob(t *testing.T) {
+	bo := &BuildOptions{WorkingDirectory: "testdata/glob"}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	// matches values in ./testdata/glob/.ko.yaml
+	want := map[string]build.Config{
+		"example.com/glob/services/bar/cmd/server": {
+			ID:      "servers[services/bar]",
+			Dir:     "services/bar",
+			Main:    "./cmd/server",
+			Ldflags: build.StringArray{"-s -w"},
+		},
+		"example.com/glob/services/foo/cmd/server": {
+			ID:      "servers[services/foo]",
+			Dir:     "services/foo",
+			Main:    "./cmd/server",
+			Ldflags: build.StringArray{"-s -w"},
+		},
+	}
+	if !reflect.DeepEqual(bo.BuildConfigs, want) {
+		t.Errorf("BuildConfigs = %+v, want %+v", bo.BuildConfigs, want)
+	}
+}
+
+func TestBuildConfigDirGlobErrors(t *testing.T) {
+	for _, tc := range []struct {
+		dir  string
+		want string
+	}{{
+		dir:  "nothing/*",
+		want: `'builds': entry #0: dir "nothing/*" matches no directories`,
+	}, {
+		// Only matches a file.
+		dir:  "services/*.md",
+		want: `'builds': entry #0: dir "services/*.md" matches no directories`,
+	}, {
+		dir:  "services/[",
+		want: "syntax error in pattern",
+	}} {
+		t.Run(tc.dir, func(t *testing.T) {
+			_, err := createBuildConfigMap("testdata/glob", []build.Config{{Dir: tc.dir}}, false)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("createBuildConfigMap() = %v, want error containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestDuplicateBuildConfigIDs(t *testing.T) {
+	bo := &BuildOptions{WorkingDirectory: "testdata/duplicate-ids"}
+	err := bo.LoadConfig()
+	if err == nil {
+		t.Fatal("expected an error, saw nil")
+	}
+	if want := "'builds': entries #0 and #2 have the same ID 'app'"; !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error to contain %q, saw: %v", want, err)
+	}
+	// Duplicate IDs are reported before import paths are looked up.
+	if strings.Contains(err.Error(), "duplicate build config for import path") {
+		t.Errorf("expected only the duplicate IDs to be reported, saw: %v", err)
+	}
+}
+
+func TestCreateBuildConfigsReportsAllErrors(t *testing.T) {
+	buildConfigs := []build.Config{
+		{ID: "first", Main: "missing-first"},
+		{ID: "valid", Main: "test"},
+		{ID: "second", Main: "missing-second"},
+	}
+
+	_, err := createBuildConfigMap("../../..", buildConfigs, false)
+	if err == nil {
+		t.Fatal("expected an error, saw nil")
+	}
+	for _, want := range []string{"missing-first", "missing-second"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, saw: %v", want, err)
+		}
+	}
+}
+
+func TestCreateBuildConfigsRejectsDuplicates(t *testing.T) {
+	buildConfigs := []build.Config{
+		{ID: "svc-a", Main: "test"},
+		{ID: "svc-b", Dir: "test"},
+		{ID: "svc-c", Dir: "test", Main: "main.go"},
+	}
+
+	_, err := createBuildConfigMap("../../..", buildConfigs, false)
+	if err == nil {
+		t.Fatal("expected an error, saw nil")
+	}
+	for _, want := range []string{
+		"duplicate build config for import path 'github.com/google/ko/test': IDs 'svc-a' and 'svc-b'",
+		"duplicate build config for import path 'github.com/google/ko/test': IDs 'svc-a' and 'svc-c'",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, saw: %v", want, err)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	t.Setenv("SOURCE", "https://example.com/repo")
+	t.Setenv("VERSION", "v1.2.3")
+	bo := &BuildOptions{
+		WorkingDirectory: "testdata/labels",
+		Labels:           []string{"version=$VERSION", "price=$5", "flag=true"},
+		StrictEnv:        true,
+	}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"org.opencontainers.image.source=https://example.com/repo",
+		"escaped=$LITERAL",
+		// Labels passed as flags are not expanded.
+		"version=$VERSION",
+		"price=$5",
+		"flag=true",
+	}
+	if !reflect.DeepEqual(bo.Labels, want) {
+		t.Errorf("Labels = %q, want %q", bo.Labels, want)
+	}
+}
+
+func TestLabelFlags(t *testing.T) {
+	t.Setenv("SOURCE", "https://example.com/repo")
+	cmd := &cobra.Command{}
+	bo := &BuildOptions{}
+	AddBuildOptions(cmd, bo)
+	if err := cmd.ParseFlags([]string{
+		"--label", "escaped=flag",
+		"--image-label", "a=1,b=2",
+		"--label", "commas=x,y",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	bo.WorkingDirectory = "testdata/labels"
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"org.opencontainers.image.source=https://example.com/repo",
+		// escaped=$$LITERAL from .ko.yaml is overridden by the flag.
+		"escaped=flag",
+		"a=1",
+		"b=2",
+		"commas=x,y",
+	}
+	if !reflect.DeepEqual(bo.Labels, want) {
+		t.Errorf("Labels = %q, want %q", bo.Labels, want)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	cmd := &cobra.Command{}
+	bo := &BuildOptions{}
+	AddBuildOptions(cmd, bo)
+	if err := cmd.ParseFlags([]string{
+		"--annotation", "team=bar",
+		"--annotation", "commas=x,y",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	bo.WorkingDirectory = "testdata/annotations"
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"org.opencontainers.image.source=https://example.com/repo",
+		// team=foo from .ko.yaml is overridden by the flag.
+		"team=bar",
+		"commas=x,y",
+	}
+	if !reflect.DeepEqual(bo.Annotations, want) {
+		t.Errorf("Annotations = %q, want %q", bo.Annotations, want)
+	}
+}
+
+func TestSBOMFlag(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		dir  string
+		args []string
+		want string
+		err  string
+	}{{
+		name: "default",
+		dir:  "testdata/config",
+		want: "spdx",
+	}, {
+		name: "from .ko.yaml",
+		dir:  "testdata/sbom",
+		want: "none", // matches value in ./testdata/sbom/.ko.yaml
+	}, {
+		name: "empty flag inherits .ko.yaml",
+		dir:  "testdata/sbom",
+		args: []string{"--sbom="},
+		want: "none",
+	}, {
+		name: "none",
+		dir:  "testdata/config",
+		args: []string{"--sbom=none"},
+		want: "none",
+	}, {
+		name: "spdx over .ko.yaml",
+		dir:  "testdata/sbom",
+		args: []string{"--sbom=spdx"},
+		want: "spdx",
+	}, {
+		name: "cyclonedx over .ko.yaml",
+		dir:  "testdata/sbom",
+		args: []string{"--sbom=cyclonedx"},
+		want: "cyclonedx",
+	}, {
+		name: "unsupported",
+		dir:  "testdata/config",
+		args: []string{"--sbom=swid"},
+		err:  `unsupported SBOM type "swid"`,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			bo := &BuildOptions{}
+			AddBuildOptions(cmd, bo)
+			if err := cmd.ParseFlags(tc.args); err != nil {
+				t.Fatal(err)
+			}
+
+			bo.WorkingDirectory = tc.dir
+			err := bo.LoadConfig()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("LoadConfig() = %v, want error containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() = %v", err)
+			}
+			if bo.SBOM != tc.want {
+				t.Errorf("SBOM = %q, want %q", bo.SBOM, tc.want)
+			}
+		})
+	}
+}
+
+func TestConditionalLabels(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{{
+		name: "local",
+		want: []string{"team=foo", "local=true"},
+	}, {
+		name: "ci",
+		env:  map[string]string{"KO_TEST_CI": "true", "KO_TEST_BRANCH": "main"},
+		want: []string{"team=foo", "branch=main"},
+	}, {
+		name: "variable set",
+		env:  map[string]string{"KO_TEST_CI": "false", "KO_TEST_COMMIT": "c0ffee"},
+		want: []string{"team=foo", "local=true", "commit=c0ffee"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Unset variables would fail LoadConfig if the labels that refer
+			// to them weren't left out.
+			for _, name := range []string{"KO_TEST_CI", "KO_TEST_BRANCH", "KO_TEST_COMMIT"} {
+				t.Setenv(name, "")
+				os.Unsetenv(name)
+			}
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			bo := &BuildOptions{WorkingDirectory: "testdata/labels-when", StrictEnv: true}
+			if err := bo.LoadConfig(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(bo.Labels, tc.want) {
+				t.Errorf("Labels = %q, want %q", bo.Labels, tc.want)
+			}
+		})
+	}
+}
+
+func TestGoFlags(t *testing.T) {
+	for _, tc := range []struct {
+		flags []string
+		err   string
+	}{{
+		flags: []string{"-race", "-cover", "-overlay=overlay.json"},
+	}, {
+		flags: []string{"-race", "-o=/tmp/out"},
+		err:   `go flag "-o=/tmp/out" cannot be set`,
+	}, {
+		flags: []string{"-v"},
+		err:   `go flag "-v" cannot be set`,
+	}, {
+		flags: []string{"--o", "/tmp/out"},
+		err:   `go flag "--o" cannot be set`,
+	}} {
+		t.Run(strings.Join(tc.flags, " "), func(t *testing.T) {
+			cmd := &cobra.Command{}
+			bo := &BuildOptions{}
+			AddBuildOptions(cmd, bo)
+			for _, flag := range tc.flags {
+				if err := cmd.Flags().Set("go-flag", flag); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(bo.GoFlags, tc.flags) {
+				t.Errorf("GoFlags = %q, want %q", bo.GoFlags, tc.flags)
+			}
+
+			err := bo.LoadConfig()
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("LoadConfig() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("LoadConfig() = %v, want error containing %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestPlatforms(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		platforms []string
+		want      []string
+	}{{
+		name: "from .ko.yaml",
+		want: []string{"linux/amd64", "linux/arm64"},
+	}, {
+		name:      "flag takes precedence",
+		platforms: []string{"linux/s390x"},
+		want:      []string{"linux/s390x"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			bo := &BuildOptions{
+				WorkingDirectory: "testdata/platforms",
+				Platforms:        tc.platforms,
+			}
+			if err := bo.LoadConfig(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(bo.Platforms, tc.want) {
+				t.Errorf("Platforms = %q, want %q", bo.Platforms, tc.want)
+			}
+		})
+	}
+}
+
+func TestLabelsWithUnsetEnv(t *testing.T) {
+	const unset = "KO_TEST_UNSET_GIT_COMMIT"
+	if err := os.Unsetenv(unset); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name      string
+		strictEnv bool
+		want      []string
+		err       string
+	}{{
+		name:      "strict",
+		strictEnv: true,
+		err:       `label "commit" refers to unset environment variables: ` + unset,
+	}, {
+		name:      "lenient",
+		strictEnv: false,
+		want:      []string{"commit=", "escaped=$" + unset},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			bo := &BuildOptions{
+				WorkingDirectory: "testdata/labels-unset",
+				StrictEnv:        tc.strictEnv,
+			}
+			err := bo.LoadConfig()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("LoadConfig() = %v, want error containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() = %v", err)
+			}
+			if !reflect.DeepEqual(bo.Labels, tc.want) {
+				t.Errorf("Labels = %q, want %q", bo.Labels, tc.want)
+			}
+		})
+	}
+}
+
+func TestIncompatibleGOFLAGS(t *testing.T) {
+	for _, tc := range []struct {
+		goflags string
+		want    []string
+	}{{
+		goflags: "-mod=vendor -trimpath",
+	}, {
+		goflags: "-mod=vendor -work",
+		want:    []string{"-work"},
+	}, {
+		goflags: "-o=/tmp/out --v -buildmode=pie",
+		want:    []string{"-o=/tmp/out", "--v"},
+	}, {
+		goflags: "-n -buildmode=c-shared",
+		want:    []string{"-n", "-buildmode=c-shared"},
+	}} {
+		t.Run(tc.goflags, func(t *testing.T) {
+			t.Setenv("GOFLAGS", tc.goflags)
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+			bo := &BuildOptions{WorkingDirectory: "testdata/platforms"}
+			if err := bo.LoadConfig(); err != nil {
+				t.Fatalf("LoadConfig() = %v", err)
+			}
+
+			var got []string
+			for _, line := range strings.Split(buf.String(), "\n") {
+				if _, warning, ok := strings.Cut(line, "WARNING: GOFLAGS contains "); ok {
+					flag, _, _ := strings.Cut(warning, ",")
+					got = append(got, flag)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("warnings for GOFLAGS=%q = %q, want %q", tc.goflags, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetBuildConfig(t *testing.T) {
+	bo := &BuildOptions{
+		BuildConfigs: map[string]build.Config{
+			"example.com/repo/cmd/app": {ID: "exact"},
+			"app":                      {ID: "short"},
+			"cmd/app":                  {ID: "long"},
+			"other":                    {ID: "other"},
+		},
+	}
+	for _, tc := range []struct {
+		importPath string
+		wantID     string
+		wantFound  bool
+	}{{
+		importPath: "example.com/repo/cmd/app",
+		wantID:     "exact",
+		wantFound:  true,
+	}, {
+		importPath: "ko://example.com/repo/cmd/app",
+		wantID:     "exact",
+		wantFound:  true,
+	}, {
+		importPath: "example.com/other/cmd/app",
+		wantID:     "long",
+		wantFound:  true,
+	}, {
+		importPath: "example.com/other/app",
+		wantID:     "short",
+		wantFound:  true,
+	}, {
+		importPath: "example.com/myapp",
+	}} {
+		t.Run(tc.importPath, func(t *testing.T) {
+			config, found := bo.GetBuildConfig(tc.importPath)
+			if found != tc.wantFound {
+				t.Fatalf("GetBuildConfig(%q) found = %t, want %t", tc.importPath, found, tc.wantFound)
+			}
+			if config.ID != tc.wantID {
+				t.Errorf("GetBuildConfig(%q) = %q, want %q", tc.importPath, config.ID, tc.wantID)
+			}
+		})
+	}
+}
+
+func TestAddBuildOptionsSetsDefaultsForNonFlagOptions(t *testing.T) {
+	cmd := &cobra.Command{}
+	bo := &BuildOptions{}
+	AddBuildOptions(cmd, bo)
+	if !bo.Trimpath {
+		t.Error("expected Trimpath=true")
+	}
+	if !bo.StrictEnv {
+		t.Error("expected StrictEnv=true")
+	}
+}
+
+func TestNoTrimpathFlag(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want bool
+	}{{
+		args: nil,
+		want: true,
+	}, {
+		args: []string{"--no-trimpath"},
+		want: false,
+	}, {
+		args: []string{"--no-trimpath=false"},
+		want: true,
+	}} {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			bo := &BuildOptions{}
+			cmd := &cobra.Command{
+				RunE: func(*cobra.Command, []string) error { return nil },
+			}
+			AddBuildOptions(cmd, bo)
+			cmd.SetArgs(tc.args)
+			if err := cmd.Execute(); err != nil {
+				t.Fatalf("Execute() = %v", err)
+			}
+			if bo.Trimpath != tc.want {
+				t.Errorf("Trimpath = %t, want %t", bo.Trimpath, tc.want)
+			}
+		})
+	}
+}
+
+func TestOverrideConfigPath(t *testing.T) {
+	const envName = "KO_CONFIG_PATH"
+	bo := &BuildOptions{}
+	for _, tc := range []struct {
+		name         string
+		koConfigPath string
+		err          string
+	}{{
+		name:         ".ko.yaml does not exist",
+		koConfigPath: "testdata",
+		err:          "testdata/.ko.yaml: no such file or directory",
+	}, {
+		name:         "config path does not contain .ko.yaml",
+		koConfigPath: "testdata/bad-config",
+		err:          "testdata/bad-config/.ko.yaml is not a regular file",
+	}, {
+		name:         "config path is a directory containing a .ko.yaml",
+		koConfigPath: "testdata/config",
+	}, {
+		name:         "config path points to a file",
+		koConfigPath: "testdata/config/my-ko.yaml",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			oldEnv := os.Getenv(envName)
+			defer os.Setenv(envName, oldEnv)
+
+			os.Setenv(envName, tc.koConfigPath)
+			err := bo.LoadConfig()
+			if err == nil {
+				if tc.err != "" {
+					t.Fatalf("expected error %q, saw nil", tc.err)
+				}
+			} else {
+				if tc.err == "" {
+					t.Errorf("expected no error, saw: %v", err)
+				}
+				if !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error to contain %q, saw: %v", tc.err, err)
+				}
+			}
+		})
+	}
+}
+
+func TestBaseImageFlag(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+	}{{
+		name: "from .ko.yaml",
+		want: "alpine", // matches value in ./testdata/config/.ko.yaml
+	}, {
+		name: "flag overrides .ko.yaml",
+		args: []string{"--base-image", "registry.example.com/base/image:patched"},
+		want: "registry.example.com/base/image:patched",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			bo := &BuildOptions{}
+			AddBuildOptions(cmd, bo)
+			if err := cmd.Flags().Parse(tc.args); err != nil {
+				t.Fatalf("Parse(%v) = %v", tc.args, err)
+			}
+			bo.WorkingDirectory = "testdata/config"
+			if err := bo.LoadConfig(); err != nil {
+				t.Fatalf("LoadConfig() = %v", err)
+			}
+			if bo.BaseImage != tc.want {
+				t.Errorf("BaseImage = %q, want %q", bo.BaseImage, tc.want)
+			}
+		})
+	}
+
+	cmd := &cobra.Command{}
+	bo := &BuildOptions{}
+	AddBuildOptions(cmd, bo)
+	if err := cmd.Flags().Parse([]string{"--base-image", "alpin e"}); err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	bo.WorkingDirectory = "testdata/config"
+	if err := bo.LoadConfig(); err == nil {
+		t.Error("LoadConfig() with an invalid --base-image = nil, want error")
+	}
+}
+
+func TestConfigFilePath(t *testing.T) {
+	noConfig := t.TempDir()
+	for _, tc := range []struct {
+		name         string
+		koConfigPath string
+		bo           *BuildOptions
+		want         string
+	}{{
+		name:         "KO_CONFIG_PATH directory",
+		koConfigPath: "testdata/config",
+		bo:           &BuildOptions{},
+		want:         "testdata/config/.ko.yaml",
+	}, {
+		name: "working directory",
+		bo:   &BuildOptions{WorkingDirectory: "testdata/paths"},
+		want: "testdata/paths/.ko.yaml",
+	}, {
+		name: "merged",
+		bo:   &BuildOptions{WorkingDirectory: "testdata/merge/app", MergeStrategy: MergeStrategyMerge},
+		want: "testdata/merge/app/.ko.yaml",
+	}, {
+		name: "no config file",
+		bo:   &BuildOptions{WorkingDirectory: noConfig},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("KO_CONFIG_PATH", tc.koConfigPath)
+			if err := tc.bo.LoadConfig(); err != nil {
+				t.Fatalf("LoadConfig() = %v", err)
+			}
+			got := tc.bo.ConfigFilePath
+			if tc.want == "" {
+				if got != "" {
+					t.Errorf("ConfigFilePath = %q, want empty", got)
+				}
+				return
+			}
+			if !filepath.IsAbs(got) || !strings.HasSuffix(got, filepath.FromSlash(tc.want)) {
+				t.Errorf("ConfigFilePath = %q, want an absolute path ending with %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestKoConfigPathFlag(t *testing.T) {
+	t.Setenv("KO_CONFIG_PATH", "testdata/sbom")
+
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		wantRepo string
+		wantSBOM string
+	}{{
+		name:     "env var",
+		wantSBOM: "none",
+	}, {
+		name:     "flag overrides env var",
+		args:     []string{"--ko-config-path", "testdata/push-repo"},
+		wantRepo: "registry.example.com/staging",
+		wantSBOM: "spdx",
+	}, {
+		name:     "--config overrides flag",
+		args:     []string{"--ko-config-path", "testdata/push-repo", "--config", "testdata/config"},
+		wantSBOM: "spdx",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			bo := &BuildOptions{}
+			root := &cobra.Command{Use: "ko"}
+			AddConfigPathFlag(root)
+			sub := &cobra.Command{
+				Use: "build",
+				RunE: func(*cobra.Command, []string) error {
+					return bo.LoadConfig()
+				},
+			}
+			AddBuildOptions(sub, bo)
+			root.AddCommand(sub)
+			root.SetArgs(append([]string{"build"}, tc.args...))
+			if err := root.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			if bo.DefaultPushRepo != tc.wantRepo {
+				t.Errorf("DefaultPushRepo = %q, want %q", bo.DefaultPushRepo, tc.wantRepo)
+			}
+			if bo.SBOM != tc.wantSBOM {
+				t.Errorf("SBOM = %q, want %q", bo.SBOM, tc.wantSBOM)
+			}
+		})
+	}
+}
+
+func TestConfigFlag(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		configPath string
+		err        string
+	}{{
+		name:       ".ko.yaml does not exist",
+		configPath: "testdata",
+		err:        "testdata/.ko.yaml: no such file or directory",
+	}, {
+		name:       "config path does not contain .ko.yaml",
+		configPath: "testdata/bad-config",
+		err:        "testdata/bad-config/.ko.yaml is not a regular file",
+	}, {
+		name:       "config path is a directory containing a .ko.yaml",
+		configPath: "testdata/config",
+	}, {
+		name:       "config path points to a file",
+		configPath: "testdata/config/my-ko.yaml",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			bo := &BuildOptions{}
+			AddBuildOptions(cmd, bo)
+			if err := cmd.Flags().Set("config", tc.configPath); err != nil {
+				t.Fatal(err)
+			}
+
+			err := bo.LoadConfig()
+			if err == nil {
+				if tc.err != "" {
+					t.Fatalf("expected error %q, saw nil", tc.err)
+				}
+			} else {
+				if tc.err == "" {
+					t.Errorf("expected no error, saw: %v", err)
+				}
+				if !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error to contain %q, saw: %v", tc.err, err)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigErrorKinds(t *testing.T) {
+	writeConfig := func(t *testing.T, config string) string {
+		t.Helper()
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, ".ko.yaml"), []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	for _, tc := range []struct {
+		name string
+		bo   func(t *testing.T) *BuildOptions
+		want ConfigErrorKind
+	}{{
+		name: "config path does not exist",
+		bo: func(*testing.T) *BuildOptions {
+			return &BuildOptions{ConfigPath: "testdata/does-not-exist.yaml"}
+		},
+		want: ConfigNotFound,
+	}, {
+		name: "config path has no .ko.yaml",
+		bo: func(*testing.T) *BuildOptions {
+			return &BuildOptions{ConfigPath: "testdata"}
+		},
+		want: ConfigNotFound,
+	}, {
+		name: "included file does not exist",
+		bo: func(t *testing.T) *BuildOptions {
+			return &BuildOptions{WorkingDirectory: writeConfig(t, "include:\n- missing.yaml\n")}
+		},
+		want: ConfigNotFound,
+	}, {
+		name: "not a regular file",
+		bo: func(*testing.T) *BuildOptions {
+			return &BuildOptions{ConfigPath: "testdata/bad-config"}
+		},
+		want: ConfigUnreadable,
+	}, {
+		name: "syntax error",
+		bo: func(t *testing.T) *BuildOptions {
+			return &BuildOptions{WorkingDirectory: writeConfig(t, "defaultBaseImage: [alpine\n")}
+		},
+		want: ConfigUnreadable,
+	}, {
+		name: "schema violation",
+		bo: func(t *testing.T) *BuildOptions {
+			return &BuildOptions{WorkingDirectory: writeConfig(t, "defaultPlatform: linux/arm64\n")}
+		},
+		want: ConfigInvalid,
+	}, {
+		name: "include cycle",
+		bo: func(*testing.T) *BuildOptions {
+			return &BuildOptions{WorkingDirectory: "testdata/include-cycle"}
+		},
+		want: ConfigInvalid,
+	}, {
+		name: "invalid base image",
+		bo: func(*testing.T) *BuildOptions {
+			return &BuildOptions{WorkingDirectory: "testdata/invalid-base-image"}
+		},
+		want: ConfigInvalid,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.bo(t).LoadConfig()
+			var ce *ConfigError
+			if !errors.As(err, &ce) {
+				t.Fatalf("LoadConfig() = %v, want a *ConfigError", err)
+			}
+			if ce.Kind != tc.want {
+				t.Errorf("LoadConfig() = %v, want kind %v, got %v", err, tc.want, ce.Kind)
+			}
+		})
+	}
+}
+
+func TestConfigFlagTakesPrecedence(t *testing.T) {
+	const envName = "KO_CONFIG_PATH"
+	oldEnv := os.Getenv(envName)
+	defer os.Setenv(envName, oldEnv)
+	os.Setenv(envName, "testdata/bad-config")
+
+	bo := &BuildOptions{ConfigPath: "testdata/config/my-ko.yaml"}
+	if err := bo.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	wantBaseImage := "wow" // matches value in ./testdata/config/my-ko.yaml
+	if bo.BaseImage != wantBaseImage {
+		t.Fatalf("wanted BaseImage %s, got %s", wantBaseImage, bo.BaseImage)
+	}
 }
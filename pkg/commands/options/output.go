@@ -0,0 +1,50 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// Supported values of OutputOptions.OutputFormat.
+const (
+	OutputFormatYAML  = "yaml"
+	OutputFormatJSON  = "json"
+	OutputFormatJSONL = "jsonl"
+)
+
+// OutputOptions controls how resolved files are written.
+type OutputOptions struct {
+	// OutputFormat is one of yaml, json (indented JSON objects, one per
+	// document) or jsonl (one JSON object per line). Empty means yaml.
+	OutputFormat string
+}
+
+func AddOutputArg(cmd *cobra.Command, oo *OutputOptions) {
+	cmd.Flags().StringVar(&oo.OutputFormat, "output-format", OutputFormatYAML,
+		"Format of the resolved output, one of yaml, json or jsonl.")
+}
+
+// Validate returns an error if OutputFormat is not supported.
+func (oo *OutputOptions) Validate() error {
+	switch oo.OutputFormat {
+	case "", OutputFormatYAML, OutputFormatJSON, OutputFormatJSONL:
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %q, must be one of yaml, json or jsonl", oo.OutputFormat)
+	}
+}
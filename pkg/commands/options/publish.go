@@ -72,7 +72,9 @@ type PublishOptions struct {
 	Jobs int
 }
 
-func AddPublishArg(cmd *cobra.Command, po *PublishOptions) {
+// AddPublishOptions registers the flags of po on cmd, and sets the defaults
+// of po, including DockerRepo from KO_DOCKER_REPO.
+func AddPublishOptions(cmd *cobra.Command, po *PublishOptions) {
 	// Set DockerRepo from the KO_DOCKER_REPO envionment variable.
 	// See https://github.com/google/ko/pull/351 for flag discussion.
 	if dockerRepo, exists := os.LookupEnv("KO_DOCKER_REPO"); exists {
@@ -106,6 +108,13 @@ func AddPublishArg(cmd *cobra.Command, po *PublishOptions) {
 		"Whether to just use KO_DOCKER_REPO without additional context (may not work properly with --tags).")
 }
 
+// AddPublishArg is the former name of AddPublishOptions.
+//
+// Deprecated: Use AddPublishOptions.
+func AddPublishArg(cmd *cobra.Command, po *PublishOptions) {
+	AddPublishOptions(cmd, po)
+}
+
 func packageWithMD5(base, importpath string) string {
 	hasher := md5.New() // nolint: gosec // No strong cryptography needed.
 	hasher.Write([]byte(importpath))
@@ -0,0 +1,43 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestAddPublishOptionsSetsDefaults(t *testing.T) {
+	t.Setenv("KO_DOCKER_REPO", "registry.example.com/repo")
+	cmd := &cobra.Command{}
+	po := &PublishOptions{}
+	AddPublishOptions(cmd, po)
+
+	want := &PublishOptions{
+		DockerRepo: "registry.example.com/repo",
+		Tags:       []string{"latest"},
+		Push:       true,
+	}
+	if !reflect.DeepEqual(po, want) {
+		t.Errorf("PublishOptions = %+v, want %+v", po, want)
+	}
+	for _, name := range []string{"tags", "tag-only", "push", "local", "insecure-registry", "oci-layout-path", "tarball", "image-refs", "preserve-import-paths", "base-import-paths", "bare"} {
+		if cmd.Flags().Lookup(name) == nil {
+			t.Errorf("flag --%s is not registered", name)
+		}
+	}
+}
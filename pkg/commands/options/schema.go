@@ -0,0 +1,241 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// configSchemaJSON is the JSON Schema of the config file. It mirrors the keys
+// read by LoadConfig and the fields of build.Config.
+//
+//go:embed config.schema.json
+var configSchemaJSON []byte
+
+// configSchema is configSchemaJSON, parsed.
+var configSchema = mustParseSchema(configSchemaJSON)
+
+// schema is the subset of JSON Schema needed to describe the config file.
+type schema struct {
+	Type                 schemaTypes        `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	AdditionalProperties *additional        `json:"additionalProperties"`
+	Items                *schema            `json:"items"`
+}
+
+// schemaTypes is the type of a schema, which may be a single type or a list
+// of types.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var types []string
+	if err := json.Unmarshal(b, &types); err == nil {
+		*t = types
+		return nil
+	}
+	var typ string
+	if err := json.Unmarshal(b, &typ); err != nil {
+		return err
+	}
+	*t = []string{typ}
+	return nil
+}
+
+// additional is the additionalProperties of a schema, which may either be a
+// boolean or the schema of the additional properties.
+type additional struct {
+	forbidden bool
+	schema    *schema
+}
+
+func (a *additional) UnmarshalJSON(b []byte) error {
+	var allowed bool
+	if err := json.Unmarshal(b, &allowed); err == nil {
+		a.forbidden = !allowed
+		return nil
+	}
+	return json.Unmarshal(b, &a.schema)
+}
+
+func mustParseSchema(b []byte) *schema {
+	var s schema
+	if err := json.Unmarshal(b, &s); err != nil {
+		panic(fmt.Sprintf("parsing config schema: %v", err))
+	}
+	return &s
+}
+
+// SchemaError describes a value of the config file that does not match the
+// config schema.
+type SchemaError struct {
+	// Path is the path of the value, e.g. builds[0].ldflags.
+	Path string
+	// Expected describes what the value should have been, e.g. "string".
+	Expected string
+	// Found is the value, or nil for an unknown field.
+	Found interface{}
+	// Unknown is true if the field is not supported at all.
+	Unknown bool
+}
+
+func (e *SchemaError) Error() string {
+	if e.Unknown {
+		return fmt.Sprintf("%s: unknown field, expected one of %s", e.Path, e.Expected)
+	}
+	found, err := json.Marshal(e.Found)
+	if err != nil {
+		found = []byte(fmt.Sprint(e.Found))
+	}
+	return fmt.Sprintf("%s: expected %s, found %s %s", e.Path, e.Expected, jsonType(e.Found), found)
+}
+
+// validateConfigFile checks the config file at path against the config
+// schema, and returns a SchemaError for each value that does not match.
+func validateConfigFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(b, &doc)
+	} else {
+		// JSON is YAML, too.
+		err = yaml.NewDecoder(bytes.NewReader(b)).Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			// An empty file.
+			return nil
+		}
+	}
+	if err != nil {
+		// Leave it to viper to report syntax errors.
+		return nil
+	}
+	return errors.Join(configSchema.validate("", doc)...)
+}
+
+// validate returns the errors of value, found at path, against s.
+func (s *schema) validate(path string, value interface{}) []error {
+	if value == nil {
+		// An empty value is the same as leaving it out.
+		return nil
+	}
+	if len(s.Type) > 0 && !s.hasType(jsonType(value)) {
+		return []error{&SchemaError{Path: displayPath(path), Expected: strings.Join(s.Type, " or "), Found: value}}
+	}
+
+	var errs []error
+	switch value := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			errs = append(errs, s.validateProperty(path, k, value[k])...)
+		}
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			m[fmt.Sprint(k)] = v
+		}
+		return s.validate(path, m)
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range value {
+				errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	}
+	return errs
+}
+
+// validateProperty returns the errors of the property k of an object found at
+// path. Like viper, property names are matched case-insensitively.
+func (s *schema) validateProperty(path, k string, v interface{}) []error {
+	child := path + "." + k
+	if prop, ok := s.Properties[k]; ok {
+		return prop.validate(child, v)
+	}
+	for name, prop := range s.Properties {
+		if strings.EqualFold(name, k) {
+			return prop.validate(child, v)
+		}
+	}
+	switch {
+	case s.AdditionalProperties == nil:
+		return nil
+	case s.AdditionalProperties.forbidden:
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return []error{&SchemaError{Path: displayPath(child), Expected: strings.Join(names, ", "), Unknown: true}}
+	default:
+		return s.AdditionalProperties.schema.validate(child, v)
+	}
+}
+
+func (s *schema) hasType(typ string) bool {
+	for _, t := range s.Type {
+		if t == typ || (t == "number" && typ == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type of a decoded YAML or TOML value.
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int64, uint64:
+		return "integer"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}, map[interface{}]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return strings.TrimPrefix(path, ".")
+}
@@ -0,0 +1,155 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/ko/pkg/build"
+)
+
+func TestLoadConfigValidatesSchema(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		filename string
+		config   string
+		want     []string
+	}{{
+		name:     "unknown top-level field",
+		filename: ".ko.yaml",
+		config:   "defaultBaseImage: alpine\ndefaultPlatform: linux/arm64\n",
+		want: []string{
+			"defaultPlatform: unknown field, expected one of annotations, baseImageOverrides, builds, defaultBaseImage, defaultPlatforms, defaultPushRepo, include, labels, platforms, sbom, sourceDateEpoch",
+		},
+	}, {
+		// Misspelled keys must not silently leave the base image unset.
+		name:     "snake case base image",
+		filename: ".ko.yaml",
+		config:   "base_image: alpine\n",
+		want: []string{
+			"base_image: unknown field, expected one of annotations, baseImageOverrides, builds, defaultBaseImage,",
+		},
+	}, {
+		name:     "unknown build field",
+		filename: ".ko.yaml",
+		config:   "builds:\n- id: app\n  ldflag: -s\n",
+		want: []string{
+			"builds[0].ldflag: unknown field, expected one of baseImage, binaryName, cgoEnabled, dir, env, extraFiles, flags, id, ldflags, main, platforms, tags, workingDirectory",
+		},
+	}, {
+		name:     "wrong types",
+		filename: ".ko.yaml",
+		config:   "defaultBaseImage: [alpine]\nbaseImageOverrides:\n  example.com/app: 42\nbuilds:\n  id: app\n",
+		want: []string{
+			`baseImageOverrides.example.com/app: expected string, found integer 42`,
+			`builds: expected array, found object {"id":"app"}`,
+			`defaultBaseImage: expected string, found array ["alpine"]`,
+		},
+	}, {
+		name:     "wrong item type",
+		filename: ".ko.yaml",
+		config:   "builds:\n- id: app\n  env:\n  - FOO=bar\n  - true\n",
+		want: []string{
+			"builds[0].env[1]: expected string, found boolean true",
+		},
+	}, {
+		name:     "unknown label field",
+		filename: ".ko.yaml",
+		config:   "labels:\n- value: a=b\n  if: CI\n",
+		want: []string{
+			"labels[0].if: unknown field, expected one of value, when",
+		},
+	}, {
+		name:     "toml",
+		filename: ".ko.toml",
+		config:   "defaultBaseImage = 1\n",
+		want: []string{
+			"defaultBaseImage: expected string, found integer 1",
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, tc.filename), []byte(tc.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			bo := &BuildOptions{WorkingDirectory: dir}
+			err := bo.LoadConfig()
+			if err == nil {
+				t.Fatal("LoadConfig() = nil, want error")
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("LoadConfig() = %v, want error containing %q", err, want)
+				}
+			}
+			var se *SchemaError
+			if !errors.As(err, &se) {
+				t.Errorf("LoadConfig() = %v, want a *SchemaError", err)
+			}
+			var ce *ConfigError
+			if !errors.As(err, &ce) || ce.Kind != ConfigInvalid {
+				t.Errorf("LoadConfig() = %v, want a *ConfigError of kind %v", err, ConfigInvalid)
+			}
+		})
+	}
+}
+
+func TestLoadConfigAcceptsValidConfigs(t *testing.T) {
+	for _, dir := range []string{"testdata/config", "testdata/toml", "testdata/annotations", "testdata/labels", "testdata/labels-when", "testdata/platforms", "testdata/paths", "testdata/push-repo"} {
+		t.Run(dir, func(t *testing.T) {
+			t.Setenv("SOURCE", "example.com/repo")
+			bo := &BuildOptions{WorkingDirectory: dir}
+			if err := bo.LoadConfig(); err != nil {
+				t.Errorf("LoadConfig() = %v", err)
+			}
+		})
+	}
+}
+
+// TestConfigSchemaCoversBuildConfig makes sure the schema is updated along
+// with build.Config and build.FileEntry, by comparing the properties of their
+// schemas with the names given to their fields by their yaml tags.
+func TestConfigSchemaCoversBuildConfig(t *testing.T) {
+	builds := configSchema.Properties["builds"].Items
+	checkSchemaCoversFields(t, builds, reflect.TypeOf(build.Config{}))
+	checkSchemaCoversFields(t, builds.Properties["extraFiles"].Items, reflect.TypeOf(build.FileEntry{}))
+}
+
+func checkSchemaCoversFields(t *testing.T, s *schema, typ reflect.Type) {
+	t.Helper()
+	fields := map[string]bool{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		// Like yaml.v3, default to the lowercased field name.
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = true
+		if _, ok := s.Properties[name]; !ok {
+			t.Errorf("config schema is missing %s field %q", typ, name)
+		}
+	}
+	for name := range s.Properties {
+		if !fields[name] {
+			t.Errorf("config schema has property %q, which is not a field of %s", name, typ)
+		}
+	}
+}
@@ -16,6 +16,7 @@ package options
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"strings"
 )
@@ -51,6 +52,12 @@ func Validate(po *PublishOptions, bo *BuildOptions) error {
 		log.Print(localFlagsWarning)
 	}
 
+	switch bo.SBOMFormat {
+	case "", "spdx", "cyclonedx":
+	default:
+		return fmt.Errorf("unsupported --sbom-format %q, must be spdx or cyclonedx", bo.SBOMFormat)
+	}
+
 	if len(bo.Platforms) > 1 {
 		for _, platform := range bo.Platforms {
 			if platform == "all" {
@@ -0,0 +1,99 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// findWorkFile returns the go.work file that the go command uses for dir,
+// or "" if dir isn't part of a workspace. Like the go command, it honors
+// GOWORK, and otherwise looks for go.work in dir and its parents.
+func findWorkFile(dir string) string {
+	switch gowork := os.Getenv("GOWORK"); gowork {
+	case "off":
+		return ""
+	case "":
+	default:
+		return gowork
+	}
+	for {
+		path := filepath.Join(dir, "go.work")
+		if isRegularFile(path) {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// workspaceModules returns the absolute directories of the modules listed
+// in the use directives of the go.work file at path.
+func workspaceModules(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	work, err := modfile.ParseWork(path, b, nil)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0, len(work.Use))
+	for _, use := range work.Use {
+		dir := filepath.FromSlash(use.Path)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		dirs = append(dirs, filepath.Clean(dir))
+	}
+	return dirs, nil
+}
+
+// workspaceModuleFor returns the directory of the module in the workspace
+// described by the go.work file at path that contains the absolute
+// directory dir. Modules may be nested, so the innermost one wins.
+func workspaceModuleFor(path, dir string) (string, error) {
+	modules, err := workspaceModules(path)
+	if err != nil {
+		return "", err
+	}
+	var best string
+	for _, module := range modules {
+		rel, err := filepath.Rel(module, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(module) > len(best) {
+			best = module
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("directory %s is not in any module listed in %s", dir, path)
+	}
+	return best, nil
+}
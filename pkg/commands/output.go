@@ -0,0 +1,220 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/resolve"
+)
+
+// encodeDocs encodes the given documents in the requested output format.
+func encodeDocs(docs []*yaml.Node, oo *options.OutputOptions) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	switch oo.OutputFormat {
+	case "", options.OutputFormatYAML:
+		b, err := resolve.DocsToBytes(docs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode output: %w", err)
+		}
+		buf.Write(b)
+
+	case options.OutputFormatJSON, options.OutputFormatJSONL:
+		for _, doc := range docs {
+			if doc.Kind == yaml.DocumentNode && len(doc.Content) == 0 {
+				continue
+			}
+			compact := &bytes.Buffer{}
+			if err := writeJSON(compact, doc); err != nil {
+				return nil, fmt.Errorf("failed to encode output: %w", err)
+			}
+			if oo.OutputFormat == options.OutputFormatJSON {
+				if err := json.Indent(buf, compact.Bytes(), "", "  "); err != nil {
+					return nil, fmt.Errorf("failed to encode output: %w", err)
+				}
+			} else {
+				buf.Write(compact.Bytes())
+			}
+			buf.WriteByte('\n')
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported output format %q", oo.OutputFormat)
+	}
+	return buf.Bytes(), nil
+}
+
+// documentSeparator returns what is written after the output of each file.
+func documentSeparator(oo *options.OutputOptions) string {
+	switch oo.OutputFormat {
+	case options.OutputFormatJSON, options.OutputFormatJSONL:
+		// Every JSON document already ends in a newline.
+		return ""
+	default:
+		return "\n---\n"
+	}
+}
+
+// writeJSON writes node to buf as compact JSON. Unlike decoding into a map
+// and marshalling that, this keeps mapping keys in their original order.
+func writeJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeJSON(buf, node.Content[0])
+
+	case yaml.AliasNode:
+		return writeJSON(buf, node.Alias)
+
+	case yaml.MappingNode:
+		fields, err := mappingFields(node)
+		if err != nil {
+			return err
+		}
+		buf.WriteByte('{')
+		for i, f := range fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONValue(buf, f.key); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeJSON(buf, f.value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+
+	case yaml.ScalarNode:
+		var v interface{}
+		if err := node.Decode(&v); err != nil {
+			return err
+		}
+		if f, ok := v.(float64); ok && (math.IsInf(f, 0) || math.IsNaN(f)) {
+			return fmt.Errorf("line %d: %s cannot be written as JSON, which has no infinity or NaN", node.Line, node.Value)
+		}
+		return writeJSONValue(buf, v)
+
+	default:
+		return fmt.Errorf("line %d: unexpected YAML node kind %d", node.Line, node.Kind)
+	}
+}
+
+// mappingField is a key of a mapping node along with its value.
+type mappingField struct {
+	key   string
+	value *yaml.Node
+}
+
+// mappingFields returns the fields of a mapping node in their original
+// order, with merge keys (<<) replaced by the fields of the mappings they
+// refer to. Like when decoding YAML, the fields of the mapping itself take
+// precedence over merged ones, and earlier merged mappings over later ones.
+func mappingFields(node *yaml.Node) ([]mappingField, error) {
+	var fields []mappingField
+	index := map[string]int{}
+	// add adds f, or replaces the value of a field with the same key if f is
+	// a field of the mapping itself rather than a merged one.
+	add := func(f mappingField, explicit bool) {
+		if i, ok := index[f.key]; !ok {
+			index[f.key] = len(fields)
+			fields = append(fields, f)
+		} else if explicit {
+			fields[i].value = f.value
+		}
+	}
+	merge := func(value *yaml.Node) error {
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		if value.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: merge keys must refer to mappings", value.Line)
+		}
+		merged, err := mappingFields(value)
+		if err != nil {
+			return err
+		}
+		for _, f := range merged {
+			add(f, false)
+		}
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Kind == yaml.AliasNode {
+			key = key.Alias
+		}
+		if key.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("line %d: mapping keys must be scalars to be written as JSON", key.Line)
+		}
+		if key.ShortTag() != "!!merge" {
+			add(mappingField{key: key.Value, value: value}, true)
+			continue
+		}
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		if value.Kind != yaml.SequenceNode {
+			if err := merge(value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, item := range value.Content {
+			if err := merge(item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return fields, nil
+}
+
+// writeJSONValue writes v to buf without escaping HTML characters, which
+// json.Marshal would otherwise do.
+func writeJSONValue(buf *bytes.Buffer, v interface{}) error {
+	e := json.NewEncoder(buf)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		return err
+	}
+	// Drop the newline the encoder adds after each value.
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestEncodeDocsJSONL(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  string
+		err   string
+	}{{
+		name:  "keys keep their order",
+		input: "b: 1\na: [x, true, null]\n",
+		want:  `{"b":1,"a":["x",true,null]}`,
+	}, {
+		name:  "aliases",
+		input: "a: &x {k: v}\nb: *x\n",
+		want:  `{"a":{"k":"v"},"b":{"k":"v"}}`,
+	}, {
+		name:  "merge key",
+		input: "base: &base {a: 1, b: 2}\nc:\n  <<: *base\n  b: 3\n",
+		want:  `{"base":{"a":1,"b":2},"c":{"a":1,"b":3}}`,
+	}, {
+		name:  "explicit keys take precedence wherever they are",
+		input: "base: &base {a: 1, b: 2}\nc:\n  b: 3\n  <<: *base\n",
+		want:  `{"base":{"a":1,"b":2},"c":{"b":3,"a":1}}`,
+	}, {
+		name:  "earlier merged mappings take precedence",
+		input: "x: &x {a: 1}\ny: &y {a: 2, b: 2}\nc:\n  <<: [*x, *y]\n",
+		want:  `{"x":{"a":1},"y":{"a":2,"b":2},"c":{"a":1,"b":2}}`,
+	}, {
+		name:  "quoted << is an ordinary key",
+		input: "\"<<\": {a: 1}\n",
+		want:  `{"<<":{"a":1}}`,
+	}, {
+		name:  "merge key with a scalar",
+		input: "a:\n  <<: 1\n",
+		err:   "line 2: merge keys must refer to mappings",
+	}, {
+		name:  "infinity",
+		input: "a: 1\nb: .inf\n",
+		err:   "line 2: .inf cannot be written as JSON",
+	}, {
+		name:  "negative infinity",
+		input: "a: [-.Inf]\n",
+		err:   "line 1: -.Inf cannot be written as JSON",
+	}, {
+		name:  "NaN",
+		input: "a:\n  b:\n    c: .nan\n",
+		err:   "line 3: .nan cannot be written as JSON",
+	}, {
+		name:  "non-scalar key",
+		input: "? [a]\n: b\n",
+		err:   "line 1: mapping keys must be scalars",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(tc.input), &doc); err != nil {
+				t.Fatalf("yaml.Unmarshal() = %v", err)
+			}
+			got, err := encodeDocs([]*yaml.Node{&doc}, &options.OutputOptions{OutputFormat: options.OutputFormatJSONL})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("encodeDocs() = %v, want an error containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("encodeDocs() = %v", err)
+			}
+			if string(got) != tc.want+"\n" {
+				t.Errorf("encodeDocs() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@ package commands
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/google/ko/pkg/commands/options"
 	"github.com/spf13/cobra"
@@ -28,6 +29,7 @@ func addResolve(topLevel *cobra.Command) {
 	fo := &options.FilenameOptions{}
 	so := &options.SelectorOptions{}
 	bo := &options.BuildOptions{}
+	oo := &options.OutputOptions{}
 
 	resolve := &cobra.Command{
 		Use:   "resolve -f FILENAME",
@@ -52,12 +54,18 @@ func addResolve(topLevel *cobra.Command) {
   # daemon as:
   #   ko.local/<import path>
   # This always preserves import paths.
-  ko resolve --local -f config/`,
+  ko resolve --local -f config/
+
+  # Print the resolved documents as JSON, one object per line.
+  ko resolve --output-format=jsonl -f config/`,
 		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if err := options.Validate(po, bo); err != nil {
 				return fmt.Errorf("validating options: %w", err)
 			}
+			if err := oo.Validate(); err != nil {
+				return fmt.Errorf("validating options: %w", err)
+			}
 
 			ctx := cmd.Context()
 
@@ -66,17 +74,26 @@ func addResolve(topLevel *cobra.Command) {
 			if err != nil {
 				return fmt.Errorf("error creating builder: %w", err)
 			}
-			publisher, err := makePublisher(po)
+			publisher, err := makePublisher(po, bo)
 			if err != nil {
 				return fmt.Errorf("error creating publisher: %w", err)
 			}
+			publisher = withPostBuildHooks(publisher, bo)
 			defer publisher.Close()
-			return ResolveFilesToWriter(ctx, builder, publisher, fo, so, os.Stdout)
+			if bo.Watch {
+				return watchFiles(ctx, builder, publisher, fo, so, oo, os.Stdout, bo, resolveOptions(bo)...)
+			}
+			return ResolveFilesToWriterWithOutput(ctx, builder, publisher, fo, so, oo, os.Stdout, resolveOptions(bo)...)
 		},
 	}
-	options.AddPublishArg(resolve, po)
+	options.AddPublishOptions(resolve, po)
 	options.AddFileArg(resolve, fo)
 	options.AddSelectorArg(resolve, so)
 	options.AddBuildOptions(resolve, bo)
+	options.AddOutputArg(resolve, oo)
+	resolve.Flags().BoolVar(&bo.Watch, "watch", false,
+		"Rebuild and re-resolve whenever Go source files of the referenced import paths change.")
+	resolve.Flags().DurationVar(&bo.WatchDebounce, "watch-debounce", 500*time.Millisecond,
+		"How long --watch waits for changes to settle before rebuilding.")
 	topLevel.AddCommand(resolve)
 }
@@ -15,21 +15,27 @@
 package commands
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"os/exec"
 	"path"
 	"strings"
 	"sync"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	ctypes "github.com/sigstore/cosign/v2/pkg/types"
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/labels"
 
+	"github.com/google/ko/internal/sbom"
 	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/commands/options"
 	"github.com/google/ko/pkg/publish"
@@ -89,7 +95,11 @@ func gobuildOptions(bo *options.BuildOptions) ([]build.Option, error) {
 		build.WithPlatforms(bo.Platforms...),
 		build.WithJobs(bo.ConcurrentBuilds),
 	}
-	if creationTime != nil {
+	if bo.RecordCreationTimestamp {
+		opts = append(opts, build.WithCreationTimestamp())
+	} else if bo.SourceDateEpoch != nil {
+		opts = append(opts, build.WithSourceDateEpoch(*bo.SourceDateEpoch))
+	} else if creationTime != nil {
 		opts = append(opts, build.WithCreationTime(*creationTime))
 	}
 	if kodataCreationTime != nil {
@@ -109,6 +119,12 @@ func gobuildOptions(bo *options.BuildOptions) ([]build.Option, error) {
 		opts = append(opts, build.WithSPDX(version()))
 	}
 	opts = append(opts, build.WithTrimpath(bo.Trimpath))
+	if len(bo.GoFlags) > 0 {
+		opts = append(opts, build.WithGoFlags(bo.GoFlags...))
+	}
+	if bo.GoBuildCache != "" {
+		opts = append(opts, build.WithGoBuildCache(bo.GoBuildCache))
+	}
 	for _, lf := range bo.Labels {
 		parts := strings.SplitN(lf, "=", 2)
 		if len(parts) != 2 {
@@ -116,6 +132,13 @@ func gobuildOptions(bo *options.BuildOptions) ([]build.Option, error) {
 		}
 		opts = append(opts, build.WithLabel(parts[0], parts[1]))
 	}
+	for _, af := range bo.Annotations {
+		k, v, ok := strings.Cut(af, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid annotation flag: %s", af)
+		}
+		opts = append(opts, build.WithAnnotation(k, v))
+	}
 
 	if bo.BuildConfigs != nil {
 		opts = append(opts, build.WithConfig(bo.BuildConfigs))
@@ -128,6 +151,25 @@ func gobuildOptions(bo *options.BuildOptions) ([]build.Option, error) {
 	return opts, nil
 }
 
+// buildManifestEnv is the environment variable that, when set to a file path,
+// makes ko append a JSON record of each image it resolves to that file, one
+// per line.
+const buildManifestEnv = "KO_BUILD_MANIFEST"
+
+func resolveOptions(bo *options.BuildOptions) []resolve.Option {
+	// Most files passed to ko hold no references at all.
+	opts := []resolve.Option{resolve.WithFastPathSkip()}
+	if bo.ConcurrencyLimit > 0 {
+		opts = append(opts, resolve.WithConcurrencyLimit(bo.ConcurrencyLimit))
+	}
+	if path := os.Getenv(buildManifestEnv); path != "" {
+		// Failing to write the build manifest is only logged, so log it to
+		// stderr rather than discarding it.
+		opts = append(opts, resolve.WithBuildManifest(path), resolve.WithLogger(slog.Default()))
+	}
+	return opts
+}
+
 // NewBuilder creates a ko builder
 func NewBuilder(ctx context.Context, bo *options.BuildOptions) (build.Interface, error) {
 	return makeBuilder(ctx, bo)
@@ -145,6 +187,10 @@ func makeBuilder(ctx context.Context, bo *options.BuildOptions) (*build.Caching,
 	if err != nil {
 		return nil, err
 	}
+	innerBuilder, err = withRegistryCache(innerBuilder, bo, opt)
+	if err != nil {
+		return nil, err
+	}
 
 	// tl;dr Wrap builder in a caching builder.
 	//
@@ -162,106 +208,153 @@ func makeBuilder(ctx context.Context, bo *options.BuildOptions) (*build.Caching,
 	return build.NewCaching(innerBuilder)
 }
 
-// NewPublisher creates a ko publisher
-func NewPublisher(po *options.PublishOptions) (publish.Interface, error) {
-	return makePublisher(po)
+// withRegistryCache wraps builder to share build results through the
+// repositories given by --cache-from and --cache-to, if any. The options that
+// builder was created with go into the cache keys.
+func withRegistryCache(builder build.Interface, bo *options.BuildOptions, buildOpts []build.Option) (build.Interface, error) {
+	if bo.CacheFrom == "" && bo.CacheTo == "" {
+		return builder, nil
+	}
+	userAgent := ua()
+	if bo.UserAgent != "" {
+		userAgent = bo.UserAgent
+	}
+	var nameOpts []name.Option
+	if bo.InsecureRegistry {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	opts := []build.CachingOption{
+		build.WithCacheRemoteOptions(
+			remote.WithAuthFromKeychain(keychain),
+			remote.WithUserAgent(userAgent),
+		),
+		build.WithCacheBuildOptions(buildOpts...),
+	}
+	for _, c := range []struct {
+		repo string
+		opt  func(name.Repository) build.CachingOption
+	}{
+		{repo: bo.CacheFrom, opt: build.WithCacheFrom},
+		{repo: bo.CacheTo, opt: build.WithCacheTo},
+	} {
+		if c.repo == "" {
+			continue
+		}
+		repo, err := name.NewRepository(c.repo, nameOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, c.opt(repo))
+	}
+	return build.NewCachingBuilder(builder, bo.WorkingDirectory, "", opts...)
 }
 
-func makePublisher(po *options.PublishOptions) (publish.Interface, error) {
-	// use each tag only once
-	po.Tags = unique(po.Tags)
-	// Create the publish.Interface that we will use to publish image references
-	// to either a docker daemon or a container image registry.
-	innerPublisher, err := func() (publish.Interface, error) {
-		repoName := po.DockerRepo
-		namer := options.MakeNamer(po)
-		// Default LocalDomain if unset.
-		if po.LocalDomain == "" {
-			po.LocalDomain = publish.LocalDomain
-		}
-		// If repoName is unset with --local, default it to the local domain.
-		if po.Local && repoName == "" {
-			repoName = po.LocalDomain
-		}
-		// When in doubt, if repoName is under the local domain, default to --local.
-		po.Local = po.Local || strings.HasPrefix(repoName, po.LocalDomain)
-		if po.Local {
-			// TODO(jonjohnsonjr): I'm assuming that nobody will
-			// use local with other publishers, but that might
-			// not be true.
-			po.LocalDomain = repoName
-			return publish.NewDaemon(namer, po.Tags,
-				publish.WithDockerClient(po.DockerClient),
-				publish.WithLocalDomain(po.LocalDomain),
-			)
-		}
-		if strings.HasPrefix(repoName, publish.KindDomain) {
-			return publish.NewKindPublisher(namer, po.Tags), nil
-		}
-
-		if repoName == "" && po.Push {
-			return nil, errors.New("KO_DOCKER_REPO environment variable is unset")
-		}
-		if _, err := name.NewRegistry(repoName); err != nil {
-			if _, err := name.NewRepository(repoName); err != nil {
-				return nil, fmt.Errorf("failed to parse %q as repository: %w", repoName, err)
-			}
+// withPostBuildHooks wraps publisher to run the post-build hooks of bo after
+// each image is published.
+func withPostBuildHooks(publisher publish.Interface, bo *options.BuildOptions) publish.Interface {
+	if len(bo.PostBuildHooks) == 0 {
+		return publisher
+	}
+	hooks := make([]publish.Hook, 0, len(bo.PostBuildHooks))
+	for _, command := range bo.PostBuildHooks {
+		hooks = append(hooks, commandHook(command))
+	}
+	return publish.NewHooked(publisher, hooks...)
+}
+
+// commandHook returns a publish.Hook that runs command with the shell, after
+// replacing `{IMAGE}` with the published image reference. Its output goes to
+// stderr, so it doesn't end up in resolved yaml written to stdout.
+func commandHook(command string) publish.Hook {
+	return func(ctx context.Context, ref name.Reference) error {
+		cmd := exec.CommandContext(ctx, "sh", "-c", strings.ReplaceAll(command, "{IMAGE}", ref.String())) //nolint:gosec
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("post-build hook %q: %w", command, err)
 		}
+		return nil
+	}
+}
 
-		publishers := []publish.Interface{}
-		if po.OCILayoutPath != "" {
-			lp, err := publish.NewLayout(po.OCILayoutPath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create LayoutPublisher for %q: %w", po.OCILayoutPath, err)
-			}
-			publishers = append(publishers, lp)
+// goListSBOM returns a publish.SBOMFunc that generates SBOMs in the format
+// bo.SBOMFormat from the module graph reported by `go list -json -deps`, for
+// the platform of each image.
+func goListSBOM(bo *options.BuildOptions) publish.SBOMFunc {
+	return func(ctx context.Context, s string, img v1.Image) ([]byte, types.MediaType, error) {
+		cf, err := img.ConfigFile()
+		if err != nil {
+			return nil, "", err
 		}
-		if po.TarballFile != "" {
-			tp := publish.NewTarball(po.TarballFile, repoName, namer, po.Tags)
-			publishers = append(publishers, tp)
+		dir := bo.WorkingDirectory
+		env := []string{"CGO_ENABLED=0", "GOOS=" + cf.OS, "GOARCH=" + cf.Architecture}
+		if config, ok := bo.GetBuildConfig(s); ok {
+			dir = path.Join(dir, config.Dir)
+			env = append(env, config.Env...)
 		}
-		userAgent := ua()
-		if po.UserAgent != "" {
-			userAgent = po.UserAgent
+
+		goBinary := os.Getenv("KO_GO_PATH")
+		if goBinary == "" {
+			goBinary = "go"
 		}
-		if po.Push {
-			dp, err := publish.NewDefault(repoName,
-				publish.WithUserAgent(userAgent),
-				publish.WithAuthFromKeychain(keychain),
-				publish.WithNamer(namer),
-				publish.WithTags(po.Tags),
-				publish.WithTagOnly(po.TagOnly),
-				publish.Insecure(po.InsecureRegistry),
-				publish.WithJobs(po.Jobs),
-			)
-			if err != nil {
-				return nil, err
-			}
-			publishers = append(publishers, dp)
+		cmd := exec.CommandContext(ctx, goBinary, "list", "-json", "-deps", strings.TrimPrefix(s, build.StrictScheme)) //nolint:gosec
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, "", fmt.Errorf("go list: %w", err)
+		}
+		bi, err := sbom.BuildInfoFromGoList(out)
+		if err != nil {
+			return nil, "", err
 		}
 
-		// If not publishing, at least generate a digest to simulate
-		// publishing.
-		if len(publishers) == 0 {
-			// If one or more tags are specified, use the first tag in the list
-			var tag string
-			if len(po.Tags) >= 1 {
-				tag = po.Tags[0]
-			}
-			publishers = append(publishers, nopPublisher{
-				repoName: repoName,
-				namer:    namer,
-				tag:      tag,
-				tagOnly:  po.TagOnly,
-			})
+		switch bo.SBOMFormat {
+		case "spdx":
+			b, err := sbom.GenerateImageSPDXFromBuildInfo(version(), bi, img)
+			return b, ctypes.SPDXJSONMediaType, err
+		case "cyclonedx":
+			b, err := sbom.GenerateImageCycloneDXFromBuildInfo(bi)
+			return b, ctypes.CycloneDXJSONMediaType, err
+		default:
+			return nil, "", fmt.Errorf("unsupported SBOM format %q", bo.SBOMFormat)
 		}
+	}
+}
+
+// NewPublisher creates a ko publisher
+func NewPublisher(po *options.PublishOptions) (publish.Interface, error) {
+	return makePublisher(po, nil)
+}
 
-		return publish.MultiPublisher(publishers...), nil
-	}()
+// makePublisher creates the publisher for po. If bo is not nil, import paths
+// whose build config sets Tags are published with those tags instead.
+func makePublisher(po *options.PublishOptions, bo *options.BuildOptions) (publish.Interface, error) {
+	po.DockerRepo = pushRepo(po, bo)
+	innerPublisher, err := makeInnerPublisher(po)
 	if err != nil {
 		return nil, err
 	}
 
+	if bo != nil {
+		innerPublisher, err = withConfigTags(innerPublisher, po, bo)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if bo != nil && bo.SBOMFormat != "" && po.Push && !po.Local && !strings.HasPrefix(po.DockerRepo, publish.KindDomain) {
+		userAgent := ua()
+		if po.UserAgent != "" {
+			userAgent = po.UserAgent
+		}
+		innerPublisher = publish.NewSBOMReferrers(innerPublisher, goListSBOM(bo),
+			remote.WithAuthFromKeychain(keychain),
+			remote.WithUserAgent(userAgent),
+		)
+	}
+
 	if po.ImageRefsFile != "" {
 		f, err := os.OpenFile(po.ImageRefsFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 		if err != nil {
@@ -277,6 +370,168 @@ func makePublisher(po *options.PublishOptions) (publish.Interface, error) {
 	return publish.NewCaching(innerPublisher)
 }
 
+// pushRepo returns the repository to publish to: KO_DOCKER_REPO if it is
+// set, or else the defaultPushRepo of `.ko.yaml`.
+func pushRepo(po *options.PublishOptions, bo *options.BuildOptions) string {
+	if po.DockerRepo == "" && bo != nil {
+		return bo.DefaultPushRepo
+	}
+	return po.DockerRepo
+}
+
+// makeInnerPublisher creates the publish.Interface that we will use to
+// publish image references to either a docker daemon or a container image
+// registry.
+func makeInnerPublisher(po *options.PublishOptions) (publish.Interface, error) {
+	// use each tag only once
+	po.Tags = unique(po.Tags)
+	repoName := po.DockerRepo
+	namer := options.MakeNamer(po)
+	// Default LocalDomain if unset.
+	if po.LocalDomain == "" {
+		po.LocalDomain = publish.LocalDomain
+	}
+	// If repoName is unset with --local, default it to the local domain.
+	if po.Local && repoName == "" {
+		repoName = po.LocalDomain
+	}
+	// When in doubt, if repoName is under the local domain, default to --local.
+	po.Local = po.Local || strings.HasPrefix(repoName, po.LocalDomain)
+	if po.Local {
+		// TODO(jonjohnsonjr): I'm assuming that nobody will
+		// use local with other publishers, but that might
+		// not be true.
+		po.LocalDomain = repoName
+		return publish.NewDaemon(namer, po.Tags,
+			publish.WithDockerClient(po.DockerClient),
+			publish.WithLocalDomain(po.LocalDomain),
+		)
+	}
+	if strings.HasPrefix(repoName, publish.KindDomain) {
+		return publish.NewKindPublisher(namer, po.Tags), nil
+	}
+
+	if repoName == "" {
+		if po.Push {
+			return nil, errors.New("KO_DOCKER_REPO environment variable is unset, and .ko.yaml sets no defaultPushRepo")
+		}
+		// Nothing is pushed, e.g. images are only saved with
+		// --oci-layout-path, but references still need a repository.
+		repoName = po.LocalDomain
+	}
+	if _, err := name.NewRegistry(repoName); err != nil {
+		if _, err := name.NewRepository(repoName); err != nil {
+			return nil, fmt.Errorf("failed to parse %q as repository: %w", repoName, err)
+		}
+	}
+
+	publishers := []publish.Interface{}
+	if po.OCILayoutPath != "" {
+		lp, err := publish.NewLayout(po.OCILayoutPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create LayoutPublisher for %q: %w", po.OCILayoutPath, err)
+		}
+		publishers = append(publishers, lp)
+	}
+	if po.TarballFile != "" {
+		tp := publish.NewTarball(po.TarballFile, repoName, namer, po.Tags)
+		publishers = append(publishers, tp)
+	}
+	userAgent := ua()
+	if po.UserAgent != "" {
+		userAgent = po.UserAgent
+	}
+	if po.Push {
+		dp, err := publish.NewDefault(repoName,
+			publish.WithUserAgent(userAgent),
+			publish.WithAuthFromKeychain(keychain),
+			publish.WithNamer(namer),
+			publish.WithTags(po.Tags),
+			publish.WithTagOnly(po.TagOnly),
+			publish.Insecure(po.InsecureRegistry),
+			publish.WithJobs(po.Jobs),
+		)
+		if err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, dp)
+	}
+
+	// If not publishing, at least generate a digest to simulate
+	// publishing.
+	if len(publishers) == 0 {
+		// If one or more tags are specified, use the first tag in the list
+		var tag string
+		if len(po.Tags) >= 1 {
+			tag = po.Tags[0]
+		}
+		publishers = append(publishers, nopPublisher{
+			repoName: repoName,
+			namer:    namer,
+			tag:      tag,
+			tagOnly:  po.TagOnly,
+		})
+	}
+
+	return publish.MultiPublisher(publishers...), nil
+}
+
+// configTagsPublisher publishes import paths whose build config sets Tags
+// with a publisher for those tags, and everything else with inner.
+type configTagsPublisher struct {
+	inner publish.Interface
+	bo    *options.BuildOptions
+	// byTags holds a publisher for each distinct list of tags, keyed by the
+	// comma separated tags.
+	byTags map[string]publish.Interface
+}
+
+// withConfigTags wraps inner in a configTagsPublisher if any build config in
+// bo sets Tags. The publishers for those tags are created like inner, with
+// their tags in place of the tags in po.
+func withConfigTags(inner publish.Interface, po *options.PublishOptions, bo *options.BuildOptions) (publish.Interface, error) {
+	byTags := map[string]publish.Interface{}
+	for _, config := range bo.BuildConfigs {
+		if len(config.Tags) == 0 {
+			continue
+		}
+		key := strings.Join(config.Tags, ",")
+		if _, ok := byTags[key]; ok {
+			continue
+		}
+		tpo := *po
+		tpo.Tags = config.Tags
+		pub, err := makeInnerPublisher(&tpo)
+		if err != nil {
+			return nil, fmt.Errorf("creating publisher for tags %s: %w", key, err)
+		}
+		byTags[key] = pub
+	}
+	if len(byTags) == 0 {
+		return inner, nil
+	}
+	return &configTagsPublisher{inner: inner, bo: bo, byTags: byTags}, nil
+}
+
+// Publish implements publish.Interface
+func (p *configTagsPublisher) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	if config, ok := p.bo.GetBuildConfig(s); ok && len(config.Tags) > 0 {
+		return p.byTags[strings.Join(config.Tags, ",")].Publish(ctx, br, s)
+	}
+	return p.inner.Publish(ctx, br, s)
+}
+
+// Close implements publish.Interface
+func (p *configTagsPublisher) Close() error {
+	err := p.inner.Close()
+	for _, pub := range p.byTags {
+		if perr := pub.Close(); perr != nil {
+			err = perr
+		}
+	}
+	return err
+}
+
 // nopPublisher simulates publishing without actually publishing anything, to
 // provide fallback behavior when the user configures no push destinations.
 type nopPublisher struct {
@@ -312,11 +567,26 @@ type resolvedFuture chan []byte
 
 func ResolveFilesToWriter(
 	ctx context.Context,
-	builder *build.Caching,
+	builder build.Interface,
+	publisher publish.Interface,
+	fo *options.FilenameOptions,
+	so *options.SelectorOptions,
+	out io.WriteCloser,
+	opts ...resolve.Option) error {
+	return ResolveFilesToWriterWithOutput(ctx, builder, publisher, fo, so, &options.OutputOptions{}, out, opts...)
+}
+
+// ResolveFilesToWriterWithOutput is like ResolveFilesToWriter, but writes the
+// resolved files in the format requested by oo instead of as YAML.
+func ResolveFilesToWriterWithOutput(
+	ctx context.Context,
+	builder build.Interface,
 	publisher publish.Interface,
 	fo *options.FilenameOptions,
 	so *options.SelectorOptions,
-	out io.WriteCloser) error {
+	oo *options.OutputOptions,
+	out io.WriteCloser,
+	opts ...resolve.Option) error {
 	defer out.Close()
 
 	// By having this as a channel, we can hook this up to a filesystem
@@ -373,7 +643,7 @@ func ResolveFilesToWriter(
 				recordingBuilder := &build.Recorder{
 					Builder: builder,
 				}
-				b, err := resolveFile(ctx, f, recordingBuilder, publisher, so)
+				b, err := resolveFile(ctx, f, recordingBuilder, publisher, so, oo, opts...)
 				if err != nil {
 					// This error is sometimes expected during watch mode, so this
 					// isn't fatal. Just print it and keep the watch open.
@@ -395,7 +665,7 @@ func ResolveFilesToWriter(
 				// We write the delimiter LAST so that when streamed to
 				// kubectl it knows that the resource is complete and may
 				// be applied.
-				out.Write(append(b, []byte("\n---\n")...))
+				out.Write(append(b, []byte(documentSeparator(oo))...))
 			}
 		}
 	}
@@ -410,7 +680,9 @@ func resolveFile(
 	f string,
 	builder build.Interface,
 	pub publish.Interface,
-	so *options.SelectorOptions) (b []byte, err error) {
+	so *options.SelectorOptions,
+	oo *options.OutputOptions,
+	opts ...resolve.Option) (b []byte, err error) {
 	var selector labels.Selector
 	if so.Selector != "" {
 		var err error
@@ -430,49 +702,29 @@ func resolveFile(
 		return nil, err
 	}
 
-	var docNodes []*yaml.Node
-
-	// The loop is to support multi-document yaml files.
-	// This is handled by using a yaml.Decoder and reading objects until io.EOF, see:
-	// https://godoc.org/gopkg.in/yaml.v3#Decoder.Decode
-	decoder := yaml.NewDecoder(bytes.NewBuffer(b))
-	for {
-		var doc yaml.Node
-		if err := decoder.Decode(&doc); err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			return nil, err
-		}
+	docs, err := resolve.DocsFromBytes(b)
+	if err != nil && !errors.Is(err, resolve.ErrNoDocuments) {
+		return nil, err
+	}
 
+	var docNodes []*yaml.Node
+	for _, doc := range docs {
 		if selector != nil {
-			if match, err := resolve.MatchesSelector(&doc, selector); err != nil {
+			if match, err := resolve.MatchesSelector(doc, selector); err != nil {
 				return nil, fmt.Errorf("error evaluating selector: %w", err)
 			} else if !match {
 				continue
 			}
 		}
 
-		docNodes = append(docNodes, &doc)
+		docNodes = append(docNodes, doc)
 	}
 
-	if err := resolve.ImageReferences(ctx, docNodes, builder, pub); err != nil {
+	if err := resolve.ImageReferences(ctx, docNodes, builder, pub, opts...); err != nil {
 		return nil, fmt.Errorf("error resolving image references: %w", err)
 	}
 
-	buf := &bytes.Buffer{}
-	e := yaml.NewEncoder(buf)
-	e.SetIndent(2)
-
-	for _, doc := range docNodes {
-		err := e.Encode(doc)
-		if err != nil {
-			return nil, fmt.Errorf("failed to encode output: %w", err)
-		}
-	}
-	e.Close()
-
-	return buf.Bytes(), nil
+	return encodeDocs(docNodes, oo)
 }
 
 // create a set from the input slice
@@ -17,6 +17,7 @@ package commands
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -24,6 +25,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -36,10 +38,12 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/daemon"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/commands/options"
 	kotesting "github.com/google/ko/pkg/internal/testing"
+	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 )
 
@@ -103,7 +107,8 @@ func TestResolveMultiDocumentYAMLs(t *testing.T) {
 		yamlToTmpFile(t, buf.Bytes()),
 		testBuilder,
 		kotesting.NewFixedPublish(base, testHashes),
-		&options.SelectorOptions{})
+		&options.SelectorOptions{},
+		&options.OutputOptions{})
 
 	if err != nil {
 		t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
@@ -137,6 +142,36 @@ func TestResolveMultiDocumentYAMLs(t *testing.T) {
 	}
 }
 
+func TestResolveBuildManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.jsonl")
+	t.Setenv(buildManifestEnv, path)
+	base := mustRepository("gcr.io/multi-pass")
+
+	input := []byte("image: " + build.StrictScheme + fooRef + "\n")
+	if _, err := resolveFile(
+		context.Background(),
+		yamlToTmpFile(t, input),
+		testBuilder,
+		kotesting.NewFixedPublish(base, testHashes),
+		&options.SelectorOptions{},
+		&options.OutputOptions{},
+		resolveOptions(&options.BuildOptions{})...); err != nil {
+		t.Fatalf("resolveFile() = %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("build manifest: %v", err)
+	}
+	var record struct{ Ref, Digest string }
+	if err := json.Unmarshal(b, &record); err != nil {
+		t.Fatalf("json.Unmarshal(%s) = %v", b, err)
+	}
+	if record.Ref != build.StrictScheme+fooRef || record.Digest != fooHash.String() {
+		t.Errorf("build manifest = %s, want a record of %s", b, fooRef)
+	}
+}
+
 func TestResolveMultiDocumentYAMLsWithSelector(t *testing.T) {
 	passesSelector := `apiVersion: something/v1
 kind: Foo
@@ -158,7 +193,8 @@ kind: Bar
 		kotesting.NewFixedPublish(base, testHashes),
 		&options.SelectorOptions{
 			Selector: "qux=baz",
-		})
+		},
+		&options.OutputOptions{})
 	if err != nil {
 		t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
 	}
@@ -167,6 +203,261 @@ kind: Bar
 	}
 }
 
+func TestResolveOutputFormats(t *testing.T) {
+	inputYAML := []byte(`apiVersion: v1
+kind: Pod
+metadata:
+  name: <app>
+spec:
+  containers:
+    - image: ko://github.com/awesomesauce/foo
+      ports:
+        - containerPort: 8080
+---
+kind: ConfigMap
+data:
+  enabled: true
+`)
+	base := mustRepository("gcr.io/output")
+	image := kotesting.ComputeDigest(base, fooRef, fooHash)
+	want := []string{
+		`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"<app>"},"spec":{"containers":[{"image":"` + image + `","ports":[{"containerPort":8080}]}]}}`,
+		`{"kind":"ConfigMap","data":{"enabled":true}}`,
+	}
+
+	for _, test := range []struct {
+		format string
+		// split splits the output into its JSON documents.
+		split func(t *testing.T, out []byte) []string
+	}{{
+		format: options.OutputFormatJSON,
+		split: func(t *testing.T, out []byte) []string {
+			var docs []string
+			d := json.NewDecoder(bytes.NewReader(out))
+			for {
+				var raw json.RawMessage
+				if err := d.Decode(&raw); errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatalf("json.Decode() = %v", err)
+				}
+				compact := &bytes.Buffer{}
+				if err := json.Compact(compact, raw); err != nil {
+					t.Fatalf("json.Compact() = %v", err)
+				}
+				docs = append(docs, compact.String())
+			}
+			return docs
+		},
+	}, {
+		format: options.OutputFormatJSONL,
+		split: func(t *testing.T, out []byte) []string {
+			lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+			for _, line := range lines {
+				if !json.Valid([]byte(line)) {
+					t.Errorf("invalid JSON line: %s", line)
+				}
+			}
+			return lines
+		},
+	}} {
+		t.Run(test.format, func(t *testing.T) {
+			out, err := resolveFile(
+				context.Background(),
+				yamlToTmpFile(t, inputYAML),
+				testBuilder,
+				kotesting.NewFixedPublish(base, testHashes),
+				&options.SelectorOptions{},
+				&options.OutputOptions{OutputFormat: test.format})
+			if err != nil {
+				t.Fatalf("resolveFile() = %v", err)
+			}
+			if diff := cmp.Diff(want, test.split(t, out)); diff != "" {
+				t.Errorf("resolveFile() (-want +got) = %v", diff)
+			}
+		})
+	}
+
+	t.Run(options.OutputFormatYAML, func(t *testing.T) {
+		out, err := resolveFile(
+			context.Background(),
+			yamlToTmpFile(t, inputYAML),
+			testBuilder,
+			kotesting.NewFixedPublish(base, testHashes),
+			&options.SelectorOptions{},
+			&options.OutputOptions{OutputFormat: options.OutputFormatYAML})
+		if err != nil {
+			t.Fatalf("resolveFile() = %v", err)
+		}
+		wantYAML := strings.Replace(string(inputYAML), build.StrictScheme+fooRef, image, 1)
+		if diff := cmp.Diff(wantYAML, string(out)); diff != "" {
+			t.Errorf("resolveFile() (-want +got) = %v", diff)
+		}
+	})
+}
+
+func TestPostBuildHooks(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	out := path.Join(t.TempDir(), "hooks")
+	bo := &options.BuildOptions{
+		PostBuildHooks: []string{
+			"echo first {IMAGE} >> " + out,
+			"echo second {IMAGE} >> " + out,
+		},
+	}
+	publisher := withPostBuildHooks(kotesting.NewFixedPublish(base, testHashes), bo)
+
+	ref, err := publisher.Publish(context.Background(), foo, fooRef)
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	if want := kotesting.ComputeDigest(base, fooRef, fooHash); ref.String() != want {
+		t.Fatalf("Publish() = %v, want %v", ref, want)
+	}
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("ReadFile() = %v", err)
+	}
+	want := fmt.Sprintf("first %s\nsecond %s\n", ref, ref)
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("hook output (-want +got) = %s", diff)
+	}
+}
+
+func TestPostBuildHookFailure(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	bo := &options.BuildOptions{
+		PostBuildHooks: []string{"exit 1"},
+	}
+	publisher := withPostBuildHooks(kotesting.NewFixedPublish(base, testHashes), bo)
+
+	if _, err := publisher.Publish(context.Background(), foo, fooRef); err == nil {
+		t.Error("Publish() should err when a post-build hook fails, got nil")
+	}
+}
+
+func TestRegistryCache(t *testing.T) {
+	builder := kotesting.NewFixedBuild(map[string]build.Result{fooRef: foo})
+	for _, tc := range []struct {
+		name       string
+		bo         *options.BuildOptions
+		wantCached bool
+	}{
+		{name: "no cache", bo: &options.BuildOptions{}},
+		{name: "cache from", bo: &options.BuildOptions{CacheFrom: "registry.example.com/cache"}, wantCached: true},
+		{name: "cache to", bo: &options.BuildOptions{CacheTo: "registry.example.com/cache"}, wantCached: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := withRegistryCache(builder, tc.bo, nil)
+			if err != nil {
+				t.Fatalf("withRegistryCache() = %v", err)
+			}
+			if _, cached := got.(*build.CachingBuilder); cached != tc.wantCached {
+				t.Errorf("withRegistryCache() = %T, want a caching builder: %t", got, tc.wantCached)
+			}
+		})
+	}
+}
+
+func TestConfigTags(t *testing.T) {
+	s := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer s.Close()
+
+	for _, test := range []struct {
+		description string
+		tags        []string
+		wantTags    []string
+	}{{
+		description: "no-tags",
+		wantTags:    []string{"default"},
+	}, {
+		description: "one-tag",
+		tags:        []string{"v1.2.3"},
+		wantTags:    []string{"v1.2.3"},
+	}, {
+		description: "multiple-tags",
+		tags:        []string{"v1.2.3", "latest"},
+		wantTags:    []string{"latest", "v1.2.3"},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			repo := fmt.Sprintf("%s/%s", s.Listener.Addr().String(), test.description)
+			po := &options.PublishOptions{
+				DockerRepo: repo,
+				Bare:       true,
+				Push:       true,
+				Tags:       []string{"default"},
+			}
+			bo := &options.BuildOptions{
+				BuildConfigs: map[string]build.Config{
+					fooRef: {ID: "foo", Tags: test.tags},
+				},
+			}
+			publisher, err := makePublisher(po, bo)
+			if err != nil {
+				t.Fatalf("makePublisher() = %v", err)
+			}
+			defer publisher.Close()
+
+			ref, err := publisher.Publish(context.Background(), foo, build.StrictScheme+fooRef)
+			if err != nil {
+				t.Fatalf("Publish() = %v", err)
+			}
+			if got := ref.Identifier(); got != fooHash.String() {
+				t.Errorf("Publish() = %v, want digest %v", ref, fooHash)
+			}
+
+			tags, err := crane.ListTags(repo)
+			if err != nil {
+				t.Fatalf("ListTags() = %v", err)
+			}
+			if diff := cmp.Diff(test.wantTags, tags, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+				t.Errorf("tags (-want +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestPushRepo(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		env         string
+		setEnv      bool
+		configRepo  string
+		want        string
+	}{{
+		description: "environment wins over config",
+		env:         "gcr.io/env",
+		setEnv:      true,
+		configRepo:  "gcr.io/config",
+		want:        "gcr.io/env",
+	}, {
+		description: "config when environment is unset",
+		configRepo:  "gcr.io/config",
+		want:        "gcr.io/config",
+	}, {
+		description: "environment without config",
+		env:         "gcr.io/env",
+		setEnv:      true,
+		want:        "gcr.io/env",
+	}, {
+		description: "neither",
+	}} {
+		t.Run(tc.description, func(t *testing.T) {
+			t.Setenv("KO_DOCKER_REPO", tc.env)
+			if !tc.setEnv {
+				os.Unsetenv("KO_DOCKER_REPO")
+			}
+			po := &options.PublishOptions{}
+			options.AddPublishOptions(&cobra.Command{}, po)
+			bo := &options.BuildOptions{DefaultPushRepo: tc.configRepo}
+			if got := pushRepo(po, bo); got != tc.want {
+				t.Errorf("pushRepo() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
 func TestNewBuilder(t *testing.T) {
 	namespace := "base"
 	s, err := registryServerWithImage(namespace)
@@ -320,6 +611,52 @@ func TestNewPublisherCanPublish(t *testing.T) {
 	}
 }
 
+func TestNewPublisherOCILayout(t *testing.T) {
+	// The layout publisher names images after the path, which must be a valid
+	// repository, unlike those of t.TempDir().
+	tmp, err := os.MkdirTemp("", "ko")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+	dir := filepath.Join(tmp, "layout")
+
+	// Without pushing, KO_DOCKER_REPO isn't needed.
+	po := &options.PublishOptions{OCILayoutPath: dir}
+	publisher, err := NewPublisher(po)
+	if err != nil {
+		t.Fatalf("NewPublisher(): %v", err)
+	}
+	defer publisher.Close()
+
+	img := mustRandom()
+	ref, err := publisher.Publish(context.Background(), img, build.StrictScheme+"github.com/google/ko/test")
+	if err != nil {
+		t.Fatalf("publisher.Publish(): %v", err)
+	}
+	if got, want := ref.Identifier(), mustDigest(img).String(); got != want {
+		t.Errorf("Publish() = %s, want digest %s", ref, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err != nil {
+		t.Fatalf("index.json: %v", err)
+	}
+	idx, err := layout.ImageIndexFromPath(dir)
+	if err != nil {
+		t.Fatalf("layout.ImageIndexFromPath(): %v", err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest(): %v", err)
+	}
+	if len(im.Manifests) != 1 || im.Manifests[0].Digest != mustDigest(img) {
+		t.Errorf("index.json manifests = %v, want the published image %s", im.Manifests, mustDigest(img))
+	}
+	if _, err := idx.Image(mustDigest(img)); err != nil {
+		t.Errorf("Image(%s): %v", mustDigest(img), err)
+	}
+}
+
 // registryServerWithImage starts a local registry and pushes a random image.
 // Use this to speed up tests, by not having to reach out to gcr.io for the default base image.
 // The registry uses a NOP logger to avoid spamming test logs.
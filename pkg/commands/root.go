@@ -21,6 +21,8 @@ import (
 	"github.com/google/go-containerregistry/pkg/logs"
 	"github.com/spf13/cobra"
 	"go.uber.org/automaxprocs/maxprocs"
+
+	"github.com/google/ko/pkg/commands/options"
 )
 
 var Root = New()
@@ -46,6 +48,7 @@ func New() *cobra.Command {
 		},
 	}
 	root.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable debug logs")
+	options.AddConfigPathFlag(root)
 
 	AddKubeCommands(root)
 
@@ -76,10 +76,11 @@ func addRun(topLevel *cobra.Command) {
 			if err != nil {
 				return fmt.Errorf("error creating builder: %w", err)
 			}
-			publisher, err := makePublisher(po)
+			publisher, err := makePublisher(po, bo)
 			if err != nil {
 				return fmt.Errorf("error creating publisher: %w", err)
 			}
+			publisher = withPostBuildHooks(publisher, bo)
 			defer publisher.Close()
 
 			if len(os.Args) < 3 {
@@ -144,7 +145,7 @@ func addRun(topLevel *cobra.Command) {
 			UnknownFlags: true,
 		},
 	}
-	options.AddPublishArg(run, po)
+	options.AddPublishOptions(run, po)
 	options.AddBuildOptions(run, bo)
 
 	topLevel.AddCommand(run)
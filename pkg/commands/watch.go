@@ -0,0 +1,218 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/publish"
+	"github.com/google/ko/pkg/resolve"
+)
+
+// watchSeparator is written between successive outputs of `ko resolve --watch`.
+const watchSeparator = "# ---- ko resolve --watch: sources changed, resolved again ----\n"
+
+// watchFiles resolves the files in fo to out like ResolveFilesToWriter, and
+// then does so again every time a Go source file of the main module that the
+// referenced import paths depend on changes. It returns when ctx is done.
+func watchFiles(
+	ctx context.Context,
+	builder *build.Caching,
+	publisher publish.Interface,
+	fo *options.FilenameOptions,
+	so *options.SelectorOptions,
+	oo *options.OutputOptions,
+	out io.Writer,
+	bo *options.BuildOptions,
+	opts ...resolve.Option) error {
+	for _, f := range fo.Filenames {
+		if f == "-" {
+			return errors.New("--watch cannot be used to resolve stdin")
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	changed := make(chan string)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !strings.HasSuffix(event.Name, ".go") || event.Op == fsnotify.Chmod {
+					continue
+				}
+				select {
+				case changed <- event.Name:
+				case <-ctx.Done():
+					return
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("error watching files: %v", err)
+			}
+		}
+	}()
+	batches := debounce(ctx, changed, bo.WatchDebounce, time.After)
+
+	watched := map[string]bool{}
+	for first := true; ; first = false {
+		// The separator is a YAML comment, so there is nothing to write
+		// between successive JSON outputs.
+		if !first && documentSeparator(oo) != "" {
+			if _, err := io.WriteString(out, watchSeparator); err != nil {
+				return err
+			}
+		}
+
+		// Record the import paths that are built, so we know which sources
+		// to watch and which results to invalidate when they change.
+		recorder := &build.Recorder{Builder: builder}
+		if err := ResolveFilesToWriterWithOutput(ctx, recorder, publisher, fo, so, oo, nopWriteCloser{out}, opts...); err != nil {
+			// The next change may well fix this, so keep watching.
+			log.Printf("error resolving files: %v", err)
+		}
+
+		dirs, err := sourceDirs(bo.WorkingDirectory, recorder.ImportPaths)
+		if err != nil {
+			log.Printf("error finding source files to watch: %v", err)
+		}
+		for _, dir := range dirs {
+			if watched[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				return fmt.Errorf("watching %s: %w", dir, err)
+			}
+			watched[dir] = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case files, ok := <-batches:
+			if !ok {
+				return nil
+			}
+			log.Printf("%d source files changed, rebuilding", len(files))
+		}
+		for _, ip := range recorder.ImportPaths {
+			builder.Invalidate(ip)
+		}
+	}
+}
+
+// debounce batches the values received from in, and sends each batch once no
+// new value has been received for d, as measured by after, e.g. time.After.
+// The returned channel is closed once in is closed and any pending batch has
+// been sent, or when ctx is done.
+func debounce(ctx context.Context, in <-chan string, d time.Duration, after func(time.Duration) <-chan time.Time) <-chan []string {
+	out := make(chan []string)
+	go func() {
+		defer close(out)
+		var (
+			batch []string
+			timer <-chan time.Time
+			// send is only set to out once the batch is ready, since a nil
+			// channel is never available to send on.
+			send chan<- []string
+		)
+		for in != nil || len(batch) > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					// Flush what we have right away.
+					in, timer, send = nil, nil, out
+					continue
+				}
+				batch = append(batch, v)
+				timer, send = after(d), nil
+			case <-timer:
+				timer, send = nil, out
+			case send <- batch:
+				batch, send = nil, nil
+			}
+		}
+	}()
+	return out
+}
+
+// sourceDirs returns the directories holding the Go sources of packages in
+// the main module that the given import paths are built from.
+func sourceDirs(dir string, importPaths []string) ([]string, error) {
+	if len(importPaths) == 0 {
+		return nil, nil
+	}
+	patterns := make([]string, 0, len(importPaths))
+	for _, ip := range importPaths {
+		patterns = append(patterns, strings.TrimPrefix(ip, build.StrictScheme))
+	}
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedModule,
+		Dir:  dir,
+	}, patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		if pkg.Module == nil || !pkg.Module.Main {
+			return
+		}
+		for _, f := range pkg.GoFiles {
+			seen[filepath.Dir(f)] = true
+		}
+	})
+	dirs := make([]string, 0, len(seen))
+	for d := range seen {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// nopWriteCloser keeps ResolveFilesToWriter from closing the output between
+// resolutions.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,232 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+)
+
+// fakeClock stands in for time.After in tests of debounce, which decide when
+// the debounce duration has passed by sending on the timers it hands out.
+type fakeClock struct {
+	timers chan chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{timers: make(chan chan time.Time)}
+}
+
+func (c *fakeClock) after(time.Duration) <-chan time.Time {
+	timer := make(chan time.Time, 1)
+	c.timers <- timer
+	return timer
+}
+
+// send sends v to in and returns the timer that debounce started for it.
+func (c *fakeClock) send(in chan<- string, v string) chan time.Time {
+	in <- v
+	return <-c.timers
+}
+
+func TestDebounceBatchesRapidChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	in := make(chan string)
+	batches := debounce(ctx, in, time.Second, clock.after)
+	var timer chan time.Time
+	for _, f := range []string{"a.go", "b.go", "a.go"} {
+		timer = clock.send(in, f)
+	}
+	timer <- time.Time{}
+
+	if diff := cmp.Diff([]string{"a.go", "b.go", "a.go"}, <-batches); diff != "" {
+		t.Errorf("debounce() (-want +got) = %s", diff)
+	}
+}
+
+func TestDebounceSeparatesSettledChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	in := make(chan string)
+	batches := debounce(ctx, in, time.Second, clock.after)
+
+	clock.send(in, "a.go") <- time.Time{}
+	if diff := cmp.Diff([]string{"a.go"}, <-batches); diff != "" {
+		t.Errorf("debounce() (-want +got) = %s", diff)
+	}
+	clock.send(in, "b.go") <- time.Time{}
+	if diff := cmp.Diff([]string{"b.go"}, <-batches); diff != "" {
+		t.Errorf("debounce() (-want +got) = %s", diff)
+	}
+}
+
+func TestDebounceWaitsForQuiet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	in := make(chan string)
+	batches := debounce(ctx, in, time.Second, clock.after)
+
+	first := clock.send(in, "a.go")
+	second := clock.send(in, "b.go")
+	// The timer of a.go was superseded by that of b.go, so it firing
+	// doesn't send a batch without b.go.
+	first <- time.Time{}
+	second <- time.Time{}
+
+	if diff := cmp.Diff([]string{"a.go", "b.go"}, <-batches); diff != "" {
+		t.Errorf("debounce() (-want +got) = %s", diff)
+	}
+}
+
+func TestDebounceFlushesOnClose(t *testing.T) {
+	clock := newFakeClock()
+	in := make(chan string)
+	batches := debounce(context.Background(), in, time.Second, clock.after)
+
+	// The timer never fires, so the pending batch is sent because in was
+	// closed, not because the changes settled.
+	clock.send(in, "a.go")
+	close(in)
+
+	if diff := cmp.Diff([]string{"a.go"}, <-batches); diff != "" {
+		t.Errorf("debounce() (-want +got) = %s", diff)
+	}
+	if batch, ok := <-batches; ok {
+		t.Errorf("debounce() sent %v after in was closed, wanted the channel to be closed", batch)
+	}
+}
+
+func TestDebounceStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	batches := debounce(ctx, make(chan string), time.Second, newFakeClock().after)
+	cancel()
+
+	if batch, ok := <-batches; ok {
+		t.Errorf("debounce() sent %v without any changes", batch)
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	// The module has no dependencies to vendor.
+	t.Setenv("GOFLAGS", "-mod=mod")
+	dir := t.TempDir()
+	mainGo := filepath.Join(dir, "cmd", "app", "main.go")
+	if err := os.MkdirAll(filepath.Dir(mainGo), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{
+		filepath.Join(dir, "go.mod"): "module example.com/watched\n\ngo 1.21\n",
+		mainGo:                       "package main\n\nfunc main() {}\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const ref = "example.com/watched/cmd/app"
+	base := mustRepository("gcr.io/watched")
+	builder, err := build.NewCaching(kotesting.NewFixedBuild(map[string]build.Result{ref: foo}))
+	if err != nil {
+		t.Fatalf("NewCaching() = %v", err)
+	}
+	publisher := kotesting.NewFixedPublish(base, map[string]v1.Hash{ref: fooHash})
+	fo := &options.FilenameOptions{Filenames: []string{yamlToTmpFile(t, []byte("image: "+build.StrictScheme+ref+"\n"))}}
+	bo := &options.BuildOptions{WorkingDirectory: dir, WatchDebounce: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, w := io.Pipe()
+	defer r.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- watchFiles(ctx, builder, publisher, fo, &options.SelectorOptions{}, &options.OutputOptions{}, w, bo)
+		w.Close()
+	}()
+
+	lines := bufio.NewScanner(r)
+	// readUntil reads lines of the output until one is want.
+	readUntil := func(want string) {
+		t.Helper()
+		for lines.Scan() {
+			if lines.Text() == want {
+				return
+			}
+		}
+		t.Fatalf("watchFiles() output ended before %q: %v", want, lines.Err())
+	}
+	resolved := "image: " + kotesting.ComputeDigest(base, ref, fooHash)
+	readUntil(resolved)
+
+	// The source directory is only watched once the first output is
+	// written, so keep changing the source until it is resolved again.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			content := fmt.Sprintf("package main\n\nfunc main() {}\n\n// change %d\n", i)
+			if err := os.WriteFile(mainGo, []byte(content), 0o644); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	readUntil(strings.TrimSuffix(watchSeparator, "\n"))
+	readUntil(resolved)
+
+	cancel()
+	go io.Copy(io.Discard, r) //nolint:errcheck
+	if err := <-done; err != nil {
+		t.Errorf("watchFiles() = %v", err)
+	}
+}
+
+func TestSourceDirs(t *testing.T) {
+	dirs, err := sourceDirs("../..", []string{build.StrictScheme + "github.com/google/ko/test"})
+	if err != nil {
+		t.Fatalf("sourceDirs() = %v", err)
+	}
+	want, err := filepath.Abs("../../test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{want}, dirs); diff != "" {
+		t.Errorf("sourceDirs() (-want +got) = %s", diff)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/build"
+)
+
+// Hook is called with the reference an image was published to.
+type Hook func(ctx context.Context, ref name.Reference) error
+
+// hooked wraps a publisher implementation in a layer that runs hooks after
+// each image is published.
+type hooked struct {
+	inner Interface
+	hooks []Hook
+}
+
+// hooked implements Interface
+var _ Interface = (*hooked)(nil)
+
+// NewHooked wraps the provided publish.Interface in an implementation that
+// calls the hooks in order after each image is published, before Publish
+// returns. If a hook fails, so does Publish.
+func NewHooked(inner Interface, hooks ...Hook) Interface {
+	return &hooked{
+		inner: inner,
+		hooks: hooks,
+	}
+}
+
+// Publish implements Interface
+func (h *hooked) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	ref, err := h.inner.Publish(ctx, br, s)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range h.hooks {
+		if err := hook(ctx, ref); err != nil {
+			return nil, fmt.Errorf("running hook for %s: %w", ref, err)
+		}
+	}
+	return ref, nil
+}
+
+// Close implements Interface
+func (h *hooked) Close() error {
+	return h.inner.Close()
+}
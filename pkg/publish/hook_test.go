@@ -0,0 +1,84 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+)
+
+func TestHooked(t *testing.T) {
+	repo := name.MustParseReference("docker.io/ubuntu:latest")
+	inner := &cbPublish{cb: func(c context.Context, b build.Result, s string) (name.Reference, error) {
+		h, err := b.Digest()
+		if err != nil {
+			return nil, err
+		}
+		return repo.Context().Digest(h.String()), nil
+	}}
+
+	var calls []string
+	hook := func(prefix string) Hook {
+		return func(_ context.Context, ref name.Reference) error {
+			calls = append(calls, prefix+ref.String())
+			return nil
+		}
+	}
+	pub := NewHooked(inner, hook("first "), hook("second "))
+
+	img, err := random.Image(3, 3)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	ref, err := pub.Publish(context.Background(), img, "ko://github.com/foo/bar")
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+
+	want := []string{"first " + ref.String(), "second " + ref.String()}
+	if diff := cmp.Diff(want, calls); diff != "" {
+		t.Errorf("hook calls (-want +got) = %s", diff)
+	}
+}
+
+func TestHookedError(t *testing.T) {
+	repo := name.MustParseReference("docker.io/ubuntu:latest")
+	inner := &cbPublish{cb: func(context.Context, build.Result, string) (name.Reference, error) {
+		return repo, nil
+	}}
+	errHook := errors.New("hook failed")
+	var called bool
+	pub := NewHooked(inner,
+		func(context.Context, name.Reference) error { return errHook },
+		func(context.Context, name.Reference) error { called = true; return nil },
+	)
+
+	img, err := random.Image(3, 3)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	if _, err := pub.Publish(context.Background(), img, "ko://github.com/foo/bar"); !errors.Is(err, errHook) {
+		t.Errorf("Publish() = %v, want %v", err, errHook)
+	}
+	if called {
+		t.Error("hooks after a failing hook should not be called")
+	}
+}
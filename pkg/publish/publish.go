@@ -32,3 +32,11 @@ type Interface interface {
 	// do the whole thing in one write.
 	Close() error
 }
+
+// Sizer is optionally implemented by publishers that know the size of the
+// images they publish.
+type Sizer interface {
+	// ImageSize returns the total compressed size in bytes of the layers of
+	// the image published for the reference passed to Publish.
+	ImageSize(ref string) (int64, error)
+}
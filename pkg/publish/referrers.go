@@ -0,0 +1,143 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/ko/pkg/build"
+)
+
+// SBOMFunc generates the SBOM of img, which was built for the import path
+// s, and returns it along with its media type.
+type SBOMFunc func(ctx context.Context, s string, img v1.Image) ([]byte, types.MediaType, error)
+
+// sbomReferrers wraps a publisher implementation in a layer that attaches
+// SBOMs to the published images as OCI referrers.
+type sbomReferrers struct {
+	inner Interface
+	sbom  SBOMFunc
+	ropt  []remote.Option
+}
+
+// sbomReferrers implements Interface
+var _ Interface = (*sbomReferrers)(nil)
+
+// NewSBOMReferrers wraps the provided publish.Interface in an implementation
+// that, after each image is published, generates its SBOM with sbom and
+// pushes it to the same repository as an artifact whose subject is the
+// image. Each image of a multi-platform image gets its own SBOM. The
+// artifact type of the SBOM is its media type.
+func NewSBOMReferrers(inner Interface, sbom SBOMFunc, opts ...remote.Option) Interface {
+	return &sbomReferrers{
+		inner: inner,
+		sbom:  sbom,
+		ropt:  opts,
+	}
+}
+
+// Publish implements Interface
+func (r *sbomReferrers) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	ref, err := r.inner.Publish(ctx, br, s)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.attachAll(ctx, ref.Context(), br, s); err != nil {
+		return nil, fmt.Errorf("attaching SBOM to %s: %w", ref, err)
+	}
+	return ref, nil
+}
+
+// attachAll attaches an SBOM to br, or to each of its images if it is an
+// image index.
+func (r *sbomReferrers) attachAll(ctx context.Context, repo name.Repository, br build.Result, s string) error {
+	switch br := br.(type) {
+	case v1.Image:
+		return r.attach(ctx, repo, br, s)
+
+	case v1.ImageIndex:
+		im, err := br.IndexManifest()
+		if err != nil {
+			return err
+		}
+		for _, desc := range im.Manifests {
+			if !desc.MediaType.IsImage() {
+				continue
+			}
+			img, err := br.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := r.attach(ctx, repo, img, s); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported build result type %T", br)
+	}
+}
+
+// attach pushes the SBOM of img to repo, with img as its subject.
+func (r *sbomReferrers) attach(ctx context.Context, repo name.Repository, img v1.Image, s string) error {
+	b, mt, err := r.sbom(ctx, s, img)
+	if err != nil {
+		return fmt.Errorf("generating SBOM: %w", err)
+	}
+	subject, err := partial.Descriptor(img)
+	if err != nil {
+		return err
+	}
+
+	// An image manifest has no artifactType, so the config media type
+	// serves as the artifact type.
+	artifact := mutate.ConfigMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), mt)
+	artifact, err = mutate.Append(artifact, mutate.Addendum{Layer: static.NewLayer(b, mt)})
+	if err != nil {
+		return err
+	}
+	artifact = mutate.Subject(artifact, v1.Descriptor{
+		MediaType: subject.MediaType,
+		Digest:    subject.Digest,
+		Size:      subject.Size,
+	}).(v1.Image)
+
+	h, err := artifact.Digest()
+	if err != nil {
+		return err
+	}
+	dst := repo.Digest(h.String())
+	if err := remote.Write(dst, artifact, append(r.ropt, remote.WithContext(ctx))...); err != nil {
+		return fmt.Errorf("writing SBOM: %w", err)
+	}
+	log.Printf("Published SBOM %v for %s", dst, subject.Digest)
+	return nil
+}
+
+// Close implements Interface
+func (r *sbomReferrers) Close() error {
+	return r.inner.Close()
+}
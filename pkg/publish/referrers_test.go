@@ -0,0 +1,160 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/ko/pkg/build"
+)
+
+const testSBOMMediaType types.MediaType = "application/spdx+json"
+
+func TestSBOMReferrers(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := name.NewRepository(fmt.Sprintf("%s/sbom", u.Host))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// inner pushes the build results, like the default publisher does.
+	inner := &cbPublish{cb: func(_ context.Context, br build.Result, _ string) (name.Reference, error) {
+		h, err := br.Digest()
+		if err != nil {
+			return nil, err
+		}
+		ref := repo.Digest(h.String())
+		switch br := br.(type) {
+		case v1.ImageIndex:
+			return ref, remote.WriteIndex(ref, br)
+		case v1.Image:
+			return ref, remote.Write(ref, br)
+		}
+		return nil, fmt.Errorf("unexpected build result %T", br)
+	}}
+	sbom := func(_ context.Context, s string, img v1.Image) ([]byte, types.MediaType, error) {
+		h, err := img.Digest()
+		if err != nil {
+			return nil, "", err
+		}
+		return []byte(s + " " + h.String()), testSBOMMediaType, nil
+	}
+	pub := NewSBOMReferrers(inner, sbom)
+
+	for _, br := range []build.Result{img, idx} {
+		if _, err := pub.Publish(context.Background(), br, "ko://example.com/app"); err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+	}
+
+	// Every image gets an SBOM, including those within the index.
+	var images []v1.Hash
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	images = append(images, h)
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, desc := range im.Manifests {
+		images = append(images, desc.Digest)
+	}
+
+	for _, h := range images {
+		referrers, err := remote.Referrers(repo.Digest(h.String()))
+		if err != nil {
+			t.Fatalf("Referrers(%s) = %v", h, err)
+		}
+		rm, err := referrers.IndexManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rm.Manifests) != 1 {
+			t.Fatalf("got %d referrers of %s, want 1", len(rm.Manifests), h)
+		}
+		if got := rm.Manifests[0].ArtifactType; got != string(testSBOMMediaType) {
+			t.Errorf("ArtifactType = %q, want %q", got, testSBOMMediaType)
+		}
+
+		artifact, err := remote.Image(repo.Digest(rm.Manifests[0].Digest.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers, err := artifact.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(layers) != 1 {
+			t.Fatalf("got %d layers, want 1", len(layers))
+		}
+		rc, err := layers[0].Uncompressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "ko://example.com/app " + h.String(); string(got) != want {
+			t.Errorf("SBOM = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSBOMReferrersError(t *testing.T) {
+	repo := name.MustParseReference("gcr.io/foo/bar")
+	inner := &cbPublish{cb: func(_ context.Context, br build.Result, _ string) (name.Reference, error) {
+		return repo, nil
+	}}
+	sbom := func(context.Context, string, v1.Image) ([]byte, types.MediaType, error) {
+		return nil, "", errors.New("no module graph")
+	}
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSBOMReferrers(inner, sbom).Publish(context.Background(), img, "ko://example.com/app"); err == nil {
+		t.Error("Publish() = nil, want error")
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNoDocuments is returned by DocFromBytes and DocsFromBytes when their
+// input holds no YAML documents, e.g. because it is empty.
+var ErrNoDocuments = errors.New("no YAML documents")
+
+// DocsFromBytes decodes every YAML document within data, which are separated
+// by `---`, into nodes that can be passed to ImageReferences.
+func DocsFromBytes(data []byte) ([]*yaml.Node, error) {
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("decoding YAML document %d: %w", len(docs), err)
+		}
+		docs = append(docs, &doc)
+	}
+	if len(docs) == 0 {
+		return nil, ErrNoDocuments
+	}
+	return docs, nil
+}
+
+// DocFromBytes is like DocsFromBytes, but for data holding a single YAML
+// document.
+func DocFromBytes(data []byte) (*yaml.Node, error) {
+	docs, err := DocsFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) != 1 {
+		return nil, fmt.Errorf("expected a single YAML document, found %d", len(docs))
+	}
+	return docs[0], nil
+}
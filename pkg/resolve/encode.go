@@ -0,0 +1,46 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DocsToBytes encodes docs, e.g. as decoded by DocsFromBytes and resolved by
+// ImageReferences, back into YAML documents separated by `---`. Comments and
+// the styles of nodes, like quoted strings or flow sequences, are preserved,
+// and mappings and sequences are indented by two spaces.
+func DocsToBytes(docs []*yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	for i, doc := range docs {
+		if err := encoder.Encode(doc); err != nil {
+			return nil, fmt.Errorf("encoding YAML document %d: %w", i, err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DocToBytes is like DocsToBytes, but for a single YAML document.
+func DocToBytes(doc *yaml.Node) ([]byte, error) {
+	return DocsToBytes([]*yaml.Node{doc})
+}
@@ -0,0 +1,115 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dprotaso/go-yit"
+	"github.com/google/ko/pkg/build"
+	"gopkg.in/yaml.v3"
+)
+
+// jsonNode is a yaml.Node holding a JSON document with supported references,
+// along with the decoded document.
+type jsonNode struct {
+	node  *yaml.Node
+	value any
+}
+
+// jsonStringsFromDoc returns an iterator over the string nodes of doc that
+// look like a JSON object or array containing a supported reference.
+func jsonStringsFromDoc(doc *yaml.Node) yit.Iterator {
+	return yit.FromNode(doc).
+		RecurseNodes().
+		Filter(yit.StringValue).
+		Filter(func(node *yaml.Node) bool {
+			value := strings.TrimSpace(node.Value)
+			return (strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[")) &&
+				strings.Contains(value, build.StrictScheme)
+		})
+}
+
+// decodeJSON decodes s, which must hold exactly one JSON value, into v.
+// Numbers are kept as json.Number so that they are re-encoded unchanged.
+func decodeJSON(s string, v any) error {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
+
+// encodeJSON encodes v, following the layout of the original document: it is
+// indented if the original spanned multiple lines, and ends with a newline if
+// the original did.
+func encodeJSON(v any, original string) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if strings.Contains(strings.TrimSpace(original), "\n") {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		return "", fmt.Errorf("encoding JSON: %w", err)
+	}
+	out := buf.String()
+	if !strings.HasSuffix(original, "\n") {
+		out = strings.TrimSuffix(out, "\n")
+	}
+	return out, nil
+}
+
+// walkJSONRefs calls f for every string in the decoded JSON value v that is a
+// supported reference, and returns a copy of v with those strings replaced by
+// the results.
+func walkJSONRefs(v any, f func(string) (string, error)) (any, error) {
+	switch v := v.(type) {
+	case string:
+		if !strings.HasPrefix(strings.TrimSpace(v), build.StrictScheme) {
+			return v, nil
+		}
+		return f(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			var err error
+			if out[i], err = walkJSONRefs(e, f); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			var err error
+			if out[k], err = walkJSONRefs(e, f); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// manifestRecord is a line of the build manifest.
+type manifestRecord struct {
+	// Ref is the supported reference, e.g. ko://github.com/foo/bar.
+	Ref string `json:"ref"`
+	// Digest is the digest of the image or image index built for Ref.
+	Digest string `json:"digest"`
+	// Timestamp is when Ref was resolved, in RFC 3339 format.
+	Timestamp string `json:"timestamp"`
+}
+
+// appendBuildManifest appends a record for each reference in sm, which maps
+// references to their published results, to the file at path.
+func appendBuildManifest(path string, sm *sync.Map, now time.Time) error {
+	var records []manifestRecord
+	var err error
+	sm.Range(func(ref, pub any) bool {
+		h, derr := pub.(published).build.Digest()
+		if derr != nil {
+			err = derr
+			return false
+		}
+		records = append(records, manifestRecord{
+			Ref:       ref.(string),
+			Digest:    h.String(),
+			Timestamp: now.UTC().Format(time.RFC3339),
+		})
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Ref < records[j].Ref })
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	// Write every record at once, so that concurrent invocations don't
+	// interleave their records.
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
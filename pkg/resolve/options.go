@@ -0,0 +1,219 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Stats reports what ImageReferences did.
+type Stats struct {
+	// NodesUpdated is the number of references that were replaced with
+	// their published image reference, counting each occurrence.
+	NodesUpdated int
+	// Builds holds one BuildStat per unique reference that was built and
+	// published, sorted by reference within each call.
+	Builds []BuildStat
+	// TotalDuration is how long ImageReferences took, from parsing the
+	// options to updating the last reference.
+	TotalDuration time.Duration
+}
+
+// BuildStat reports how building and publishing a reference went.
+type BuildStat struct {
+	// Ref is the reference, e.g. "ko://github.com/foo/bar".
+	Ref string
+	// BuildDuration is how long the builder took to build the reference.
+	BuildDuration time.Duration
+	// PushDuration is how long the publisher took to publish the result.
+	PushDuration time.Duration
+	// CompressedBytes is the total compressed size of the layers of the
+	// built image, or of all images of an image index. Layers that the
+	// publisher found to exist already count as well, so this is an upper
+	// bound of the bytes transferred.
+	CompressedBytes int64
+}
+
+// Option is a functional option for ImageReferences.
+type Option func(*resolveOptions) error
+
+type resolveOptions struct {
+	concurrencyLimit    int
+	logger              *slog.Logger
+	jsonStringExpansion bool
+	substringMatching   bool
+	dryRun              bool
+	fastPathSkip        bool
+	helmPassthrough     bool
+	workerPool          bool
+	stats               *Stats
+	onResolved          func(ref, digest string)
+	buildManifest       string
+}
+
+func makeOptions(opts ...Option) (*resolveOptions, error) {
+	ro := &resolveOptions{
+		// Library users should not get unexpected output by default.
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	for _, opt := range opts {
+		if err := opt(ro); err != nil {
+			return nil, err
+		}
+	}
+	return ro, nil
+}
+
+// WithConcurrencyLimit is a functional option for limiting the number of
+// references that are built and published concurrently. Zero means no limit.
+func WithConcurrencyLimit(n int) Option {
+	return func(ro *resolveOptions) error {
+		ro.concurrencyLimit = n
+		return nil
+	}
+}
+
+// withWorkerPool is a functional option for building and publishing the
+// references with a fixed pool of workers, see ImageReferencesParallel.
+func withWorkerPool() Option {
+	return func(ro *resolveOptions) error {
+		ro.workerPool = true
+		return nil
+	}
+}
+
+// WithLogger is a functional option for logging details of the resolution,
+// like the references that are found and what they resolve to. By default,
+// or if l is nil, nothing is logged.
+func WithLogger(l *slog.Logger) Option {
+	return func(ro *resolveOptions) error {
+		if l == nil {
+			l = slog.New(slog.NewTextHandler(io.Discard, nil))
+		}
+		ro.logger = l
+		return nil
+	}
+}
+
+// loggerKey is the context key of the logger set with NewContext.
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx that carries l, for
+// ImageReferencesWithContext to log with.
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// loggerFromContext returns the logger carried by ctx, or slog.Default() if
+// there is none.
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok && l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// WithJSONStringExpansion is a functional option for also resolving supported
+// references within string values that hold a JSON document, like a
+// ConfigMap's `config.json` entry. Such values are re-encoded after their
+// references are resolved, which sorts the keys of JSON objects.
+func WithJSONStringExpansion() Option {
+	return func(ro *resolveOptions) error {
+		ro.jsonStringExpansion = true
+		return nil
+	}
+}
+
+// WithSubstringMatching is a functional option for also resolving supported
+// references embedded within larger strings using the `$(ko://...)` syntax,
+// e.g. `--image=$(ko://github.com/foo/bar)`. The whole `$(...)` is replaced.
+func WithSubstringMatching() Option {
+	return func(ro *resolveOptions) error {
+		ro.substringMatching = true
+		return nil
+	}
+}
+
+// WithDryRun is a functional option for only checking that every reference
+// is supported by the builder. Nothing is built or published, and the input
+// yaml is left unchanged.
+func WithDryRun() Option {
+	return func(ro *resolveOptions) error {
+		ro.dryRun = true
+		return nil
+	}
+}
+
+// WithFastPathSkip is a functional option for skipping documents none of
+// whose values contain the "ko://" scheme with a cheap substring check, instead
+// of walking them looking for each supported kind of reference. This pays
+// off when most documents hold no references, and is wasted work otherwise.
+func WithFastPathSkip() Option {
+	return func(ro *resolveOptions) error {
+		ro.fastPathSkip = true
+		return nil
+	}
+}
+
+// WithHelmPassthrough is a functional option for skipping string values that
+// contain a template expression, i.e. `{{`, as found in Helm charts, e.g.
+// `{{ include "image" (dict "ref" "ko://github.com/foo/bar") }}`. Templates
+// are not evaluated, so any reference within such a value is left unresolved
+// instead of being reported as malformed.
+func WithHelmPassthrough() Option {
+	return func(ro *resolveOptions) error {
+		ro.helmPassthrough = true
+		return nil
+	}
+}
+
+// WithStats is a functional option for reporting statistics about the
+// resolution into s. Counts and durations are added to those already in s, and
+// build statistics appended, so the same
+// Stats can be passed to several calls, e.g. by StreamingImageReferences.
+func WithStats(s *Stats) Option {
+	return func(ro *resolveOptions) error {
+		ro.stats = s
+		return nil
+	}
+}
+
+// WithOnResolved is a functional option for calling f after each reference
+// has been built and published, e.g. to record where its image went. f is
+// called once per unique reference, with the reference, e.g.
+// "ko://github.com/foo/bar" without any query, and the image reference it
+// was published as, typically by digest.
+// Calls are made from the goroutines doing the builds, so f must be safe for
+// concurrent use. A panic in f fails the resolution.
+func WithOnResolved(f func(ref, digest string)) Option {
+	return func(ro *resolveOptions) error {
+		ro.onResolved = f
+		return nil
+	}
+}
+
+// WithBuildManifest is a functional option for appending a JSON record of
+// each image that is resolved to the file at path, one per line, with the
+// reference, the digest of the image and when it was resolved. Failing to
+// write the file is logged, and doesn't fail the resolution.
+func WithBuildManifest(path string) Option {
+	return func(ro *resolveOptions) error {
+		ro.buildManifest = path
+		return nil
+	}
+}
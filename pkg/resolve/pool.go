@@ -0,0 +1,74 @@
+// Copyright 2026 ko Build Authors All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"context"
+	"sort"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// resolveWithWorkers calls resolve for each of refs from a pool of n workers,
+// and returns the first error. Once a call fails, the context passed to the
+// others is canceled and no more references are handed out. If ctx is
+// canceled before every reference is handed out, its error is returned.
+func resolveWithWorkers(ctx context.Context, refs []string, n int, resolve func(context.Context, string) error) error {
+	errg, gctx := errgroup.WithContext(ctx)
+	work := make(chan string)
+	handedOut := 0
+	errg.Go(func() error {
+		defer close(work)
+		for _, ref := range refs {
+			select {
+			case work <- ref:
+				handedOut++
+			case <-gctx.Done():
+				// A failing call has already reported why, so returning
+				// gctx.Err() here could only hide its error.
+				return nil
+			}
+		}
+		return nil
+	})
+	for i := 0; i < n; i++ {
+		errg.Go(func() error {
+			for ref := range work {
+				if err := resolve(gctx, ref); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err := errg.Wait(); err != nil {
+		return err
+	}
+	if handedOut < len(refs) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// sortedRefs returns the references of refs in order, so that they are handed
+// to the workers deterministically.
+func sortedRefs(refs map[string][]refNode) []string {
+	keys := make([]string, 0, len(refs))
+	for k := range refs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -16,81 +16,1172 @@ package resolve
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 
 	"github.com/dprotaso/go-yit"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/publish"
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 )
 
+// refNode is a yaml.Node holding a supported reference, along with the part
+// of the published image reference it should be replaced with.
+type refNode struct {
+	node  *yaml.Node
+	query refQuery
+}
+
+// refQuery is the query of a supported reference, e.g. ?part=digest.
+type refQuery struct {
+	// part is the part of the published image reference requested, which is
+	// empty when the full reference is requested.
+	part string
+	// platform is the platform requested with part=platformDigest.
+	platform *v1.Platform
+}
+
 // ImageReferences resolves supported references to images within the input yaml
 // to published image digests.
 //
-// If a reference can be built and pushed, its yaml.Node will be mutated.
-func ImageReferences(ctx context.Context, docs []*yaml.Node, builder build.Interface, publisher publish.Interface) error {
+// A reference may select a part of the published image reference with the
+// `part` query parameter, e.g. ko://github.com/foo/bar?part=digest. The
+// following parts are supported:
+//   - digest: only the digest, e.g. sha256:deadbeef...
+//   - digestAlgorithm: only the algorithm of the digest, e.g. sha256
+//   - digestHex: only the hex encoded digest, e.g. deadbeef...
+//   - shortDigest: the first 12 characters of the hex encoded digest, e.g. deadbeefdead
+//   - repository: the reference without tag or digest, e.g. gcr.io/foo/bar
+//   - registryHostname: only the hostname of the registry, without port,
+//     e.g. gcr.io, or localhost for localhost:5000/foo/bar
+//   - fullDigest: the reference without tag, e.g. gcr.io/foo/bar@sha256:deadbeef...
+//   - tag: only the tag, or "latest" if there is none, e.g. v1.2
+//   - imageID: the digest of the image's config blob, e.g. sha256:c0ffee...
+//     This is not supported for multi-platform images.
+//   - labels: the labels of the image's config as comma separated key=value
+//     pairs sorted by key, e.g. org.opencontainers.image.version=v1.2,team=foo.
+//     For multi-platform images, only labels common to all images are included.
+//   - created: the creation time of the image's config in RFC 3339 format,
+//     e.g. 2026-03-04T05:06:07Z, or 0001-01-01T00:00:00Z if it isn't recorded.
+//   - size: the compressed size in bytes of the image's layers, e.g. 3145728.
+//     Unless the publisher implements publish.Sizer, this is not supported
+//     for multi-platform images.
+//   - platformDigest: the digest of the image for the platform given with
+//     the `platform` query parameter, e.g.
+//     ko://github.com/foo/bar?part=platformDigest&platform=linux/arm64.
+//     For multi-platform images, this is the digest of the platform's
+//     manifest within the index.
+//   - manifestMediaType: the media type of the published manifest, e.g.
+//     application/vnd.oci.image.manifest.v1+json, or of the index for
+//     multi-platform images.
+//   - indexDigest: the digest of the image index for multi-platform images,
+//     or of the image's manifest otherwise, e.g. sha256:deadbeef... This is
+//     taken from the built image, so unlike digest it doesn't need the
+//     published reference to contain a digest.
+//   - none: the reference is left unchanged, e.g. to only push the image as
+//     a side effect of resolving.
+//
+// With WithSubstringMatching, references may also be embedded within a larger
+// string as $(ko://github.com/foo/bar), e.g. --image=$(ko://github.com/foo/bar).
+//
+// With WithHelmPassthrough, values holding a template expression like
+// {{ .Values.image }} are left alone.
+//
+// Each of docs is normally a document node, as decoded by DocsFromBytes. Any
+// other node, like a mapping or sequence node, is treated as the content of a
+// document.
+//
+// If a reference can be built and pushed, its yaml.Node will be mutated. Once
+// a reference fails, the builds and pushes of the others are cancelled, and
+// context.Cause of their context returns the error of the failed reference.
+func ImageReferences(ctx context.Context, docs []*yaml.Node, builder build.Interface, publisher publish.Interface, opts ...Option) error {
+	ro, err := makeOptions(opts...)
+	if err != nil {
+		return err
+	}
+	if ro.stats != nil {
+		start := time.Now()
+		defer func() {
+			ro.stats.TotalDuration += time.Since(start)
+		}()
+	}
+	docs = asDocuments(docs)
+
 	// First, walk the input objects and collect a list of supported references
-	refs := make(map[string][]*yaml.Node)
+	found, err := collectRefs(ctx, docs, ro, builder.IsSupportedReference)
+	if err != nil {
+		return err
+	}
+	refs := found.refs
+
+	if ro.dryRun {
+		// Every reference is supported, which is all a dry run checks.
+		return nil
+	}
+
+	// Next, perform parallel builds for each of the supported references.
+	var sm sync.Map
+	var statsMu sync.Mutex
+	var buildStats []BuildStat
+	resolveRef := func(ctx context.Context, ref string) error {
+		buildStart := time.Now()
+		img, err := builder.Build(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("building %s: %w", ref, err)
+		}
+		pushStart := time.Now()
+		digest, err := publisher.Publish(ctx, img, ref)
+		if err != nil {
+			return fmt.Errorf("publishing %s: %w", ref, err)
+		}
+		pushEnd := time.Now()
+		ro.logger.DebugContext(ctx, "resolved reference", "ref", ref, "image", digest.String())
+		sizer, _ := publisher.(publish.Sizer)
+		sm.Store(ref, published{image: digest, build: img, ref: ref, sizer: sizer})
+		if ro.stats != nil {
+			// Statistics are a side channel, so failing to compute the
+			// size doesn't fail the resolution.
+			size, err := compressedSize(img)
+			if err != nil {
+				ro.logger.WarnContext(ctx, "failed to compute image size", "ref", ref, "error", err)
+			}
+			statsMu.Lock()
+			buildStats = append(buildStats, BuildStat{
+				Ref:             ref,
+				BuildDuration:   pushStart.Sub(buildStart),
+				PushDuration:    pushEnd.Sub(pushStart),
+				CompressedBytes: size,
+			})
+			statsMu.Unlock()
+		}
+		if ro.onResolved != nil {
+			return callOnResolved(ro.onResolved, ref, digest.String())
+		}
+		return nil
+	}
+
+	// The first failure cancels the other builds, with the failure as the
+	// cause, so that they can tell which reference they were cancelled for.
+	buildCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	resolveOrCancel := func(ctx context.Context, ref string) error {
+		err := resolveRef(ctx, ref)
+		if err != nil {
+			cancel(err)
+		}
+		return err
+	}
+	if ro.workerPool {
+		workers := ro.concurrencyLimit
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		if err := resolveWithWorkers(buildCtx, sortedRefs(refs), workers, resolveOrCancel); err != nil {
+			return err
+		}
+	} else {
+		var errg errgroup.Group
+		if ro.concurrencyLimit > 0 {
+			errg.SetLimit(ro.concurrencyLimit)
+		}
+		for ref := range refs {
+			ref := ref
+			errg.Go(func() error {
+				return resolveOrCancel(buildCtx, ref)
+			})
+		}
+		if err := errg.Wait(); err != nil {
+			return err
+		}
+	}
+	if ro.stats != nil {
+		sort.Slice(buildStats, func(i, j int) bool {
+			return buildStats[i].Ref < buildStats[j].Ref
+		})
+		ro.stats.Builds = append(ro.stats.Builds, buildStats...)
+	}
+
+	if ro.buildManifest != "" {
+		// Like statistics, the build manifest is a side channel, so failing
+		// to write it doesn't fail the resolution.
+		if err := appendBuildManifest(ro.buildManifest, &sm, time.Now()); err != nil {
+			ro.logger.WarnContext(ctx, "failed to write build manifest", "path", ro.buildManifest, "error", err)
+		}
+	}
+
+	// Walk the references and update them with the published images.
+	updated, err := found.replace(func(ref string, q refQuery) (string, error) {
+		pub, ok := sm.Load(ref)
+		if !ok {
+			return "", fmt.Errorf("resolved reference to %q not found", ref)
+		}
+		return pub.(published).part(q)
+	})
+	if err != nil {
+		return err
+	}
+
+	if ro.stats != nil {
+		ro.stats.NodesUpdated += updated
+	}
+	return nil
+}
+
+// foundRefs are the supported references found within some documents, by the
+// kind of node that holds them.
+type foundRefs struct {
+	// refs maps each reference to the nodes whose whole value it is, which
+	// is empty for references only found within other values.
+	refs           map[string][]refNode
+	jsonNodes      []*jsonNode
+	substringNodes []*yaml.Node
+}
+
+// collectRefs walks docs and collects the supported references within them,
+// according to ro. If supported is not nil, it must accept each reference.
+func collectRefs(ctx context.Context, docs []*yaml.Node, ro *resolveOptions, supported func(ref string) error) (*foundRefs, error) {
+	refs := make(map[string][]refNode)
+	var jsonNodes []*jsonNode
+	var substringNodes []*yaml.Node
+
+	supportedRef := func(value string) (string, refQuery, error) {
+		ref, q, err := parseRef(value)
+		if err != nil {
+			return "", refQuery{}, err
+		}
+
+		if supported != nil {
+			if err := supported(ref); err != nil {
+				return "", refQuery{}, fmt.Errorf("found strict reference but %s is not a valid import path: %w", ref, err)
+			}
+		}
+
+		ro.logger.DebugContext(ctx, "found reference", "ref", ref, "part", q.part)
+		return ref, q, nil
+	}
+
+	if ro.fastPathSkip {
+		docs = docsWithScheme(docs)
+	}
 
 	for _, doc := range docs {
-		it := refsFromDoc(doc)
+		expandAliases(doc)
+		it := refNodesFromDoc(doc)
 
 		for node, ok := it(); ok; node, ok = it() {
-			ref := strings.TrimSpace(node.Value)
+			if ro.helmPassthrough && isTemplate(node.Value) {
+				continue
+			}
+			ref, q, err := supportedRef(node.Value)
+			if err != nil {
+				return nil, err
+			}
+			refs[ref] = append(refs[ref], refNode{node: node, query: q})
+		}
 
-			if err := builder.IsSupportedReference(ref); err != nil {
-				return fmt.Errorf("found strict reference but %s is not a valid import path: %w", ref, err)
+		if !ro.jsonStringExpansion {
+			continue
+		}
+		it = jsonStringsFromDoc(doc)
+		for node, ok := it(); ok; node, ok = it() {
+			if ro.helmPassthrough && isTemplate(node.Value) {
+				continue
+			}
+			var value any
+			if err := decodeJSON(node.Value, &value); err != nil {
+				// Not a JSON document after all, so leave it alone.
+				continue
 			}
+			if _, err := walkJSONRefs(value, func(s string) (string, error) {
+				ref, _, err := supportedRef(s)
+				if err != nil {
+					return "", err
+				}
+				if _, ok := refs[ref]; !ok {
+					// Make sure the reference gets built, even if it
+					// only occurs within JSON values.
+					refs[ref] = nil
+				}
+				return s, nil
+			}); err != nil {
+				return nil, err
+			}
+			jsonNodes = append(jsonNodes, &jsonNode{node: node, value: value})
+		}
+	}
 
-			refs[ref] = append(refs[ref], node)
+	if ro.substringMatching {
+		for _, doc := range docs {
+			it := substringsFromDoc(doc)
+			for node, ok := it(); ok; node, ok = it() {
+				if ro.helmPassthrough && isTemplate(node.Value) {
+					continue
+				}
+				if _, err := replaceSubstringRefs(node.Value, func(s string) (string, error) {
+					ref, _, err := supportedRef(s)
+					if err != nil {
+						return "", err
+					}
+					if _, ok := refs[ref]; !ok {
+						// Make sure the reference gets built, even if it
+						// only occurs within larger strings.
+						refs[ref] = nil
+					}
+					return s, nil
+				}); err != nil {
+					return nil, err
+				}
+				substringNodes = append(substringNodes, node)
+			}
 		}
 	}
+	return &foundRefs{refs: refs, jsonNodes: jsonNodes, substringNodes: substringNodes}, nil
+}
 
-	// Next, perform parallel builds for each of the supported references.
-	var sm sync.Map
-	var errg errgroup.Group
-	for ref := range refs {
-		ref := ref
-		errg.Go(func() error {
-			img, err := builder.Build(ctx, ref)
+// replace replaces each of the references in f with value(ref, q), where ref
+// is the reference without its query and q is the query, and returns the
+// number of replaced occurrences. References with part=none are left
+// unchanged.
+func (f *foundRefs) replace(value func(ref string, q refQuery) (string, error)) (int, error) {
+	var updated int
+	for ref, nodes := range f.refs {
+		for _, node := range nodes {
+			if node.query.part == "none" {
+				continue
+			}
+			v, err := value(ref, node.query)
 			if err != nil {
-				return err
+				return 0, fmt.Errorf("resolving %q: %w", ref, err)
+			}
+			node.node.Value = v
+			updated++
+		}
+	}
+
+	// resolved returns what a reference within a larger value resolves to.
+	resolved := func(s string) (string, error) {
+		ref, q, err := parseRef(s)
+		if err != nil {
+			return "", err
+		}
+		if q.part == "none" {
+			return s, nil
+		}
+		v, err := value(ref, q)
+		if err != nil {
+			return "", fmt.Errorf("resolving %q: %w", ref, err)
+		}
+		updated++
+		return v, nil
+	}
+
+	// Next, update the references within JSON values and re-encode them.
+	for _, jn := range f.jsonNodes {
+		v, err := walkJSONRefs(jn.value, resolved)
+		if err != nil {
+			return 0, err
+		}
+		encoded, err := encodeJSON(v, jn.node.Value)
+		if err != nil {
+			return 0, err
+		}
+		jn.node.Value = encoded
+	}
+
+	// Finally, substitute the references embedded within larger strings.
+	for _, node := range f.substringNodes {
+		v, err := replaceSubstringRefs(node.Value, func(s string) (string, error) {
+			// Keep the whole $(...) of a reference that is left unchanged.
+			if _, q, err := parseRef(s); err == nil && q.part == "none" {
+				return "$(" + s + ")", nil
+			}
+			return resolved(s)
+		})
+		if err != nil {
+			return 0, err
+		}
+		node.Value = v
+	}
+	return updated, nil
+}
+
+// callOnResolved calls f, turning a panic into an error.
+func callOnResolved(f func(ref, digest string), ref, digest string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("OnResolved callback for %s panicked: %v", ref, r)
+		}
+	}()
+	f(ref, digest)
+	return nil
+}
+
+// ImageReferencesParallel is like ImageReferences, but builds and publishes
+// the references with a fixed pool of workers instead of a goroutine each,
+// which scales better to hundreds of references. The pool has as many workers
+// as set with WithConcurrencyLimit, or runtime.GOMAXPROCS(0) if there is no
+// limit. Once a reference fails, the remaining ones are not built.
+func ImageReferencesParallel(ctx context.Context, docs []*yaml.Node, builder build.Interface, publisher publish.Interface, opts ...Option) error {
+	return ImageReferences(ctx, docs, builder, publisher, append(opts, withWorkerPool())...)
+}
+
+// ImageReferencesWithContext is like ImageReferences, but logs with the logger
+// carried by ctx (see NewContext), or slog.Default() if there is none. The
+// records are logged with ctx, so handlers can add attributes from it, like
+// a request ID. WithLogger takes precedence over both.
+func ImageReferencesWithContext(ctx context.Context, docs []*yaml.Node, builder build.Interface, publisher publish.Interface, opts ...Option) error {
+	return ImageReferences(ctx, docs, builder, publisher, append([]Option{WithLogger(loggerFromContext(ctx))}, opts...)...)
+}
+
+// StreamingImageReferences is like ImageReferences, but reads yaml documents
+// from r and writes them to w one at a time once their references have been
+// resolved, instead of holding all of them in memory.
+func StreamingImageReferences(ctx context.Context, r io.Reader, w io.Writer, builder build.Interface, publisher publish.Interface, opts ...Option) error {
+	decoder := yaml.NewDecoder(r)
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+
+		if err := ImageReferences(ctx, []*yaml.Node{&doc}, builder, publisher, opts...); err != nil {
+			return err
+		}
+
+		if err := encoder.Encode(&doc); err != nil {
+			return fmt.Errorf("failed to encode output: %w", err)
+		}
+	}
+
+	return encoder.Close()
+}
+
+// Validate checks that every supported reference within docs, e.g.
+// ko://github.com/foo/bar?part=digest, is well-formed and names an import path
+// that builder supports, without building anything. Unlike a dry run of
+// ImageReferences, it reports every invalid reference rather than the first
+// one, and never mutates docs. Like for ImageReferences, docs need not be
+// document nodes.
+func Validate(ctx context.Context, docs []*yaml.Node, builder build.Interface) []error {
+	var errs []error
+	for i, doc := range asDocuments(docs) {
+		it := refNodesFromDoc(doc)
+		for node, ok := it(); ok; node, ok = it() {
+			if err := ctx.Err(); err != nil {
+				return append(errs, err)
+			}
+			ref, _, err := parseRef(node.Value)
+			if err == nil {
+				if err = builder.IsSupportedReference(ref); err != nil {
+					err = fmt.Errorf("%s is not a valid import path: %w", ref, err)
+				}
 			}
-			digest, err := publisher.Publish(ctx, img, ref)
 			if err != nil {
-				return err
+				errs = append(errs, fmt.Errorf("document %d, line %d: %w", i, node.Line, err))
 			}
-			sm.Store(ref, digest.String())
-			return nil
+		}
+	}
+	return errs
+}
+
+// supportedParts are the values of the `part` query parameter supported by
+// ImageReferences, besides the empty part selecting the full reference.
+var supportedParts = map[string]bool{
+	"digest":            true,
+	"digestAlgorithm":   true,
+	"digestHex":         true,
+	"shortDigest":       true,
+	"repository":        true,
+	"registryHostname":  true,
+	"fullDigest":        true,
+	"tag":               true,
+	"imageID":           true,
+	"labels":            true,
+	"created":           true,
+	"size":              true,
+	"platformDigest":    true,
+	"manifestMediaType": true,
+	"indexDigest":       true,
+	"none":              true,
+}
+
+// ParseParts parses a supported reference, e.g.
+// ko://github.com/foo/bar?part=digest, into the import path to build and the
+// part of the published image reference requested with the `part` query
+// parameter, which is empty when the full reference is requested. It does not
+// check whether the import path can be built.
+func ParseParts(ref string) (importPath, part string, err error) {
+	importPath, q, err := parseQuery(ref)
+	if err != nil {
+		return "", "", err
+	}
+	return importPath, q.part, nil
+}
+
+// parseQuery is like ParseParts, but returns the whole query of ref.
+func parseQuery(ref string) (string, refQuery, error) {
+	rest, ok := strings.CutPrefix(ref, build.StrictScheme)
+	if !ok {
+		return "", refQuery{}, fmt.Errorf("%q does not start with %s", ref, build.StrictScheme)
+	}
+	importPath, query, found := strings.Cut(rest, "?")
+	if importPath == "" {
+		return "", refQuery{}, fmt.Errorf("%q has no import path", ref)
+	}
+	// Import paths are ASCII, so anything else is most likely a homoglyph
+	// typed by accident, which would otherwise fail later with an error that
+	// looks just like the reference that was meant. The error escapes it.
+	for i, r := range importPath {
+		if r > unicode.MaxASCII {
+			return "", refQuery{}, fmt.Errorf("import path of %+q contains non-ASCII character %+q (%U) at offset %d", ref, r, r, i)
+		}
+	}
+	if !found {
+		return importPath, refQuery{}, nil
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", refQuery{}, fmt.Errorf("parsing query of %q: %w", ref, err)
+	}
+	q := refQuery{part: values.Get("part")}
+	if q.part != "" && !supportedParts[q.part] {
+		return "", refQuery{}, fmt.Errorf("unsupported part %q in %q", q.part, ref)
+	}
+	platform := values.Get("platform")
+	switch {
+	case q.part == "platformDigest" && platform == "":
+		return "", refQuery{}, fmt.Errorf("part platformDigest requires a platform, e.g. &platform=linux/arm64, in %q", ref)
+	case q.part != "platformDigest" && platform != "":
+		return "", refQuery{}, fmt.Errorf("platform is only supported with part=platformDigest in %q", ref)
+	case platform != "":
+		if q.platform, err = v1.ParsePlatform(platform); err != nil {
+			return "", refQuery{}, fmt.Errorf("parsing platform of %q: %w", ref, err)
+		}
+	}
+	return importPath, q, nil
+}
+
+// published is the result of building and publishing a reference. Its parts
+// are only computed when requested, as most build results are only needed for
+// the full image reference.
+type published struct {
+	// image is the published image reference, and build the built image.
+	image name.Reference
+	build build.Result
+	// ref is the reference that was published, and sizer is the publisher
+	// if it implements publish.Sizer.
+	ref   string
+	sizer publish.Sizer
+}
+
+// part returns the requested part of the published image.
+func (p published) part(q refQuery) (string, error) {
+	switch part := q.part; part {
+	case "imageID":
+		return imageIDOf(p.build)
+	case "labels":
+		labels, err := labelsOf(p.build)
+		if err != nil {
+			return "", fmt.Errorf("reading labels of %s: %w", p.image, err)
+		}
+		return formatLabels(labels), nil
+	case "created":
+		created, err := createdOf(p.build)
+		if err != nil {
+			return "", fmt.Errorf("reading creation time of %s: %w", p.image, err)
+		}
+		// The zero time is formatted as 0001-01-01T00:00:00Z, so that
+		// reproducible builds still yield a valid timestamp.
+		return created.UTC().Format(time.RFC3339), nil
+	case "size":
+		size, err := p.size()
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(size, 10), nil
+	case "platformDigest":
+		return platformDigestOf(p.build, *q.platform)
+	case "manifestMediaType":
+		mt, err := p.build.MediaType()
+		if err != nil {
+			return "", fmt.Errorf("reading media type of %s: %w", p.image, err)
+		}
+		return string(mt), nil
+	case "indexDigest":
+		// An image index is published as is, so its digest is that of the
+		// index rather than of any platform's manifest.
+		digest, err := p.build.Digest()
+		if err != nil {
+			return "", fmt.Errorf("computing digest: %w", err)
+		}
+		return digest.String(), nil
+	default:
+		return imageRefPart(p.image, part)
+	}
+}
+
+// platformDigestOf returns the digest of the image built for platform. For an
+// image index, that is the digest of the matching manifest within it, and a
+// single image must have been built for platform itself.
+func platformDigestOf(result build.Result, platform v1.Platform) (string, error) {
+	switch r := result.(type) {
+	case v1.ImageIndex:
+		im, err := r.IndexManifest()
+		if err != nil {
+			return "", fmt.Errorf("reading index manifest: %w", err)
+		}
+		for _, desc := range im.Manifests {
+			if desc.Platform != nil && desc.Platform.Satisfies(platform) {
+				return desc.Digest.String(), nil
+			}
+		}
+	case v1.Image:
+		cf, err := r.ConfigFile()
+		if err != nil {
+			return "", fmt.Errorf("reading config file: %w", err)
+		}
+		if cf.Platform() != nil && cf.Platform().Satisfies(platform) {
+			digest, err := r.Digest()
+			if err != nil {
+				return "", err
+			}
+			return digest.String(), nil
+		}
+	}
+	return "", fmt.Errorf("platform %s was not built", platform.String())
+}
+
+// createdOf returns the creation time of the image's config, or the zero time
+// if it doesn't record one, e.g. for reproducible builds. The creation time of
+// an image index is that of its most recently created image.
+func createdOf(br build.Result) (time.Time, error) {
+	switch br := br.(type) {
+	case v1.Image:
+		cf, err := br.ConfigFile()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return cf.Created.Time, nil
+
+	case v1.ImageIndex:
+		im, err := br.IndexManifest()
+		if err != nil {
+			return time.Time{}, err
+		}
+		var latest time.Time
+		for _, desc := range im.Manifests {
+			if !desc.MediaType.IsImage() {
+				continue
+			}
+			img, err := br.Image(desc.Digest)
+			if err != nil {
+				return time.Time{}, err
+			}
+			created, err := createdOf(img)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if created.After(latest) {
+				latest = created
+			}
+		}
+		return latest, nil
+
+	default:
+		return time.Time{}, fmt.Errorf("unsupported build result type %T", br)
+	}
+}
+
+// labelsOf returns the labels of the image's config. The labels of an image
+// index are those that all of its images have in common.
+func labelsOf(br build.Result) (map[string]string, error) {
+	switch br := br.(type) {
+	case v1.Image:
+		cf, err := br.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		return cf.Config.Labels, nil
+
+	case v1.ImageIndex:
+		im, err := br.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		var common map[string]string
+		first := true
+		for _, desc := range im.Manifests {
+			if !desc.MediaType.IsImage() {
+				continue
+			}
+			img, err := br.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			labels, err := labelsOf(img)
+			if err != nil {
+				return nil, err
+			}
+			if first {
+				common, first = maps.Clone(labels), false
+				continue
+			}
+			for k, v := range common {
+				if labels[k] != v {
+					delete(common, k)
+				}
+			}
+		}
+		return common, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported build result type %T", br)
+	}
+}
+
+// formatLabels renders labels as key=value pairs sorted by key and separated
+// by commas, e.g. org.opencontainers.image.version=v1.2,team=foo.
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// size returns the compressed size in bytes of the layers of the published
+// image, as reported by the publisher if it can, or else as computed from the
+// built image. The layers of an image index are not summed up, as they aren't
+// all pulled.
+func (p published) size() (int64, error) {
+	if p.sizer != nil {
+		return p.sizer.ImageSize(p.ref)
+	}
+	img, ok := p.build.(v1.Image)
+	if !ok {
+		return 0, errors.New("size is not supported for multi-platform images")
+	}
+	return layersSize(img)
+}
+
+// layersSize returns the total compressed size of the layers of img.
+func layersSize(img v1.Image) (int64, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return 0, fmt.Errorf("computing size: %w", err)
+	}
+	var total int64
+	for _, layer := range layers {
+		size, err := layer.Size()
+		if err != nil {
+			return 0, fmt.Errorf("computing size: %w", err)
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// compressedSize returns the total compressed size of the layers of a built
+// image, or of all images of a built image index.
+func compressedSize(result build.Result) (int64, error) {
+	switch r := result.(type) {
+	case v1.Image:
+		return layersSize(r)
+	case v1.ImageIndex:
+		im, err := r.IndexManifest()
+		if err != nil {
+			return 0, fmt.Errorf("computing size: %w", err)
+		}
+		var total int64
+		for _, desc := range im.Manifests {
+			if !desc.MediaType.IsImage() {
+				continue
+			}
+			img, err := r.Image(desc.Digest)
+			if err != nil {
+				return 0, fmt.Errorf("computing size: %w", err)
+			}
+			size, err := layersSize(img)
+			if err != nil {
+				return 0, err
+			}
+			total += size
+		}
+		return total, nil
+	default:
+		return 0, fmt.Errorf("computing size: unsupported result type %T", result)
+	}
+}
+
+// imageIDOf returns the image ID of a built image, which is the digest of its
+// config blob rather than of its manifest. An image index has no config, so
+// it has no image ID either.
+func imageIDOf(result build.Result) (string, error) {
+	img, ok := result.(v1.Image)
+	if !ok {
+		return "", errors.New("imageID is not supported for multi-platform images")
+	}
+	id, err := img.ConfigName()
+	if err != nil {
+		return "", fmt.Errorf("computing image ID: %w", err)
+	}
+	return id.String(), nil
+}
+
+// shortDigestLength is the number of hex characters of an abbreviated digest,
+// like those shown by docker.
+const shortDigestLength = 12
+
+// imageRefPart returns the requested part of the published image reference.
+// An empty part returns the full reference.
+func imageRefPart(ref name.Reference, part string) (string, error) {
+	switch part {
+	case "":
+		return ref.String(), nil
+	case "digest":
+		return digestOf(ref)
+	case "digestAlgorithm":
+		digest, err := digestOf(ref)
+		if err != nil {
+			return "", err
+		}
+		algorithm, _, _ := strings.Cut(digest, ":")
+		return algorithm, nil
+	case "digestHex":
+		digest, err := digestOf(ref)
+		if err != nil {
+			return "", err
+		}
+		_, hex, _ := strings.Cut(digest, ":")
+		return hex, nil
+	case "shortDigest":
+		digest, err := digestOf(ref)
+		if err != nil {
+			return "", err
+		}
+		_, hex, _ := strings.Cut(digest, ":")
+		if len(hex) > shortDigestLength {
+			hex = hex[:shortDigestLength]
+		}
+		return hex, nil
+	case "repository":
+		return repositoryOf(ref), nil
+	case "registryHostname":
+		// url.URL knows to strip the port, also from bracketed IPv6 hosts.
+		return (&url.URL{Host: ref.Context().RegistryStr()}).Hostname(), nil
+	case "tag":
+		return tagOf(ref), nil
+	case "fullDigest":
+		digest, err := digestOf(ref)
+		if err != nil {
+			return "", err
+		}
+		return repositoryOf(ref) + "@" + digest, nil
+	default:
+		return "", fmt.Errorf("unsupported part %q", part)
+	}
+}
+
+// splitRef splits a published image reference into its repository, tag and
+// digest, any of which may be empty.
+func splitRef(ref name.Reference) (repo, tag, digest string) {
+	repo, digest, _ = strings.Cut(ref.String(), "@")
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+	return repo, tag, digest
+}
+
+// repositoryOf returns a published image reference without its tag or
+// digest, e.g. registry.example.com/foo. Compose files reject image references
+// with a trailing slash, so that is stripped as well.
+func repositoryOf(ref name.Reference) string {
+	repo, _, _ := splitRef(ref)
+	return strings.TrimRight(repo, "/")
+}
+
+// tagOf returns the tag of a published image reference. Like Docker, this
+// defaults to "latest" when the reference has no tag, e.g. when it only
+// contains a digest.
+func tagOf(ref name.Reference) string {
+	if _, tag, _ := splitRef(ref); tag != "" {
+		return tag
+	}
+	return name.DefaultTag
+}
+
+// digestOf returns the digest of a published image reference, e.g.
+// sha256:deadbeef... This also handles tagged-plus-digest references, like
+// registry.example.com/foo:v1.2@sha256:deadbeef...
+func digestOf(ref name.Reference) (string, error) {
+	_, _, digest := splitRef(ref)
+	if !strings.Contains(digest, ":") {
+		return "", fmt.Errorf("published reference %s does not contain a digest", ref)
+	}
+	return digest, nil
+}
+
+// expandAliases replaces alias nodes that refer to a supported reference with
+// a copy of their anchor, so that each is resolved in place. Otherwise, an
+// alias whose anchor lives in a previous document would be emitted as a
+// dangling alias when the documents are encoded one by one.
+func expandAliases(doc *yaml.Node) {
+	var aliases []*yaml.Node
+	it := yit.FromNode(doc).RecurseNodes()
+	for node, ok := it(); ok; node, ok = it() {
+		if node.Kind != yaml.AliasNode || node.Alias == nil || node.Alias.Kind != yaml.ScalarNode {
+			continue
+		}
+		if withTrimmedPrefix(build.StrictScheme)(node.Alias) {
+			aliases = append(aliases, node)
+		}
+	}
+
+	for _, node := range aliases {
+		expanded := *node.Alias
+		expanded.Anchor = ""
+		expanded.HeadComment = node.HeadComment
+		expanded.LineComment = node.LineComment
+		expanded.FootComment = node.FootComment
+		expanded.Line = node.Line
+		expanded.Column = node.Column
+		*node = expanded
+	}
+}
+
+// isTemplate reports whether value contains a template expression, like those
+// of Helm charts, see WithHelmPassthrough.
+func isTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+// asDocument returns node if it is a document node, or else a synthetic
+// document node holding it. The content is shared, so that resolving the
+// references of the document mutates node.
+func asDocument(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.DocumentNode {
+		return node
+	}
+	return &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{node}}
+}
+
+// asDocuments applies asDocument to each of docs, without modifying docs.
+func asDocuments(docs []*yaml.Node) []*yaml.Node {
+	wrapped := make([]*yaml.Node, 0, len(docs))
+	for _, doc := range docs {
+		wrapped = append(wrapped, asDocument(doc))
+	}
+	return wrapped
+}
+
+// docsWithScheme returns the docs that contain build.StrictScheme anywhere in
+// their values, since no other document can hold a supported reference.
+func docsWithScheme(docs []*yaml.Node) []*yaml.Node {
+	var filtered []*yaml.Node
+	for _, doc := range docs {
+		if containsScheme(doc) {
+			filtered = append(filtered, doc)
+		}
+	}
+	return filtered
+}
+
+// containsScheme reports whether any scalar within node contains
+// build.StrictScheme. Aliases are not followed, as their anchors are part of
+// the same document.
+func containsScheme(node *yaml.Node) bool {
+	if node.Kind == yaml.ScalarNode {
+		return strings.Contains(node.Value, build.StrictScheme)
+	}
+	for _, n := range node.Content {
+		if containsScheme(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// substringRefPattern matches supported references embedded within a larger
+// string with the `$(ko://...)` syntax, capturing the reference.
+var substringRefPattern = regexp.MustCompile(`\$\((` + regexp.QuoteMeta(build.StrictScheme) + `[^()\s]+)\)`)
+
+// substringsFromDoc returns an iterator over the string nodes of doc that
+// embed a supported reference with the `$(ko://...)` syntax.
+func substringsFromDoc(doc *yaml.Node) yit.Iterator {
+	return yit.FromNode(doc).
+		RecurseNodes().
+		Filter(yit.StringValue).
+		Filter(func(node *yaml.Node) bool {
+			return substringRefPattern.MatchString(node.Value)
 		})
+}
+
+// replaceSubstringRefs replaces every `$(ko://...)` in s with the result of
+// calling f with the embedded reference.
+func replaceSubstringRefs(s string, f func(string) (string, error)) (string, error) {
+	var err error
+	out := substringRefPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if err != nil {
+			return match
+		}
+		var value string
+		value, err = f(substringRefPattern.FindStringSubmatch(match)[1])
+		return value
+	})
+	if err != nil {
+		return "", err
 	}
-	if err := errg.Wait(); err != nil {
-		return err
+	return out, nil
+}
+
+// RefsFromDoc returns the distinct supported references within doc, e.g.
+// ko://github.com/foo/bar, in the order they first appear. Query strings such
+// as ?part=digest are dropped, and values that are not well-formed references
+// are skipped. Like for ImageReferences, doc need not be a document node.
+func RefsFromDoc(doc *yaml.Node) []string {
+	if doc == nil {
+		return nil
 	}
+	var refs []string
+	seen := map[string]bool{}
+	it := refNodesFromDoc(asDocument(doc))
+	for node, ok := it(); ok; node, ok = it() {
+		ref, _, err := parseRef(node.Value)
+		if err != nil || seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		refs = append(refs, ref)
+	}
+	return refs
+}
 
-	// Walk the tags and update them with their digest.
-	for ref, nodes := range refs {
-		digest, ok := sm.Load(ref)
+// ReplaceRefs replaces the supported references within the YAML documents in
+// data with what replacer returns for them, e.g. image references pinned by
+// digest in a lock file, and returns the re-encoded documents. Nothing is
+// built or published. replacer is called once for each distinct reference,
+// e.g. ko://github.com/foo/bar, without its query, in sorted order.
+//
+// The parts of an image reference, like ?part=digest, are supported as for
+// ImageReferences, while those that need the image itself, like imageID, are
+// not. Unlike for ImageReferences, references within JSON values and larger
+// strings are left alone. Empty input is returned as is.
+func ReplaceRefs(data []byte, replacer func(ref string) (string, error)) ([]byte, error) {
+	docs, err := DocsFromBytes(data)
+	if errors.Is(err, ErrNoDocuments) {
+		return data, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ro, err := makeOptions()
+	if err != nil {
+		return nil, err
+	}
+	found, err := collectRefs(context.Background(), docs, ro, nil)
+	if err != nil {
+		return nil, err
+	}
 
-		if !ok {
-			return fmt.Errorf("resolved reference to %q not found", ref)
+	replaced := make(map[string]string, len(found.refs))
+	for _, ref := range sortedRefs(found.refs) {
+		value, err := replacer(ref)
+		if err != nil {
+			return nil, fmt.Errorf("replacing %s: %w", ref, err)
+		}
+		replaced[ref] = value
+	}
+	if _, err := found.replace(func(ref string, q refQuery) (string, error) {
+		value := replaced[ref]
+		if q.part == "" {
+			return value, nil
 		}
+		parsed, err := name.ParseReference(value)
+		if err != nil {
+			return "", fmt.Errorf("part %s of %q: %w", q.part, value, err)
+		}
+		return imageRefPart(parsed, q.part)
+	}); err != nil {
+		return nil, err
+	}
+	return DocsToBytes(docs)
+}
 
-		for _, node := range nodes {
-			node.Value = digest.(string)
+// ExtractRefs returns the distinct supported references within the YAML
+// documents in data, sorted, like RefsFromDoc does for a single document.
+// Nothing is built, so it suits tools that only need to know which import
+// paths some YAML refers to. Empty input holds no references.
+func ExtractRefs(data []byte) ([]string, error) {
+	docs, err := DocsFromBytes(data)
+	if errors.Is(err, ErrNoDocuments) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var refs []string
+	seen := map[string]bool{}
+	for _, doc := range docs {
+		for _, ref := range RefsFromDoc(doc) {
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			refs = append(refs, ref)
 		}
 	}
+	sort.Strings(refs)
+	return refs, nil
+}
 
-	return nil
+// parseRef parses the value of a node matched by refNodesFromDoc into the
+// reference to build, e.g. ko://github.com/foo/bar, and its query.
+func parseRef(value string) (string, refQuery, error) {
+	importPath, q, err := parseQuery(strings.TrimSpace(value))
+	if err != nil {
+		return "", refQuery{}, err
+	}
+	return build.StrictScheme + importPath, q, nil
 }
 
-func refsFromDoc(doc *yaml.Node) yit.Iterator {
+// refNodesFromDoc returns an iterator over the string nodes of doc that hold
+// a supported reference.
+func refNodesFromDoc(doc *yaml.Node) yit.Iterator {
 	it := yit.FromNode(doc).
 		RecurseNodes().
 		Filter(yit.StringValue)
 
-	return it.Filter(yit.WithPrefix(build.StrictScheme))
+	return it.Filter(withTrimmedPrefix(build.StrictScheme))
+}
+
+// withTrimmedPrefix matches nodes whose value starts with prefix once
+// surrounding whitespace is trimmed. This catches block scalars (literal `|`
+// and folded `>` style), whose values may start with blank lines.
+func withTrimmedPrefix(prefix string) yit.Predicate {
+	return func(node *yaml.Node) bool {
+		return strings.HasPrefix(strings.TrimSpace(node.Value), prefix)
+	}
 }
@@ -15,17 +15,34 @@
 package resolve
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/google/ko/pkg/build"
 	kotesting "github.com/google/ko/pkg/internal/testing"
+	"github.com/google/ko/pkg/publish"
 	"gopkg.in/yaml.v3"
 )
 
@@ -272,24 +289,2084 @@ func TestStrict(t *testing.T) {
 	t.Log(yamlToStr(t, doc))
 }
 
+func TestBlockScalars(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	want := kotesting.ComputeDigest(base, fooRef, fooHash)
+	for _, test := range []struct {
+		desc  string
+		input string
+	}{{
+		desc:  "literal",
+		input: "image: |\n  ko://" + fooRef + "\n",
+	}, {
+		desc:  "literal with leading blank line",
+		input: "image: |\n\n  ko://" + fooRef + "\n",
+	}, {
+		desc:  "folded",
+		input: "image: >-\n  ko://" + fooRef + "\n",
+	}, {
+		desc:  "folded with leading blank line",
+		input: "image: >\n\n  ko://" + fooRef + "\n",
+	}} {
+		t.Run(test.desc, func(t *testing.T) {
+			doc := strToYAML(t, test.input)
+			err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes))
+			if err != nil {
+				t.Fatalf("ImageReferences(%v) = %v", test.input, err)
+			}
+			var outStructured map[string]string
+			if err := doc.Decode(&outStructured); err != nil {
+				t.Errorf("doc.Decode(%v) = %v", yamlToStr(t, doc), err)
+			}
+			if got := strings.TrimSpace(outStructured["image"]); got != want {
+				t.Errorf("ImageReferences(%v) = %v, want %v", test.input, got, want)
+			}
+		})
+	}
+}
+
+func TestAliasAcrossDocuments(t *testing.T) {
+	input := fmt.Sprintf("image: &img %s%s\n---\nfirst: *img\nrest: [*img, *img]\n", build.StrictScheme, fooRef)
+
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(strings.NewReader(input))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatalf("Decode(%v) = %v", input, err)
+		}
+		docs = append(docs, &doc)
+	}
+
+	base := mustRepository("gcr.io/mattmoor")
+	err := ImageReferences(context.Background(), docs, testBuilder, kotesting.NewFixedPublish(base, testHashes))
+	if err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", input, err)
+	}
+
+	// Encode the second document on its own, as `ko resolve` does, to make
+	// sure it does not contain a dangling alias.
+	var outStructured struct {
+		First string
+		Rest  []string
+	}
+	if err := yaml.Unmarshal([]byte(yamlToStr(t, docs[1])), &outStructured); err != nil {
+		t.Fatalf("yaml.Unmarshal(%v) = %v", yamlToStr(t, docs[1]), err)
+	}
+
+	want := kotesting.ComputeDigest(base, fooRef, fooHash)
+	if outStructured.First != want {
+		t.Errorf("first = %v, want %v", outStructured.First, want)
+	}
+	if diff := cmp.Diff([]string{want, want}, outStructured.Rest); diff != "" {
+		t.Errorf("rest; (-want +got) = %v", diff)
+	}
+}
+
+func TestNonDocumentNodes(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	fooDigest := kotesting.ComputeDigest(base, fooRef, fooHash)
+	barDigest := kotesting.ComputeDigest(base, barRef, barHash)
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  string
+		kind  yaml.Kind
+	}{{
+		name:  "mapping node",
+		input: fmt.Sprintf("image: ko://%s\nsidecar: ko://%s\n", fooRef, barRef),
+		want:  fmt.Sprintf("image: %s\nsidecar: %s\n", fooDigest, barDigest),
+		kind:  yaml.MappingNode,
+	}, {
+		name:  "sequence node",
+		input: fmt.Sprintf("- ko://%s\n- name: sidecar\n  image: ko://%s\n", fooRef, barRef),
+		want:  fmt.Sprintf("- %s\n- name: sidecar\n  image: %s\n", fooDigest, barDigest),
+		kind:  yaml.SequenceNode,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			node := strToYAML(t, tc.input).Content[0]
+			if node.Kind != tc.kind {
+				t.Fatalf("node.Kind = %v, want %v", node.Kind, tc.kind)
+			}
+
+			if diff := cmp.Diff([]string{"ko://" + fooRef, "ko://" + barRef}, RefsFromDoc(node)); diff != "" {
+				t.Errorf("RefsFromDoc() (-want +got) = %s", diff)
+			}
+			if errs := Validate(context.Background(), []*yaml.Node{node}, testBuilder); len(errs) != 0 {
+				t.Errorf("Validate() = %v", errs)
+			}
+			if err := ImageReferences(context.Background(), []*yaml.Node{node}, testBuilder, kotesting.NewFixedPublish(base, testHashes)); err != nil {
+				t.Fatalf("ImageReferences(%v) = %v", tc.input, err)
+			}
+			var got strings.Builder
+			enc := yaml.NewEncoder(&got)
+			enc.SetIndent(2)
+			if err := enc.Encode(node); err != nil {
+				t.Fatalf("Encode() = %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got.String()); diff != "" {
+				t.Errorf("ImageReferences(%v); (-want +got) = %v", tc.input, diff)
+			}
+		})
+	}
+}
+
+func TestStreamingImageReferences(t *testing.T) {
+	input := fmt.Sprintf("image: %s%s\n---\nimages:\n- %s%s\n- %s%s?part=digest\n",
+		build.StrictScheme, fooRef, build.StrictScheme, barRef, build.StrictScheme, bazRef)
+
+	base := mustRepository("gcr.io/mattmoor")
+	out := bytes.NewBuffer(nil)
+	err := StreamingImageReferences(context.Background(), strings.NewReader(input), out, testBuilder, kotesting.NewFixedPublish(base, testHashes))
+	if err != nil {
+		t.Fatalf("StreamingImageReferences(%v) = %v", input, err)
+	}
+
+	want := fmt.Sprintf("image: %s\n---\nimages:\n  - %s\n  - %s\n",
+		kotesting.ComputeDigest(base, fooRef, fooHash),
+		kotesting.ComputeDigest(base, barRef, barHash),
+		bazHash)
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("StreamingImageReferences(%v); (-want +got) = %v", input, diff)
+	}
+}
+
+func TestStreamingImageReferencesWithoutReferences(t *testing.T) {
+	// Like the output of kubectl get -o yaml, where only some documents hold
+	// references, which must not affect the others.
+	input := fmt.Sprintf(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+        - image: %s%s
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+`, build.StrictScheme, fooRef)
+
+	base := mustRepository("gcr.io/mattmoor")
+	out := bytes.NewBuffer(nil)
+	err := StreamingImageReferences(context.Background(), strings.NewReader(input), out, testBuilder, kotesting.NewFixedPublish(base, testHashes))
+	if err != nil {
+		t.Fatalf("StreamingImageReferences(%v) = %v", input, err)
+	}
+
+	want := strings.Replace(input, build.StrictScheme+fooRef, kotesting.ComputeDigest(base, fooRef, fooHash), 1)
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("StreamingImageReferences(%v); (-want +got) = %v", input, diff)
+	}
+}
+
+func TestJSONStringExpansion(t *testing.T) {
+	input := fmt.Sprintf(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  config.json: |
+    {
+      "image": "%s%s",
+      "sidecars": ["%s%s?part=digest"],
+      "replicas": 3
+    }
+  inline.json: '{"image": "%s%s"}'
+  image: %s%s
+`, build.StrictScheme, fooRef, build.StrictScheme, barRef, build.StrictScheme, bazRef, build.StrictScheme, fooRef)
+
+	base := mustRepository("gcr.io/mattmoor")
+	doc := strToYAML(t, input)
+	err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithJSONStringExpansion())
+	if err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", input, err)
+	}
+
+	var got struct {
+		Data map[string]string `yaml:"data"`
+	}
+	if err := yaml.Unmarshal([]byte(yamlToStr(t, doc)), &got); err != nil {
+		t.Fatalf("yaml.Unmarshal() = %v", err)
+	}
+	want := map[string]string{
+		"config.json": fmt.Sprintf(`{
+  "image": "%s",
+  "replicas": 3,
+  "sidecars": [
+    "%s"
+  ]
+}
+`, kotesting.ComputeDigest(base, fooRef, fooHash), barHash),
+		"inline.json": fmt.Sprintf(`{"image":"%s"}`, kotesting.ComputeDigest(base, bazRef, bazHash)),
+		"image":       kotesting.ComputeDigest(base, fooRef, fooHash),
+	}
+	if diff := cmp.Diff(want, got.Data); diff != "" {
+		t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+	}
+}
+
+func TestJSONStringExpansionDisabled(t *testing.T) {
+	input := fmt.Sprintf("config.json: '{\"image\": \"%s%s\"}'\n", build.StrictScheme, fooRef)
+
+	base := mustRepository("gcr.io/mattmoor")
+	doc := strToYAML(t, input)
+	err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes))
+	if err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", input, err)
+	}
+	if diff := cmp.Diff(input, yamlToStr(t, doc)); diff != "" {
+		t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+	}
+}
+
+func TestFastPathSkip(t *testing.T) {
+	inputs := []string{
+		"kind: ConfigMap\ndata:\n    image: gcr.io/not/a/reference\n",
+		"image: " + build.StrictScheme + fooRef + "\n",
+		"args:\n    - --image=$(" + build.StrictScheme + barRef + ")\n",
+	}
+	base := mustRepository("gcr.io/mattmoor")
+	want := []string{
+		inputs[0],
+		"image: " + kotesting.ComputeDigest(base, fooRef, fooHash) + "\n",
+		"args:\n    - --image=" + kotesting.ComputeDigest(base, barRef, barHash) + "\n",
+	}
+
+	docs := make([]*yaml.Node, 0, len(inputs))
+	for _, input := range inputs {
+		docs = append(docs, strToYAML(t, input))
+	}
+	stats := &Stats{}
+	if err := ImageReferences(context.Background(), docs, testBuilder, kotesting.NewFixedPublish(base, testHashes),
+		WithFastPathSkip(), WithSubstringMatching(), WithStats(stats)); err != nil {
+		t.Fatalf("ImageReferences() = %v", err)
+	}
+	for i, doc := range docs {
+		if diff := cmp.Diff(want[i], yamlToStr(t, doc)); diff != "" {
+			t.Errorf("ImageReferences(%v); (-want +got) = %v", inputs[i], diff)
+		}
+	}
+	if stats.NodesUpdated != 2 {
+		t.Errorf("NodesUpdated = %d, want 2", stats.NodesUpdated)
+	}
+}
+
+// BenchmarkImageReferencesWithoutRefs resolves a document of 10,000 nodes
+// that holds no references, with and without WithFastPathSkip.
+func BenchmarkImageReferencesWithoutRefs(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("items:\n")
+	// Each item is a mapping node holding two key and value scalars.
+	for i := 0; i < 10000/5; i++ {
+		fmt.Fprintf(&sb, "  - name: item-%d\n    image: gcr.io/not/a/reference:%d\n", i, i)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(sb.String()), &doc); err != nil {
+		b.Fatalf("yaml.Unmarshal() = %v", err)
+	}
+	publisher := kotesting.NewFixedPublish(mustRepository("gcr.io/mattmoor"), testHashes)
+
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "full walk"},
+		{name: "fast path", opts: []Option{WithFastPathSkip()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := ImageReferences(context.Background(), []*yaml.Node{&doc}, testBuilder, publisher, bc.opts...); err != nil {
+					b.Fatalf("ImageReferences() = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		opts  []Option
+		want  int
+	}{{
+		name:  "no references",
+		input: "image: busybox\n",
+		want:  0,
+	}, {
+		name:  "each occurrence is counted",
+		input: fmt.Sprintf("a: ko://%s\nb: ko://%s\nc: ko://%s?part=digest\nd: ko://%s\n", fooRef, fooRef, fooRef, barRef),
+		want:  4,
+	}, {
+		name:  "aliases are counted",
+		input: fmt.Sprintf("a: &img ko://%s\nb: *img\n", fooRef),
+		want:  2,
+	}, {
+		name:  "json values",
+		input: fmt.Sprintf("a: ko://%s\nb: '[\"ko://%s\", \"ko://%s\"]'\n", fooRef, fooRef, barRef),
+		opts:  []Option{WithJSONStringExpansion()},
+		want:  3,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			base := mustRepository("gcr.io/mattmoor")
+			doc := strToYAML(t, tc.input)
+			var stats Stats
+			opts := append([]Option{WithStats(&stats)}, tc.opts...)
+			if err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), opts...); err != nil {
+				t.Fatalf("ImageReferences(%v) = %v", tc.input, err)
+			}
+			if stats.NodesUpdated != tc.want {
+				t.Errorf("NodesUpdated = %d, want %d", stats.NodesUpdated, tc.want)
+			}
+		})
+	}
+}
+
+func TestStatsAcrossDocuments(t *testing.T) {
+	input := fmt.Sprintf("image: ko://%s\n---\nimages: [ko://%s, ko://%s]\n", fooRef, barRef, bazRef)
+
+	base := mustRepository("gcr.io/mattmoor")
+	var stats Stats
+	err := StreamingImageReferences(context.Background(), strings.NewReader(input), io.Discard, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithStats(&stats))
+	if err != nil {
+		t.Fatalf("StreamingImageReferences(%v) = %v", input, err)
+	}
+	if stats.NodesUpdated != 3 {
+		t.Errorf("NodesUpdated = %d, want 3", stats.NodesUpdated)
+	}
+}
+
+func TestSubstringMatching(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	fooDigest := kotesting.ComputeDigest(base, fooRef, fooHash)
+	barDigest := kotesting.ComputeDigest(base, barRef, barHash)
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  string
+	}{{
+		name:  "single reference",
+		input: fmt.Sprintf("--image=$(ko://%s)", fooRef),
+		want:  "--image=" + fooDigest,
+	}, {
+		name:  "several references",
+		input: fmt.Sprintf("--foo=$(ko://%s) --bar=$(ko://%s) --foo-again=$(ko://%s)", fooRef, barRef, fooRef),
+		want:  fmt.Sprintf("--foo=%s --bar=%s --foo-again=%s", fooDigest, barDigest, fooDigest),
+	}, {
+		name:  "part",
+		input: fmt.Sprintf("--digest=$(ko://%s?part=digest)", fooRef),
+		want:  "--digest=" + fooHash.String(),
+	}, {
+		name:  "other substitutions are left alone",
+		input: fmt.Sprintf("--image=$(ko://%s) --name=$(POD_NAME)", fooRef),
+		want:  fmt.Sprintf("--image=%s --name=$(POD_NAME)", fooDigest),
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := strToYAML(t, fmt.Sprintf("args:\n- %q\nimage: ko://%s\n", tc.input, bazRef))
+			var stats Stats
+			err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithSubstringMatching(), WithStats(&stats))
+			if err != nil {
+				t.Fatalf("ImageReferences(%v) = %v", tc.input, err)
+			}
+
+			var got struct {
+				Args  []string `yaml:"args"`
+				Image string   `yaml:"image"`
+			}
+			if err := yaml.Unmarshal([]byte(yamlToStr(t, doc)), &got); err != nil {
+				t.Fatalf("yaml.Unmarshal() = %v", err)
+			}
+			if diff := cmp.Diff([]string{tc.want}, got.Args); diff != "" {
+				t.Errorf("ImageReferences(%v); (-want +got) = %v", tc.input, diff)
+			}
+			if want := kotesting.ComputeDigest(base, bazRef, bazHash); got.Image != want {
+				t.Errorf("ImageReferences(%v); image = %s, want %s", tc.input, got.Image, want)
+			}
+			if want := strings.Count(tc.input, "$(ko://") + 1; stats.NodesUpdated != want {
+				t.Errorf("NodesUpdated = %d, want %d", stats.NodesUpdated, want)
+			}
+		})
+	}
+}
+
+func TestSubstringMatchingDisabled(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	input := fmt.Sprintf("args:\n    - --image=$(ko://%s)\n", fooRef)
+	doc := strToYAML(t, input)
+	if err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes)); err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", input, err)
+	}
+	if diff := cmp.Diff(input, yamlToStr(t, doc)); diff != "" {
+		t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+	}
+}
+
+func TestSubstringMatchingUnsupportedReference(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	doc := strToYAML(t, "args:\n  - --image=$(ko://example.com/unknown)\n")
+	err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithSubstringMatching())
+	if err == nil {
+		t.Error("ImageReferences() should err for an unsupported reference, got nil")
+	}
+}
+
+func TestHelmPassthrough(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	templates := []string{
+		fmt.Sprintf("image: '{{ include \"image\" (dict \"ref\" \"ko://%s\") }}'\n", fooRef),
+		fmt.Sprintf("image: ko://%s:{{ .Values.tag }}\n", fooRef),
+		fmt.Sprintf("args:\n    - '--image=$(ko://%s) {{ .Values.args }}'\n", fooRef),
+		fmt.Sprintf("config.json: '{\"image\": \"ko://%s:{{ .Values.tag }}\"}'\n", fooRef),
+	}
+	for _, input := range templates {
+		t.Run(input, func(t *testing.T) {
+			doc := strToYAML(t, input+fmt.Sprintf("other: ko://%s\n", barRef))
+			if err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes),
+				WithHelmPassthrough(), WithSubstringMatching(), WithJSONStringExpansion()); err != nil {
+				t.Fatalf("ImageReferences(%v) = %v", input, err)
+			}
+			want := input + "other: " + kotesting.ComputeDigest(base, barRef, barHash) + "\n"
+			if diff := cmp.Diff(want, yamlToStr(t, doc)); diff != "" {
+				t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+			}
+		})
+	}
+
+	// Without the option, a reference followed by a template is malformed.
+	doc := strToYAML(t, templates[1])
+	if err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes)); err == nil {
+		t.Error("ImageReferences() should err for a template without WithHelmPassthrough, got nil")
+	}
+}
+
+func TestOnResolved(t *testing.T) {
+	input := fmt.Sprintf("image: ko://%s\nalso: ko://%s\ndigest: ko://%s?part=digest\nother: ko://%s\n", fooRef, fooRef, fooRef, barRef)
+
+	base := mustRepository("gcr.io/mattmoor")
+	var mu sync.Mutex
+	got := map[string][]string{}
+	onResolved := func(ref, digest string) {
+		mu.Lock()
+		defer mu.Unlock()
+		got[ref] = append(got[ref], digest)
+	}
+	doc := strToYAML(t, input)
+	if err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithOnResolved(onResolved)); err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", input, err)
+	}
+	want := map[string][]string{
+		"ko://" + fooRef: {kotesting.ComputeDigest(base, fooRef, fooHash)},
+		"ko://" + barRef: {kotesting.ComputeDigest(base, barRef, barHash)},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("OnResolved calls; (-want +got) = %v", diff)
+	}
+
+	// A panicking callback fails the resolution instead of the program.
+	doc = strToYAML(t, input)
+	err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithOnResolved(func(string, string) {
+		panic("boom")
+	}))
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("ImageReferences() with a panicking callback = %v, want error containing %q", err, "boom")
+	}
+}
+
+// delayedBuild and delayedPublish take at least delay to build or publish.
+type delayedBuild struct {
+	build.Interface
+	delay time.Duration
+}
+
+func (d delayedBuild) Build(ctx context.Context, s string) (build.Result, error) {
+	time.Sleep(d.delay)
+	return d.Interface.Build(ctx, s)
+}
+
+type delayedPublish struct {
+	publish.Interface
+	delay time.Duration
+}
+
+func (d delayedPublish) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	time.Sleep(d.delay)
+	return d.Interface.Publish(ctx, br, s)
+}
+
+// blockingBuild is like failingBuild, but blocks the builds of the other
+// references until they are cancelled, recording why. The failure waits for
+// the other builds to start.
+type blockingBuild struct {
+	failingBuild
+	started sync.WaitGroup
+
+	mu     sync.Mutex
+	causes map[string]error
+}
+
+func (f *blockingBuild) Build(ctx context.Context, s string) (build.Result, error) {
+	if strings.TrimPrefix(s, build.StrictScheme) == f.ref {
+		f.started.Wait()
+		return f.failingBuild.Build(ctx, s)
+	}
+	f.started.Done()
+	select {
+	case <-ctx.Done():
+	case <-time.After(10 * time.Second):
+		return nil, errors.New("not cancelled")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.causes[s] = context.Cause(ctx)
+	return nil, ctx.Err()
+}
+
+func TestCancellationCause(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		resolve func(context.Context, []*yaml.Node, build.Interface, publish.Interface, ...Option) error
+	}{{
+		name:    "goroutine per reference",
+		resolve: ImageReferences,
+	}, {
+		name:    "worker pool",
+		resolve: ImageReferencesParallel,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			input := fmt.Sprintf("a: ko://%s\nb: ko://%s\nc: ko://%s\n", fooRef, barRef, bazRef)
+			doc := strToYAML(t, input)
+			builder := &blockingBuild{
+				failingBuild: failingBuild{Interface: testBuilder, ref: barRef},
+				causes:       map[string]error{},
+			}
+			builder.started.Add(2)
+			publisher := kotesting.NewFixedPublish(mustRepository("gcr.io/mattmoor"), testHashes)
+
+			err := tc.resolve(context.Background(), []*yaml.Node{doc}, builder, publisher, WithConcurrencyLimit(3))
+			if err == nil || !strings.Contains(err.Error(), "building ko://"+barRef+": compilation failed") {
+				t.Fatalf("ImageReferences() = %v, want the failure of %s", err, barRef)
+			}
+			for _, ref := range []string{"ko://" + fooRef, "ko://" + bazRef} {
+				cause, ok := builder.causes[ref]
+				if !ok {
+					t.Errorf("build of %s was not cancelled", ref)
+					continue
+				}
+				if cause == nil || cause.Error() != err.Error() {
+					t.Errorf("context.Cause() for %s = %v, want %v", ref, cause, err)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveWithWorkersReturnsFailure(t *testing.T) {
+	// Like ImageReferencesParallel, the failing call cancels the context
+	// before returning its error, which gives the goroutine handing out the
+	// remaining references a chance to stop first.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	failure := errors.New("compilation failed")
+	refs := []string{"ko://a", "ko://b", "ko://c", "ko://d"}
+	err := resolveWithWorkers(ctx, refs, 1, func(context.Context, string) error {
+		cancel(failure)
+		time.Sleep(10 * time.Millisecond)
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("resolveWithWorkers() = %v, want %v", err, failure)
+	}
+}
+
+func TestResolveWithWorkersCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	refs := []string{"ko://a", "ko://b", "ko://c", "ko://d"}
+	var resolved []string
+	err := resolveWithWorkers(ctx, refs, 1, func(_ context.Context, ref string) error {
+		resolved = append(resolved, ref)
+		cancel()
+		// Give the goroutine handing out references time to stop.
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("resolveWithWorkers() = %v, want %v", err, context.Canceled)
+	}
+	if len(resolved) != 1 {
+		t.Errorf("resolveWithWorkers() resolved %v after being canceled, want only %s", resolved, refs[0])
+	}
+}
+
+func TestBuildStats(t *testing.T) {
+	const (
+		buildDelay = 20 * time.Millisecond
+		pushDelay  = 40 * time.Millisecond
+		// slack allows for slow test machines.
+		slack = 500 * time.Millisecond
+	)
+	input := fmt.Sprintf("image: ko://%s\nalso: ko://%s\nother: ko://%s\n", fooRef, fooRef, barRef)
+
+	base := mustRepository("gcr.io/mattmoor")
+	doc := strToYAML(t, input)
+	var stats Stats
+	builder := delayedBuild{Interface: testBuilder, delay: buildDelay}
+	publisher := delayedPublish{Interface: kotesting.NewFixedPublish(base, testHashes), delay: pushDelay}
+	if err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, publisher, WithStats(&stats)); err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", input, err)
+	}
+
+	built := map[string]build.Result{"ko://" + fooRef: foo, "ko://" + barRef: bar}
+	var refs []string
+	for _, bs := range stats.Builds {
+		refs = append(refs, bs.Ref)
+		if bs.BuildDuration < buildDelay || bs.BuildDuration > buildDelay+slack {
+			t.Errorf("%s: BuildDuration = %v, want about %v", bs.Ref, bs.BuildDuration, buildDelay)
+		}
+		if bs.PushDuration < pushDelay || bs.PushDuration > pushDelay+slack {
+			t.Errorf("%s: PushDuration = %v, want about %v", bs.Ref, bs.PushDuration, pushDelay)
+		}
+		idx := built[bs.Ref].(v1.ImageIndex)
+		im, err := idx.IndexManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want int64
+		for _, desc := range im.Manifests {
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			layers, err := img.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, layer := range layers {
+				size, err := layer.Size()
+				if err != nil {
+					t.Fatal(err)
+				}
+				want += size
+			}
+		}
+		if bs.CompressedBytes != want {
+			t.Errorf("%s: CompressedBytes = %d, want %d", bs.Ref, bs.CompressedBytes, want)
+		}
+	}
+	if want := []string{"ko://" + barRef, "ko://" + fooRef}; !cmp.Equal(refs, want) {
+		t.Errorf("Builds refs = %v, want %v", refs, want)
+	}
+	// The references are built and published concurrently.
+	if min := buildDelay + pushDelay; stats.TotalDuration < min || stats.TotalDuration > min+slack {
+		t.Errorf("TotalDuration = %v, want about %v", stats.TotalDuration, min)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	input := fmt.Sprintf("image: ko://%s\ndigest: ko://%s?part=digest\nargs:\n    - --image=$(ko://%s)\n", fooRef, barRef, bazRef)
+
+	base := mustRepository("gcr.io/mattmoor")
+	doc := strToYAML(t, input)
+	builder := &countingBuild{Interface: testBuilder}
+	var stats Stats
+	err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, kotesting.NewFixedPublish(base, testHashes), WithDryRun(), WithSubstringMatching(), WithStats(&stats))
+	if err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", input, err)
+	}
+	if diff := cmp.Diff(input, yamlToStr(t, doc)); diff != "" {
+		t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+	}
+	if builder.max.Load() != 0 {
+		t.Error("dry run built references, want none")
+	}
+	if stats.NodesUpdated != 0 {
+		t.Errorf("NodesUpdated = %d, want 0", stats.NodesUpdated)
+	}
+}
+
+func TestDryRunUnsupportedReference(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	doc := strToYAML(t, "image: ko://example.com/unknown\n")
+	err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithDryRun())
+	if err == nil {
+		t.Error("ImageReferences() should err for an unsupported reference, got nil")
+	}
+}
+
 func TestIsSupportedReferenceError(t *testing.T) {
 	ref := build.StrictScheme + fooRef
 
-	buf := bytes.NewBuffer(nil)
-	encoder := yaml.NewEncoder(buf)
-	if err := encoder.Encode(ref); err != nil {
-		t.Fatalf("Encode(%v) = %v", ref, err)
+	buf := bytes.NewBuffer(nil)
+	encoder := yaml.NewEncoder(buf)
+	if err := encoder.Encode(ref); err != nil {
+		t.Fatalf("Encode(%v) = %v", ref, err)
+	}
+
+	base := mustRepository("gcr.io/multi-pass")
+	doc := strToYAML(t, buf.String())
+
+	noMatchBuilder := kotesting.NewFixedBuild(nil)
+
+	err := ImageReferences(context.Background(), []*yaml.Node{doc}, noMatchBuilder, kotesting.NewFixedPublish(base, testHashes))
+	if err == nil {
+		t.Fatal("ImageReferences should err, got nil")
+	}
+}
+
+func TestPartDigest(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	input := map[string]string{"image": build.StrictScheme + fooRef + "?part=digest"}
+	inputYAML, err := yaml.Marshal(input)
+	if err != nil {
+		t.Fatalf("yaml.Marshal(%v) = %v", input, err)
+	}
+
+	doc := strToYAML(t, string(inputYAML))
+	err = ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes))
+	if err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
+	}
+	var outStructured map[string]string
+	if err := doc.Decode(&outStructured); err != nil {
+		t.Errorf("doc.Decode(%v) = %v", yamlToStr(t, doc), err)
+	}
+
+	expected := map[string]string{"image": fooHash.String()}
+	if diff := cmp.Diff(expected, outStructured); diff != "" {
+		t.Errorf("ImageReferences(%v); (-want +got) = %v", string(inputYAML), diff)
+	}
+}
+
+func TestImageRefPart(t *testing.T) {
+	const hash = "sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef"
+	tests := []struct {
+		desc    string
+		ref     string
+		part    string
+		want    string
+		wantErr bool
+	}{{
+		desc: "no part",
+		ref:  "gcr.io/foo/bar@" + hash,
+		want: "gcr.io/foo/bar@" + hash,
+	}, {
+		desc: "digest",
+		ref:  "gcr.io/foo/bar@" + hash,
+		part: "digest",
+		want: hash,
+	}, {
+		desc: "digest of tagged reference",
+		ref:  "gcr.io/foo/bar:v1.2@" + hash,
+		part: "digest",
+		want: hash,
+	}, {
+		desc:    "digest of tag-only reference",
+		ref:     "gcr.io/foo/bar:v1.2",
+		part:    "digest",
+		wantErr: true,
+	}, {
+		desc: "registry hostname",
+		ref:  "gcr.io/foo/bar@" + hash,
+		part: "registryHostname",
+		want: "gcr.io",
+	}, {
+		desc: "registry hostname with port",
+		ref:  "localhost:5000/repo/image@" + hash,
+		part: "registryHostname",
+		want: "localhost",
+	}, {
+		desc: "registry hostname of IPv6 address",
+		ref:  "[::1]:5000/repo/image:v1",
+		part: "registryHostname",
+		want: "::1",
+	}, {
+		desc: "registry hostname of Docker Hub",
+		ref:  "library/busybox:v1",
+		part: "registryHostname",
+		want: "index.docker.io",
+	}, {
+		desc:    "unsupported part",
+		ref:     "gcr.io/foo/bar@" + hash,
+		part:    "bogus",
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ref, err := name.ParseReference(test.ref)
+			if err != nil {
+				t.Fatalf("name.ParseReference(%q) = %v", test.ref, err)
+			}
+			got, err := imageRefPart(ref, test.part)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("imageRefPart(%q, %q) = %v, wantErr %v", test.ref, test.part, err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("imageRefPart(%q, %q) = %q, want %q", test.ref, test.part, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseParts(t *testing.T) {
+	type parseTest struct {
+		desc           string
+		ref            string
+		wantImportPath string
+		wantPart       string
+		wantErr        bool
+	}
+	tests := []parseTest{{
+		desc:           "no part",
+		ref:            "ko://github.com/foo/bar",
+		wantImportPath: "github.com/foo/bar",
+	}, {
+		desc:           "empty part",
+		ref:            "ko://github.com/foo/bar?part=",
+		wantImportPath: "github.com/foo/bar",
+	}, {
+		desc:           "other query parameters",
+		ref:            "ko://github.com/foo/bar?other=value&part=digest",
+		wantImportPath: "github.com/foo/bar",
+		wantPart:       "digest",
+	}, {
+		desc:    "missing scheme",
+		ref:     "github.com/foo/bar?part=digest",
+		wantErr: true,
+	}, {
+		desc:    "missing import path",
+		ref:     "ko://?part=digest",
+		wantErr: true,
+	}, {
+		desc:    "non-ASCII import path",
+		ref:     "ko://github.com/f\u043eo/bar",
+		wantErr: true,
+	}, {
+		desc:    "malformed query",
+		ref:     "ko://github.com/foo/bar?part=%zz",
+		wantErr: true,
+	}, {
+		desc:    "unsupported part",
+		ref:     "ko://github.com/foo/bar?part=bogus",
+		wantErr: true,
+	}, {
+		desc:           "platformDigest",
+		ref:            "ko://github.com/foo/bar?part=platformDigest&platform=linux/arm64",
+		wantImportPath: "github.com/foo/bar",
+		wantPart:       "platformDigest",
+	}, {
+		desc:    "platformDigest without platform",
+		ref:     "ko://github.com/foo/bar?part=platformDigest",
+		wantErr: true,
+	}, {
+		desc:    "platform without platformDigest",
+		ref:     "ko://github.com/foo/bar?part=digest&platform=linux/arm64",
+		wantErr: true,
+	}}
+	for _, part := range []string{"digest", "digestAlgorithm", "digestHex", "shortDigest", "repository", "registryHostname", "fullDigest", "tag", "imageID", "labels"} {
+		tests = append(tests, parseTest{
+			desc:           part,
+			ref:            "ko://github.com/foo/bar?part=" + part,
+			wantImportPath: "github.com/foo/bar",
+			wantPart:       part,
+		})
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			importPath, part, err := ParseParts(test.ref)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseParts(%q) = %v, wantErr %v", test.ref, err, test.wantErr)
+			}
+			if importPath != test.wantImportPath || part != test.wantPart {
+				t.Errorf("ParseParts(%q) = (%q, %q), want (%q, %q)", test.ref, importPath, part, test.wantImportPath, test.wantPart)
+			}
+		})
+	}
+}
+
+func TestImageIDPart(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	id, err := img.ConfigName()
+	if err != nil {
+		t.Fatalf("ConfigName() = %v", err)
+	}
+	base := mustRepository("gcr.io/mattmoor")
+	builder := kotesting.NewFixedBuild(map[string]build.Result{fooRef: img})
+	publisher := kotesting.NewFixedPublish(base, map[string]v1.Hash{fooRef: h})
+
+	input := fmt.Sprintf("id: ko://%s?part=imageID\nimage: ko://%s\n", fooRef, fooRef)
+	doc := strToYAML(t, input)
+	if err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, publisher); err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", input, err)
+	}
+
+	want := fmt.Sprintf("id: %s\nimage: %s\n", id, kotesting.ComputeDigest(base, fooRef, h))
+	if diff := cmp.Diff(want, yamlToStr(t, doc)); diff != "" {
+		t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+	}
+}
+
+func TestImageIDPartOfIndex(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	doc := strToYAML(t, fmt.Sprintf("id: ko://%s?part=imageID\n", fooRef))
+	if err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes)); err == nil {
+		t.Error("ImageReferences() should err for the image ID of an index, got nil")
+	}
+}
+
+func TestLabelsPart(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	img, err = mutate.Config(img, v1.Config{Labels: map[string]string{
+		"team":                             "foo",
+		"org.opencontainers.image.version": "v1.2",
+	}})
+	if err != nil {
+		t.Fatalf("mutate.Config() = %v", err)
+	}
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	base := mustRepository("gcr.io/mattmoor")
+	builder := kotesting.NewFixedBuild(map[string]build.Result{fooRef: img})
+	publisher := kotesting.NewFixedPublish(base, map[string]v1.Hash{fooRef: h})
+
+	input := fmt.Sprintf("labels: ko://%s?part=labels\n", fooRef)
+	doc := strToYAML(t, input)
+	if err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, publisher); err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", input, err)
+	}
+
+	want := "labels: org.opencontainers.image.version=v1.2,team=foo\n"
+	if diff := cmp.Diff(want, yamlToStr(t, doc)); diff != "" {
+		t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+	}
+}
+
+func TestCreatedPart(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		created time.Time
+		want    string
+	}{{
+		name:    "timestamp",
+		created: time.Date(2026, time.March, 4, 5, 6, 7, 0, time.FixedZone("CET", 3600)),
+		want:    "created: \"2026-03-04T04:06:07Z\"\n",
+	}, {
+		name: "reproducible",
+		want: "created: \"0001-01-01T00:00:00Z\"\n",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			img, err := random.Image(1024, 1)
+			if err != nil {
+				t.Fatalf("random.Image() = %v", err)
+			}
+			img, err = mutate.CreatedAt(img, v1.Time{Time: tc.created})
+			if err != nil {
+				t.Fatalf("mutate.CreatedAt() = %v", err)
+			}
+			h, err := img.Digest()
+			if err != nil {
+				t.Fatalf("Digest() = %v", err)
+			}
+			base := mustRepository("gcr.io/mattmoor")
+			builder := kotesting.NewFixedBuild(map[string]build.Result{fooRef: img})
+			publisher := kotesting.NewFixedPublish(base, map[string]v1.Hash{fooRef: h})
+
+			input := fmt.Sprintf("created: ko://%s?part=created\n", fooRef)
+			doc := strToYAML(t, input)
+			if err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, publisher); err != nil {
+				t.Fatalf("ImageReferences(%v) = %v", input, err)
+			}
+			if diff := cmp.Diff(tc.want, yamlToStr(t, doc)); diff != "" {
+				t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+			}
+		})
+	}
+}
+
+func labeledImage(t *testing.T, labels map[string]string) v1.Image {
+	t.Helper()
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	img, err = mutate.Config(img, v1.Config{Labels: labels})
+	if err != nil {
+		t.Fatalf("mutate.Config() = %v", err)
+	}
+	return img
+}
+
+func TestLabelsOf(t *testing.T) {
+	img := labeledImage(t, map[string]string{"a": "1", "b": "2"})
+	labels, err := labelsOf(img)
+	if err != nil {
+		t.Fatalf("labelsOf() = %v", err)
+	}
+	if diff := cmp.Diff(map[string]string{"a": "1", "b": "2"}, labels); diff != "" {
+		t.Errorf("labelsOf() (-want +got) = %s", diff)
+	}
+
+	idx := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: img},
+		mutate.IndexAddendum{Add: labeledImage(t, map[string]string{"a": "1", "b": "3", "c": "4"})},
+	)
+	labels, err = labelsOf(idx)
+	if err != nil {
+		t.Fatalf("labelsOf() = %v", err)
+	}
+	// Only the labels that all images agree on.
+	if diff := cmp.Diff(map[string]string{"a": "1"}, labels); diff != "" {
+		t.Errorf("labelsOf(index) (-want +got) = %s", diff)
+	}
+}
+
+func TestCreatedOf(t *testing.T) {
+	older, newer := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	var imgs []v1.Image
+	for _, created := range []time.Time{older, newer, {}} {
+		img, err := mutate.CreatedAt(labeledImage(t, nil), v1.Time{Time: created})
+		if err != nil {
+			t.Fatalf("mutate.CreatedAt() = %v", err)
+		}
+		imgs = append(imgs, img)
+	}
+
+	created, err := createdOf(imgs[0])
+	if err != nil {
+		t.Fatalf("createdOf() = %v", err)
+	}
+	if !created.Equal(older) {
+		t.Errorf("createdOf() = %v, want %v", created, older)
+	}
+
+	idx := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: imgs[0]},
+		mutate.IndexAddendum{Add: imgs[1]},
+		mutate.IndexAddendum{Add: imgs[2]},
+	)
+	created, err = createdOf(idx)
+	if err != nil {
+		t.Fatalf("createdOf() = %v", err)
+	}
+	// The most recently created image.
+	if !created.Equal(newer) {
+		t.Errorf("createdOf(index) = %v, want %v", created, newer)
+	}
+}
+
+// opaqueResult is a build.Result that is neither an image nor an index.
+type opaqueResult struct {
+	build.Result
+}
+
+func TestPartsOfOpaqueResult(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	builder := kotesting.NewFixedBuild(map[string]build.Result{fooRef: opaqueResult{foo}})
+	publisher := kotesting.NewFixedPublish(base, testHashes)
+
+	// Only the parts requested are read from the build result.
+	input := fmt.Sprintf("image: ko://%s\ndigest: ko://%s?part=digest\n", fooRef, fooRef)
+	doc := strToYAML(t, input)
+	if err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, publisher); err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", input, err)
+	}
+	want := fmt.Sprintf("image: %s\ndigest: %s\n", kotesting.ComputeDigest(base, fooRef, fooHash), fooHash)
+	if diff := cmp.Diff(want, yamlToStr(t, doc)); diff != "" {
+		t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+	}
+
+	for _, part := range []string{"labels", "created"} {
+		doc := strToYAML(t, fmt.Sprintf("%s: ko://%s?part=%s\n", part, fooRef, part))
+		if err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, publisher); err == nil {
+			t.Errorf("ImageReferences() should err for part=%s of an opaque build result, got nil", part)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		inputs []string
+		want   []string
+	}{{
+		name: "no errors",
+		inputs: []string{
+			fmt.Sprintf("image: ko://%s\ndigest: ko://%s?part=digest\n", fooRef, barRef),
+			fmt.Sprintf("- ko://%s\n- not a reference\n", bazRef),
+		},
+	}, {
+		name: "one error",
+		inputs: []string{
+			fmt.Sprintf("image: ko://%s\nother: ko://github.com/awesomesauce/missing\n", fooRef),
+		},
+		want: []string{
+			"document 0, line 2: ko://github.com/awesomesauce/missing is not a valid import path",
+		},
+	}, {
+		name: "errors across documents",
+		inputs: []string{
+			"image: ko://github.com/awesomesauce/missing\n",
+			fmt.Sprintf("image: ko://%s\n", fooRef),
+			fmt.Sprintf("a: ko://%s?part=nope\nb: ko://\n", barRef),
+		},
+		want: []string{
+			"document 0, line 1: ko://github.com/awesomesauce/missing is not a valid import path",
+			`document 2, line 1: unsupported part "nope"`,
+			`document 2, line 2: "ko://" has no import path`,
+		},
+	}, {
+		name: "non-ASCII import path",
+		inputs: []string{
+			"image: ko://github.com/g\u043eogle/ko/test\n",
+		},
+		want: []string{
+			`document 0, line 1: import path of "ko://github.com/g\u043eogle/ko/test" contains non-ASCII character '\u043e' (U+043E) at offset 12`,
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var docs []*yaml.Node
+			for _, input := range tc.inputs {
+				docs = append(docs, strToYAML(t, input))
+			}
+
+			errs := Validate(context.Background(), docs, testBuilder)
+			if len(errs) != len(tc.want) {
+				t.Fatalf("Validate() = %v, want %d errors", errs, len(tc.want))
+			}
+			for i, want := range tc.want {
+				if !strings.Contains(errs[i].Error(), want) {
+					t.Errorf("Validate()[%d] = %v, want error containing %q", i, errs[i], want)
+				}
+			}
+
+			// The documents are left alone.
+			for i, input := range tc.inputs {
+				if diff := cmp.Diff(input, yamlToStr(t, docs[i])); diff != "" {
+					t.Errorf("Validate() mutated document %d; (-want +got) = %v", i, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestRefsFromDoc(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  []string
+	}{{
+		name: "empty document",
+	}, {
+		name:  "no refs",
+		input: "image: gcr.io/foo/bar\nargs:\n- ko\n- not ko://\n",
+	}, {
+		name: "duplicate refs",
+		input: fmt.Sprintf("image: ko://%s\nsidecar: ko://%s\ndigest: ko://%s?part=digest\nalso: ' ko://%s'\n",
+			fooRef, barRef, fooRef, barRef),
+		want: []string{"ko://" + fooRef, "ko://" + barRef},
+	}, {
+		name:  "malformed refs are skipped",
+		input: fmt.Sprintf("- ko://\n- ko://%s?part=nope\n- ko://%s?part=tag\n", fooRef, bazRef),
+		want:  []string{"ko://" + bazRef},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(tc.input), &doc); err != nil {
+				t.Fatalf("yaml.Unmarshal() = %v", err)
+			}
+			if diff := cmp.Diff(tc.want, RefsFromDoc(&doc)); diff != "" {
+				t.Errorf("RefsFromDoc() (-want +got) = %v", diff)
+			}
+		})
+	}
+
+	if got := RefsFromDoc(nil); got != nil {
+		t.Errorf("RefsFromDoc(nil) = %v, want nil", got)
+	}
+}
+
+func TestExtractRefs(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input string
+		want  []string
+	}{{
+		name: "empty input",
+	}, {
+		name:  "no refs",
+		input: "image: gcr.io/foo/bar\n---\nargs:\n- ko\n",
+	}, {
+		name: "multiple documents",
+		input: fmt.Sprintf("image: ko://%s\n---\nimage: ko://%s?part=digest\nsidecar: ko://%s\n---\n---\n- ko://%s\n",
+			fooRef, fooRef, bazRef, barRef),
+		want: []string{"ko://" + barRef, "ko://" + bazRef, "ko://" + fooRef},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ExtractRefs([]byte(tc.input))
+			if err != nil {
+				t.Fatalf("ExtractRefs() = %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ExtractRefs() (-want +got) = %v", diff)
+			}
+		})
+	}
+
+	if _, err := ExtractRefs([]byte("image: [ko://foo\n")); err == nil {
+		t.Error("ExtractRefs() of malformed YAML = nil, want error")
+	}
+}
+
+func TestReplaceRefs(t *testing.T) {
+	pinned := map[string]string{
+		"ko://" + fooRef: "gcr.io/foo@" + fooHash.String(),
+		"ko://" + barRef: "gcr.io/bar:v1",
+	}
+	for _, tc := range []struct {
+		name      string
+		input     string
+		want      string
+		wantCalls []string
+		wantErr   string
+	}{{
+		name: "empty input",
+	}, {
+		name:  "no refs",
+		input: "image: gcr.io/foo/bar\n",
+		want:  "image: gcr.io/foo/bar\n",
+	}, {
+		name: "multiple documents",
+		input: fmt.Sprintf("image: ko://%s\ndigest: ko://%s?part=digest\n---\n- ko://%s?part=tag\n- ko://%s?part=none\n- '$(ko://%s)'\n",
+			fooRef, fooRef, barRef, fooRef, barRef),
+		want: fmt.Sprintf("image: gcr.io/foo@%s\ndigest: %s\n---\n- v1\n- ko://%s?part=none\n- '$(ko://%s)'\n",
+			fooHash, fooHash, fooRef, barRef),
+		wantCalls: []string{"ko://" + barRef, "ko://" + fooRef},
+	}, {
+		name:    "part of the image",
+		input:   fmt.Sprintf("id: ko://%s?part=imageID\n", fooRef),
+		wantErr: `unsupported part "imageID"`,
+	}, {
+		name:    "replacer fails",
+		input:   fmt.Sprintf("image: ko://%s\n", bazRef),
+		wantErr: "replacing ko://" + bazRef + ": not pinned",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls []string
+			got, err := ReplaceRefs([]byte(tc.input), func(ref string) (string, error) {
+				calls = append(calls, ref)
+				value, ok := pinned[ref]
+				if !ok {
+					return "", errors.New("not pinned")
+				}
+				return value, nil
+			})
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("ReplaceRefs() = %v, want error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReplaceRefs() = %v", err)
+			}
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("ReplaceRefs() (-want +got) = %v", diff)
+			}
+			if diff := cmp.Diff(tc.wantCalls, calls); diff != "" {
+				t.Errorf("replacer calls (-want +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestDocsFromBytes(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr error
+	}{{
+		name:    "empty input",
+		wantErr: ErrNoDocuments,
+	}, {
+		name:    "only a comment",
+		input:   "# nothing to see here\n",
+		wantErr: ErrNoDocuments,
+	}, {
+		name:  "single document",
+		input: "image: ko://github.com/foo/bar\n",
+		want:  []string{"image: ko://github.com/foo/bar\n"},
+	}, {
+		name:  "multiple documents",
+		input: "a: 1\n---\nb: 2\n---\n- c\n",
+		want:  []string{"a: 1\n", "b: 2\n", "- c\n"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			docs, err := DocsFromBytes([]byte(tc.input))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("DocsFromBytes() = %v, want %v", err, tc.wantErr)
+			}
+			got := make([]string, 0, len(docs))
+			for _, doc := range docs {
+				got = append(got, yamlToStr(t, doc))
+			}
+			if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("DocsFromBytes() (-want +got) = %v", diff)
+			}
+		})
+	}
+
+	if _, err := DocsFromBytes([]byte("a: 1\n---\nb: [\n")); err == nil || !strings.Contains(err.Error(), "document 1") {
+		t.Errorf("DocsFromBytes() = %v, want an error for document 1", err)
+	}
+}
+
+func TestDocsToBytes(t *testing.T) {
+	input := `# The first document.
+apiVersion: v1
+kind: Pod
+metadata:
+  name: "quoted" # a line comment
+  labels: {app: foo, tier: 'web'}
+spec:
+  containers:
+    - name: app
+      image: ko://` + fooRef + `
+      args: [--verbose, --port=8080]
+      command:
+        - |
+          literal
+          block
+---
+# The second document.
+kind: ConfigMap
+data:
+  image: ko://` + barRef + `?part=digest
+---
+untouched: true
+`
+	docs, err := DocsFromBytes([]byte(input))
+	if err != nil {
+		t.Fatalf("DocsFromBytes() = %v", err)
+	}
+	base := mustRepository("gcr.io/mattmoor")
+	if err := ImageReferences(context.Background(), docs, testBuilder, kotesting.NewFixedPublish(base, testHashes)); err != nil {
+		t.Fatalf("ImageReferences() = %v", err)
+	}
+
+	got, err := DocsToBytes(docs)
+	if err != nil {
+		t.Fatalf("DocsToBytes() = %v", err)
+	}
+	// Only the references change.
+	want := strings.NewReplacer(
+		"ko://"+fooRef, kotesting.ComputeDigest(base, fooRef, fooHash),
+		"ko://"+barRef+"?part=digest", barHash.String(),
+	).Replace(input)
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("DocsToBytes() (-want +got) = %s", diff)
+	}
+
+	got, err = DocToBytes(docs[2])
+	if err != nil {
+		t.Fatalf("DocToBytes() = %v", err)
+	}
+	if diff := cmp.Diff("untouched: true\n", string(got)); diff != "" {
+		t.Errorf("DocToBytes() (-want +got) = %s", diff)
+	}
+}
+
+func TestDocFromBytes(t *testing.T) {
+	doc, err := DocFromBytes([]byte("image: ko://github.com/foo/bar\n"))
+	if err != nil {
+		t.Fatalf("DocFromBytes() = %v", err)
+	}
+	if diff := cmp.Diff("image: ko://github.com/foo/bar\n", yamlToStr(t, doc)); diff != "" {
+		t.Errorf("DocFromBytes() (-want +got) = %v", diff)
+	}
+
+	if _, err := DocFromBytes(nil); !errors.Is(err, ErrNoDocuments) {
+		t.Errorf("DocFromBytes(nil) = %v, want %v", err, ErrNoDocuments)
+	}
+	if _, err := DocFromBytes([]byte("a: 1\n---\nb: 2\n")); err == nil {
+		t.Error("DocFromBytes() of two documents = nil, want an error")
+	}
+}
+
+func TestBuildManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.jsonl")
+	base := mustRepository("gcr.io/multi-pass")
+
+	inputs := []string{
+		fmt.Sprintf("a: ko://%s\nb: ko://%s?part=digest\n", fooRef, barRef),
+		fmt.Sprintf("c: ko://%s\n", fooRef),
+	}
+	want := []string{
+		fmt.Sprintf("a: %s\nb: %s\n", kotesting.ComputeDigest(base, fooRef, fooHash), barHash),
+		fmt.Sprintf("c: %s\n", kotesting.ComputeDigest(base, fooRef, fooHash)),
+	}
+	// Each call appends to the manifest.
+	for i, input := range inputs {
+		doc := strToYAML(t, input)
+		if err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithBuildManifest(path)); err != nil {
+			t.Fatalf("ImageReferences(%v) = %v", input, err)
+		}
+		// The YAML output is unaffected.
+		if diff := cmp.Diff(want[i], yamlToStr(t, doc)); diff != "" {
+			t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []manifestRecord
+	for s := bufio.NewScanner(f); s.Scan(); {
+		var r manifestRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = %v", s.Text(), err)
+		}
+		if _, err := time.Parse(time.RFC3339, r.Timestamp); err != nil {
+			t.Errorf("timestamp of %s: %v", r.Ref, err)
+		}
+		r.Timestamp = ""
+		got = append(got, r)
+	}
+	wantRecords := []manifestRecord{
+		{Ref: build.StrictScheme + barRef, Digest: barHash.String()},
+		{Ref: build.StrictScheme + fooRef, Digest: fooHash.String()},
+		{Ref: build.StrictScheme + fooRef, Digest: fooHash.String()},
+	}
+	if diff := cmp.Diff(wantRecords, got); diff != "" {
+		t.Errorf("build manifest (-want +got) = %v", diff)
+	}
+}
+
+func TestBuildManifestError(t *testing.T) {
+	// A directory can't be appended to.
+	path := t.TempDir()
+	base := mustRepository("gcr.io/multi-pass")
+	doc := strToYAML(t, fmt.Sprintf("a: ko://%s\n", fooRef))
+	buf := bytes.NewBuffer(nil)
+	logger := slog.New(slog.NewTextHandler(buf, nil))
+	if err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithBuildManifest(path), WithLogger(logger)); err != nil {
+		t.Fatalf("ImageReferences() = %v, want the build manifest not to fail the resolution", err)
+	}
+	if want := "a: " + kotesting.ComputeDigest(base, fooRef, fooHash) + "\n"; yamlToStr(t, doc) != want {
+		t.Errorf("ImageReferences() = %q, want %q", yamlToStr(t, doc), want)
+	}
+	if !strings.Contains(buf.String(), "failed to write build manifest") {
+		t.Errorf("log output = %q, want a warning about the build manifest", buf.String())
+	}
+}
+
+// sizingPublish is a publish.Interface that also implements publish.Sizer.
+type sizingPublish struct {
+	publish.Interface
+	sizes map[string]int64
+}
+
+func (s sizingPublish) ImageSize(ref string) (int64, error) {
+	size, ok := s.sizes[strings.TrimPrefix(ref, build.StrictScheme)]
+	if !ok {
+		return 0, fmt.Errorf("unknown reference %s", ref)
+	}
+	return size, nil
+}
+
+func TestSizePart(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	input := fmt.Sprintf("size: ko://%s?part=size\n", fooRef)
+
+	t.Run("sizer", func(t *testing.T) {
+		const size int64 = 31415926
+		publisher := sizingPublish{
+			Interface: kotesting.NewFixedPublish(base, testHashes),
+			sizes:     map[string]int64{fooRef: size},
+		}
+		doc := strToYAML(t, input)
+		if err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, publisher); err != nil {
+			t.Fatalf("ImageReferences(%v) = %v", input, err)
+		}
+		want := "size: \"" + strconv.FormatInt(size, 10) + "\"\n"
+		if diff := cmp.Diff(want, yamlToStr(t, doc)); diff != "" {
+			t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+		}
+	})
+
+	t.Run("from build", func(t *testing.T) {
+		img, err := random.Image(1024, 3)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		h, err := img.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		layers, err := img.Layers()
+		if err != nil {
+			t.Fatalf("Layers() = %v", err)
+		}
+		var size int64
+		for _, layer := range layers {
+			s, err := layer.Size()
+			if err != nil {
+				t.Fatalf("Size() = %v", err)
+			}
+			size += s
+		}
+		builder := kotesting.NewFixedBuild(map[string]build.Result{fooRef: img})
+		publisher := kotesting.NewFixedPublish(base, map[string]v1.Hash{fooRef: h})
+
+		doc := strToYAML(t, input)
+		if err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, publisher); err != nil {
+			t.Fatalf("ImageReferences(%v) = %v", input, err)
+		}
+		want := "size: \"" + strconv.FormatInt(size, 10) + "\"\n"
+		if diff := cmp.Diff(want, yamlToStr(t, doc)); diff != "" {
+			t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+		}
+	})
+
+	t.Run("index without sizer", func(t *testing.T) {
+		doc := strToYAML(t, input)
+		err := ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes))
+		if err == nil || !strings.Contains(err.Error(), "not supported for multi-platform images") {
+			t.Errorf("ImageReferences() = %v, want an error for multi-platform images", err)
+		}
+	})
+}
+
+func TestPlatformDigestPart(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	amd64, arm64 := mustRandom(), mustRandom()
+	idx := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add:        amd64,
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+	}, mutate.IndexAddendum{
+		Add:        arm64,
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+	})
+	builder := kotesting.NewFixedBuild(map[string]build.Result{fooRef: idx})
+	publisher := kotesting.NewFixedPublish(base, map[string]v1.Hash{fooRef: mustDigest(idx)})
+
+	for _, tc := range []struct {
+		platform string
+		want     v1.Hash
+		wantErr  string
+	}{{
+		platform: "linux/amd64",
+		want:     mustDigest(amd64),
+	}, {
+		platform: "linux/arm64",
+		want:     mustDigest(arm64),
+	}, {
+		platform: "linux/arm64/v8",
+		want:     mustDigest(arm64),
+	}, {
+		platform: "linux/s390x",
+		wantErr:  "platform linux/s390x was not built",
+	}} {
+		t.Run(tc.platform, func(t *testing.T) {
+			input := fmt.Sprintf("image: ko://%s?part=platformDigest&platform=%s\n", fooRef, tc.platform)
+			doc := strToYAML(t, input)
+			err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, publisher)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("ImageReferences() = %v, want error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ImageReferences(%v) = %v", input, err)
+			}
+			want := "image: " + tc.want.String() + "\n"
+			if diff := cmp.Diff(want, yamlToStr(t, doc)); diff != "" {
+				t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+			}
+		})
 	}
+}
 
-	base := mustRepository("gcr.io/multi-pass")
-	doc := strToYAML(t, buf.String())
+func TestManifestMediaTypePart(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	for _, mt := range []types.MediaType{types.OCIManifestSchema1, types.DockerManifestSchema2} {
+		t.Run(string(mt), func(t *testing.T) {
+			img, err := random.Image(1024, 1)
+			if err != nil {
+				t.Fatalf("random.Image() = %v", err)
+			}
+			img = mutate.MediaType(img, mt)
+			builder := kotesting.NewFixedBuild(map[string]build.Result{fooRef: img})
+			publisher := kotesting.NewFixedPublish(base, map[string]v1.Hash{fooRef: mustDigest(img)})
 
-	noMatchBuilder := kotesting.NewFixedBuild(nil)
+			input := fmt.Sprintf("mediaType: ko://%s?part=manifestMediaType\n", fooRef)
+			doc := strToYAML(t, input)
+			if err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, publisher); err != nil {
+				t.Fatalf("ImageReferences(%v) = %v", input, err)
+			}
+			want := "mediaType: " + string(mt) + "\n"
+			if diff := cmp.Diff(want, yamlToStr(t, doc)); diff != "" {
+				t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+			}
+		})
+	}
+}
 
-	err := ImageReferences(context.Background(), []*yaml.Node{doc}, noMatchBuilder, kotesting.NewFixedPublish(base, testHashes))
+func TestIndexDigestPart(t *testing.T) {
+	base := mustRepository("gcr.io/mattmoor")
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	for _, tc := range []struct {
+		name  string
+		built build.Result
+	}{{
+		name:  "index",
+		built: foo,
+	}, {
+		name:  "image",
+		built: img,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := kotesting.NewFixedBuild(map[string]build.Result{fooRef: tc.built})
+			publisher := kotesting.NewFixedPublish(base, map[string]v1.Hash{fooRef: mustDigest(tc.built)})
+
+			input := fmt.Sprintf("index: ko://%s?part=indexDigest\ndigest: ko://%s?part=digest\n", fooRef, fooRef)
+			doc := strToYAML(t, input)
+			if err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, publisher); err != nil {
+				t.Fatalf("ImageReferences(%v) = %v", input, err)
+			}
+			digest := mustDigest(tc.built).String()
+			want := "index: " + digest + "\ndigest: " + digest + "\n"
+			if diff := cmp.Diff(want, yamlToStr(t, doc)); diff != "" {
+				t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+			}
+		})
+	}
+}
+
+// recordingBuild and recordingPublish record the references they are asked to
+// build or publish.
+type recordingBuild struct {
+	build.Interface
+	mu   sync.Mutex
+	refs []string
+}
+
+func (r *recordingBuild) Build(ctx context.Context, s string) (build.Result, error) {
+	r.mu.Lock()
+	r.refs = append(r.refs, s)
+	r.mu.Unlock()
+	return r.Interface.Build(ctx, s)
+}
+
+type recordingPublish struct {
+	publish.Interface
+	mu   sync.Mutex
+	refs []string
+}
+
+func (r *recordingPublish) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	r.mu.Lock()
+	r.refs = append(r.refs, s)
+	r.mu.Unlock()
+	return r.Interface.Publish(ctx, br, s)
+}
+
+func TestNonePart(t *testing.T) {
+	input := fmt.Sprintf("image: ko://%s?part=none\nargs:\n    - --image=$(ko://%s?part=none)\nconfig.json: '{\"image\":\"ko://%s?part=none\"}'\n", fooRef, barRef, bazRef)
+
+	base := mustRepository("gcr.io/mattmoor")
+	doc := strToYAML(t, input)
+	builder := &recordingBuild{Interface: testBuilder}
+	publisher := &recordingPublish{Interface: kotesting.NewFixedPublish(base, testHashes)}
+	var stats Stats
+	if err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, publisher,
+		WithSubstringMatching(), WithJSONStringExpansion(), WithStats(&stats)); err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", input, err)
+	}
+	if diff := cmp.Diff(input, yamlToStr(t, doc)); diff != "" {
+		t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+	}
+	if stats.NodesUpdated != 0 {
+		t.Errorf("NodesUpdated = %d, want 0", stats.NodesUpdated)
+	}
+
+	want := []string{"ko://" + barRef, "ko://" + bazRef, "ko://" + fooRef}
+	sortStrings := cmpopts.SortSlices(func(a, b string) bool { return a < b })
+	if diff := cmp.Diff(want, builder.refs, sortStrings); diff != "" {
+		t.Errorf("built references; (-want +got) = %v", diff)
+	}
+	if diff := cmp.Diff(want, publisher.refs, sortStrings); diff != "" {
+		t.Errorf("published references; (-want +got) = %v", diff)
+	}
+}
+
+func TestSameImportPathBuiltOnce(t *testing.T) {
+	input := fmt.Sprintf("tag: ko://%s?part=tag\ndigest: ko://%s?part=digest\nimage: ko://%s\nargs:\n    - --image=$(ko://%s?part=repository)\nconfig.json: '{\"image\":\"ko://%s?part=digest\"}'\n",
+		fooRef, fooRef, fooRef, fooRef, fooRef)
+
+	base := mustRepository("gcr.io/mattmoor")
+	doc := strToYAML(t, input)
+	builder := &recordingBuild{Interface: testBuilder}
+	publisher := &recordingPublish{Interface: kotesting.NewFixedPublish(base, testHashes)}
+	if err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, publisher,
+		WithSubstringMatching(), WithJSONStringExpansion()); err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", input, err)
+	}
+
+	image := kotesting.ComputeDigest(base, fooRef, fooHash)
+	repository, _, _ := strings.Cut(image, "@")
+	want := fmt.Sprintf("tag: latest\ndigest: %s\nimage: %s\nargs:\n    - --image=%s\nconfig.json: '{\"image\":\"%s\"}'\n",
+		fooHash, image, repository, fooHash)
+	if diff := cmp.Diff(want, yamlToStr(t, doc)); diff != "" {
+		t.Errorf("ImageReferences(%v); (-want +got) = %v", input, diff)
+	}
+
+	// Each part is taken from the same published image.
+	refs := []string{"ko://" + fooRef}
+	if diff := cmp.Diff(refs, builder.refs); diff != "" {
+		t.Errorf("built references; (-want +got) = %v", diff)
+	}
+	if diff := cmp.Diff(refs, publisher.refs); diff != "" {
+		t.Errorf("published references; (-want +got) = %v", diff)
+	}
+}
+
+// failingBuild is a build.Interface that fails to build a particular reference.
+type failingBuild struct {
+	build.Interface
+	ref string
+}
+
+func (f failingBuild) Build(ctx context.Context, s string) (build.Result, error) {
+	if strings.TrimPrefix(s, build.StrictScheme) == f.ref {
+		return nil, errors.New("compilation failed")
+	}
+	return f.Interface.Build(ctx, s)
+}
+
+func TestBuildErrorIncludesReference(t *testing.T) {
+	input := map[string]string{
+		"arg1": build.StrictScheme + fooRef,
+		"arg2": build.StrictScheme + barRef,
+	}
+	inputYAML, err := yaml.Marshal(input)
+	if err != nil {
+		t.Fatalf("yaml.Marshal(%v) = %v", input, err)
+	}
+
+	base := mustRepository("gcr.io/multi-pass")
+	doc := strToYAML(t, string(inputYAML))
+	builder := failingBuild{Interface: testBuilder, ref: barRef}
+
+	err = ImageReferences(context.Background(), []*yaml.Node{doc}, builder, kotesting.NewFixedPublish(base, testHashes))
 	if err == nil {
 		t.Fatal("ImageReferences should err, got nil")
 	}
+	if !strings.Contains(err.Error(), barRef) {
+		t.Errorf("ImageReferences() = %v, want error containing %q", err, barRef)
+	}
+}
+
+// countingBuild is a build.Interface that records the maximum number of
+// concurrent builds.
+type countingBuild struct {
+	build.Interface
+	current, max atomic.Int32
+}
+
+func (c *countingBuild) Build(ctx context.Context, s string) (build.Result, error) {
+	n := c.current.Add(1)
+	defer c.current.Add(-1)
+	for {
+		m := c.max.Load()
+		if n <= m || c.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return c.Interface.Build(ctx, s)
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	input := map[string]string{
+		"arg1": build.StrictScheme + fooRef,
+		"arg2": build.StrictScheme + barRef,
+		"arg3": build.StrictScheme + bazRef,
+	}
+	inputYAML, err := yaml.Marshal(input)
+	if err != nil {
+		t.Fatalf("yaml.Marshal(%v) = %v", input, err)
+	}
+
+	base := mustRepository("gcr.io/multi-pass")
+	doc := strToYAML(t, string(inputYAML))
+	builder := &countingBuild{Interface: testBuilder}
+
+	err = ImageReferences(context.Background(), []*yaml.Node{doc}, builder, kotesting.NewFixedPublish(base, testHashes), WithConcurrencyLimit(1))
+	if err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
+	}
+	if got := builder.max.Load(); got != 1 {
+		t.Errorf("max concurrent builds = %d, want 1", got)
+	}
+}
+
+func TestImageReferencesParallel(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	input := fmt.Sprintf("a: ko://%s\nb: ko://%s?part=digest\nc: ko://%s\nd: ko://%s\n", fooRef, barRef, bazRef, fooRef)
+	want := fmt.Sprintf("a: %s\nb: %s\nc: %s\nd: %s\n",
+		kotesting.ComputeDigest(base, fooRef, fooHash), barHash,
+		kotesting.ComputeDigest(base, bazRef, bazHash), kotesting.ComputeDigest(base, fooRef, fooHash))
+
+	for _, workers := range []int{0, 1, 2} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			doc := strToYAML(t, input)
+			builder := &countingBuild{Interface: testBuilder}
+			err := ImageReferencesParallel(context.Background(), []*yaml.Node{doc}, builder, kotesting.NewFixedPublish(base, testHashes), WithConcurrencyLimit(workers))
+			if err != nil {
+				t.Fatalf("ImageReferencesParallel(%v) = %v", input, err)
+			}
+			if diff := cmp.Diff(want, yamlToStr(t, doc)); diff != "" {
+				t.Errorf("ImageReferencesParallel(%v); (-want +got) = %v", input, diff)
+			}
+			if got := builder.max.Load(); workers > 0 && got > int32(workers) {
+				t.Errorf("max concurrent builds = %d, want at most %d", got, workers)
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		doc := strToYAML(t, input)
+		builder := failingBuild{Interface: testBuilder, ref: barRef}
+		err := ImageReferencesParallel(context.Background(), []*yaml.Node{doc}, builder, kotesting.NewFixedPublish(base, testHashes), WithConcurrencyLimit(1))
+		if err == nil || !strings.Contains(err.Error(), barRef) {
+			t.Errorf("ImageReferencesParallel() = %v, want error containing %q", err, barRef)
+		}
+	})
+}
+
+// sleepyBuild is a build.Interface that supports every reference and takes a
+// millisecond to build each, recording the maximum number of concurrent
+// builds like countingBuild.
+type sleepyBuild struct {
+	current, max atomic.Int32
+}
+
+func (*sleepyBuild) QualifyImport(s string) (string, error) { return s, nil }
+
+func (*sleepyBuild) IsSupportedReference(string) error { return nil }
+
+func (s *sleepyBuild) Build(ctx context.Context, ref string) (build.Result, error) {
+	n := s.current.Add(1)
+	defer s.current.Add(-1)
+	for {
+		m := s.max.Load()
+		if n <= m || s.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	return foo, nil
+}
+
+func BenchmarkImageReferencesParallel(b *testing.B) {
+	const n = 200
+	var sb strings.Builder
+	hashes := make(map[string]v1.Hash, n)
+	for i := 0; i < n; i++ {
+		ref := fmt.Sprintf("example.com/svc/cmd/svc%d", i)
+		fmt.Fprintf(&sb, "- ko://%s\n", ref)
+		hashes[ref] = fooHash
+	}
+	input := sb.String()
+	publisher := kotesting.NewFixedPublish(mustRepository("gcr.io/mattmoor"), hashes)
+
+	for _, bc := range []struct {
+		name string
+		f    func(context.Context, []*yaml.Node, build.Interface, publish.Interface, ...Option) error
+	}{
+		{name: "goroutine per ref", f: ImageReferences},
+		{name: "worker pool", f: ImageReferencesParallel},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			builder := &sleepyBuild{}
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				var doc yaml.Node
+				if err := yaml.Unmarshal([]byte(input), &doc); err != nil {
+					b.Fatalf("yaml.Unmarshal() = %v", err)
+				}
+				b.StartTimer()
+				if err := bc.f(context.Background(), []*yaml.Node{&doc}, builder, publisher); err != nil {
+					b.Fatalf("ImageReferences() = %v", err)
+				}
+			}
+			// The number of builds, and so goroutines, in flight at once.
+			b.ReportMetric(float64(builder.max.Load()), "max-concurrent-builds")
+		})
+	}
+}
+
+func TestLogger(t *testing.T) {
+	input := map[string]string{"image": build.StrictScheme + fooRef}
+	inputYAML, err := yaml.Marshal(input)
+	if err != nil {
+		t.Fatalf("yaml.Marshal(%v) = %v", input, err)
+	}
+
+	base := mustRepository("gcr.io/mattmoor")
+	doc := strToYAML(t, string(inputYAML))
+	buf := bytes.NewBuffer(nil)
+	logger := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	err = ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
+	}
+
+	for _, want := range []string{
+		"found reference",
+		"resolved reference",
+		kotesting.ComputeDigest(base, fooRef, fooHash),
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log output = %q, want it to contain %q", buf.String(), want)
+		}
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	input := map[string]string{"image": build.StrictScheme + fooRef}
+	inputYAML, err := yaml.Marshal(input)
+	if err != nil {
+		t.Fatalf("yaml.Marshal(%v) = %v", input, err)
+	}
+
+	base := mustRepository("gcr.io/mattmoor")
+	doc := strToYAML(t, string(inputYAML))
+	err = ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithLogger(nil))
+	if err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
+	}
+}
+
+func TestImageReferencesWithContext(t *testing.T) {
+	input := fmt.Sprintf("image: %s%s\n", build.StrictScheme, fooRef)
+	base := mustRepository("gcr.io/mattmoor")
+
+	newLogger := func() (*slog.Logger, *bytes.Buffer) {
+		buf := bytes.NewBuffer(nil)
+		return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})), buf
+	}
+
+	t.Run("logger in context", func(t *testing.T) {
+		logger, buf := newLogger()
+		ctx := NewContext(context.Background(), logger.With("request", "1234"))
+		doc := strToYAML(t, input)
+		if err := ImageReferencesWithContext(ctx, []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes)); err != nil {
+			t.Fatalf("ImageReferencesWithContext() = %v", err)
+		}
+		for _, want := range []string{"resolved reference", "request=1234"} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("log output = %q, want it to contain %q", buf.String(), want)
+			}
+		}
+	})
+
+	t.Run("default logger", func(t *testing.T) {
+		logger, buf := newLogger()
+		defer slog.SetDefault(slog.Default())
+		slog.SetDefault(logger)
+		doc := strToYAML(t, input)
+		if err := ImageReferencesWithContext(context.Background(), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes)); err != nil {
+			t.Fatalf("ImageReferencesWithContext() = %v", err)
+		}
+		if !strings.Contains(buf.String(), "resolved reference") {
+			t.Errorf("log output = %q, want it to contain %q", buf.String(), "resolved reference")
+		}
+	})
+
+	t.Run("option wins", func(t *testing.T) {
+		ctxLogger, ctxBuf := newLogger()
+		logger, buf := newLogger()
+		doc := strToYAML(t, input)
+		if err := ImageReferencesWithContext(NewContext(context.Background(), ctxLogger), []*yaml.Node{doc}, testBuilder, kotesting.NewFixedPublish(base, testHashes), WithLogger(logger)); err != nil {
+			t.Fatalf("ImageReferencesWithContext() = %v", err)
+		}
+		if ctxBuf.Len() != 0 || buf.Len() == 0 {
+			t.Errorf("got %q logged to the context logger and %q to the option logger, want only the latter", ctxBuf.String(), buf.String())
+		}
+	})
+}
+
+// rawRef is a name.Reference that is not validated, since name.NewDigest only
+// accepts well-formed sha256 digests.
+type rawRef string
+
+func (r rawRef) Context() name.Repository { return name.Repository{} }
+func (r rawRef) Identifier() string       { _, id, _ := strings.Cut(string(r), "@"); return id }
+func (r rawRef) Name() string             { return string(r) }
+func (r rawRef) String() string           { return string(r) }
+func (r rawRef) Scope(string) string      { return "" }
+
+func TestDigestAlgorithmPart(t *testing.T) {
+	tests := []struct {
+		desc string
+		ref  name.Reference
+		want string
+	}{{
+		desc: "sha256",
+		ref:  rawRef("gcr.io/foo/bar@sha256:" + strings.Repeat("deadbeef", 8)),
+		want: "sha256",
+	}, {
+		desc: "sha512",
+		ref:  rawRef("gcr.io/foo/bar@sha512:" + strings.Repeat("deadbeef", 16)),
+		want: "sha512",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := imageRefPart(test.ref, "digestAlgorithm")
+			if err != nil {
+				t.Fatalf("imageRefPart(%v, digestAlgorithm) = %v", test.ref, err)
+			}
+			if got != test.want {
+				t.Errorf("imageRefPart(%v, digestAlgorithm) = %q, want %q", test.ref, got, test.want)
+			}
+		})
+	}
 }
 
 func mustRandom() build.Result {
@@ -315,3 +2392,199 @@ func mustDigest(img build.Result) v1.Hash {
 	}
 	return d
 }
+
+func TestDigestHexPart(t *testing.T) {
+	for _, digest := range []string{
+		"sha256:" + strings.Repeat("deadbeef", 8),
+		"sha512:" + strings.Repeat("deadbeef", 16),
+	} {
+		t.Run(digest, func(t *testing.T) {
+			ref := rawRef("gcr.io/foo/bar@" + digest)
+			algorithm, err := imageRefPart(ref, "digestAlgorithm")
+			if err != nil {
+				t.Fatalf("imageRefPart(%v, digestAlgorithm) = %v", ref, err)
+			}
+			hex, err := imageRefPart(ref, "digestHex")
+			if err != nil {
+				t.Fatalf("imageRefPart(%v, digestHex) = %v", ref, err)
+			}
+			if strings.Contains(hex, ":") {
+				t.Errorf("imageRefPart(%v, digestHex) = %q, want no algorithm", ref, hex)
+			}
+			if got := algorithm + ":" + hex; got != digest {
+				t.Errorf("digestAlgorithm:digestHex = %q, want %q", got, digest)
+			}
+		})
+	}
+
+	if _, err := imageRefPart(rawRef("gcr.io/foo/bar:v1.2"), "digestHex"); err == nil {
+		t.Error("imageRefPart(gcr.io/foo/bar:v1.2, digestHex) should err, got nil")
+	}
+}
+
+func TestShortDigestPart(t *testing.T) {
+	for _, test := range []struct {
+		desc string
+		ref  rawRef
+		want string
+	}{{
+		desc: "sha256",
+		ref:  rawRef("gcr.io/foo/bar@sha256:" + strings.Repeat("0123456789abcdef", 4)),
+		want: "0123456789ab",
+	}, {
+		desc: "sha512",
+		ref:  rawRef("gcr.io/foo/bar@sha512:" + strings.Repeat("fedcba9876543210", 8)),
+		want: "fedcba987654",
+	}, {
+		desc: "tagged",
+		ref:  rawRef("gcr.io/foo/bar:v1.2@sha256:" + strings.Repeat("0123456789abcdef", 4)),
+		want: "0123456789ab",
+	}, {
+		desc: "exactly 12 characters",
+		ref:  rawRef("gcr.io/foo/bar@sha256:0123456789ab"),
+		want: "0123456789ab",
+	}, {
+		desc: "shorter than 12 characters",
+		ref:  rawRef("gcr.io/foo/bar@sha256:beef"),
+		want: "beef",
+	}, {
+		desc: "empty hex",
+		ref:  rawRef("gcr.io/foo/bar@sha256:"),
+		want: "",
+	}} {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := imageRefPart(test.ref, "shortDigest")
+			if err != nil {
+				t.Fatalf("imageRefPart(%v, shortDigest) = %v", test.ref, err)
+			}
+			if got != test.want {
+				t.Errorf("imageRefPart(%v, shortDigest) = %q, want %q", test.ref, got, test.want)
+			}
+		})
+	}
+
+	if _, err := imageRefPart(rawRef("gcr.io/foo/bar:v1.2"), "shortDigest"); err == nil {
+		t.Error("imageRefPart(gcr.io/foo/bar:v1.2, shortDigest) should err, got nil")
+	}
+}
+
+func TestRepositoryPart(t *testing.T) {
+	const hash = "sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef"
+	tests := []struct {
+		desc string
+		ref  name.Reference
+		want string
+	}{{
+		desc: "digest",
+		ref:  rawRef("gcr.io/foo/bar@" + hash),
+		want: "gcr.io/foo/bar",
+	}, {
+		desc: "tag and digest",
+		ref:  rawRef("gcr.io/foo/bar:v1.2@" + hash),
+		want: "gcr.io/foo/bar",
+	}, {
+		desc: "registry with port",
+		ref:  rawRef("localhost:5000/foo/bar:v1.2"),
+		want: "localhost:5000/foo/bar",
+	}, {
+		desc: "trailing slash",
+		ref:  rawRef("gcr.io/foo/@" + hash),
+		want: "gcr.io/foo",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := imageRefPart(test.ref, "repository")
+			if err != nil {
+				t.Fatalf("imageRefPart(%v, repository) = %v", test.ref, err)
+			}
+			if got != test.want {
+				t.Errorf("imageRefPart(%v, repository) = %q, want %q", test.ref, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFullDigestPart(t *testing.T) {
+	const hash = "sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef"
+	for _, test := range []struct {
+		desc string
+		ref  string
+		want string
+	}{{
+		desc: "digest",
+		ref:  "gcr.io/foo/bar@" + hash,
+		want: "gcr.io/foo/bar@" + hash,
+	}, {
+		desc: "tag and digest",
+		ref:  "gcr.io/foo/bar:v1.2@" + hash,
+		want: "gcr.io/foo/bar@" + hash,
+	}, {
+		desc: "registry with port",
+		ref:  "localhost:5000/foo/bar:v1.2@" + hash,
+		want: "localhost:5000/foo/bar@" + hash,
+	}} {
+		t.Run(test.desc, func(t *testing.T) {
+			ref, err := name.ParseReference(test.ref)
+			if err != nil {
+				t.Fatalf("name.ParseReference(%q) = %v", test.ref, err)
+			}
+			got, err := imageRefPart(ref, "fullDigest")
+			if err != nil {
+				t.Fatalf("imageRefPart(%q, fullDigest) = %v", test.ref, err)
+			}
+			if got != test.want {
+				t.Errorf("imageRefPart(%q, fullDigest) = %q, want %q", test.ref, got, test.want)
+			}
+			// The result should be a valid digest reference.
+			if _, err := name.NewDigest(got); err != nil {
+				t.Errorf("name.NewDigest(%q) = %v", got, err)
+			}
+		})
+	}
+}
+
+// digestOnlyPublish is a publish.Interface that always returns a digest-only
+// reference.
+type digestOnlyPublish struct{}
+
+func (digestOnlyPublish) Publish(context.Context, build.Result, string) (name.Reference, error) {
+	return name.NewDigest("gcr.io/project/app@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef")
+}
+
+func (digestOnlyPublish) Close() error { return nil }
+
+func TestTagPart(t *testing.T) {
+	input := map[string]string{"tag": build.StrictScheme + fooRef + "?part=tag"}
+	inputYAML, err := yaml.Marshal(input)
+	if err != nil {
+		t.Fatalf("yaml.Marshal(%v) = %v", input, err)
+	}
+
+	doc := strToYAML(t, string(inputYAML))
+	err = ImageReferences(context.Background(), []*yaml.Node{doc}, testBuilder, digestOnlyPublish{})
+	if err != nil {
+		t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
+	}
+	var outStructured map[string]string
+	if err := doc.Decode(&outStructured); err != nil {
+		t.Errorf("doc.Decode(%v) = %v", yamlToStr(t, doc), err)
+	}
+	if diff := cmp.Diff(map[string]string{"tag": "latest"}, outStructured); diff != "" {
+		t.Errorf("ImageReferences(%v); (-want +got) = %v", string(inputYAML), diff)
+	}
+
+	for ref, want := range map[string]string{
+		"gcr.io/foo/bar:v1.2@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef": "v1.2",
+		"localhost:5000/foo/bar:v1.2": "v1.2",
+		"localhost:5000/foo/bar@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef": "latest",
+	} {
+		got, err := imageRefPart(rawRef(ref), "tag")
+		if err != nil {
+			t.Fatalf("imageRefPart(%q, tag) = %v", ref, err)
+		}
+		if got != want {
+			t.Errorf("imageRefPart(%q, tag) = %q, want %q", ref, got, want)
+		}
+	}
+}